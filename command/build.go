@@ -3,7 +3,12 @@ package command
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	config "github.com/pm-connect/tent/config"
@@ -13,15 +18,43 @@ import (
 // BuildCommand runs the build to prepare the project for deployment.
 type BuildCommand struct {
 	Meta
+
+	environment   string
+	outputs       *buildOutputs
+	provenanceDir string
+
+	// deploying is set when the builds are deployed straight after, as by ship.
+	deploying bool
 }
 
 // Help displays help output for the command.
 func (c *BuildCommand) Help() string {
 	helpText := `
-Usage: tent build
+Usage: tent build [-env=] [-log-dir=] [-outputs=] [-provenance-dir=] [-since=] [-explain]
 
     Build is used to build the project ready for deployment.
 
+    The output of each build is streamed as it runs with -verbose. Without it, the last lines of
+    output are shown when a build fails.
+
+    -env=
+        Apply the build overrides of the given environment.
+
+    -log-dir=
+        Write the full output of each build to {deployment}-{build}.log within the directory.
+
+    -outputs=
+        Write the pushed images and script outputs of each build to the given file, for use with deploy -outputs.
+
+    -provenance-dir=
+        Write how each image was built, and the digest it was pushed with, to {deployment}-{build}.json within the directory.
+
+    -since=
+        Only select the deployments affected by the files changed since the given git ref. (eg, origin/main)
+
+    -explain
+        Show why each deployment was or was not selected by -since, then exit without running.
+
 General Options:
 
     ` + generalOptionsUsage() + `
@@ -39,9 +72,21 @@ func (c *BuildCommand) Name() string { return "build" }
 // Run starts the build procedure.
 func (c *BuildCommand) Run(args []string) int {
 	var verbose bool
+	var environment string
+	var logDir string
+	var outputsFile string
+	var provenanceDir string
+	var since string
+	var explain bool
 
 	flags := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
 	flags.BoolVar(&verbose, "verbose", false, "Turn on verbose output.")
+	flags.StringVar(&environment, "env", "", "Specify the environment whose overrides to apply.")
+	flags.StringVar(&logDir, "log-dir", "", "Write the output of each build to a file within the directory.")
+	flags.StringVar(&outputsFile, "outputs", "", "Write the build outputs to the given file.")
+	flags.StringVar(&provenanceDir, "provenance-dir", "", "Write the provenance of each build to a file within the directory.")
+	flags.StringVar(&since, "since", "", "Only select the deployments changed since the given git ref.")
+	flags.BoolVar(&explain, "explain", false, "Show why each deployment was selected by -since, without running.")
 	err := flags.Parse(args)
 
 	if err != nil {
@@ -49,6 +94,30 @@ func (c *BuildCommand) Run(args []string) int {
 		return 1
 	}
 
+	if len(environment) > 0 {
+		if _, ok := c.Config.Environments[environment]; !ok {
+			c.UI.Error(fmt.Sprintf("Unable to find any environment config for environment: %s", environment))
+			return 1
+		}
+
+		c.Config, _ = c.Config.ForEnvironment(environment)
+	}
+
+	run, err := c.selectChanged(since, explain)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	if !run {
+		return 0
+	}
+
+	c.environment = environment
+	c.outputs = newBuildOutputs(c.Config.Name)
+	c.provenanceDir = provenanceDir
+
 	flags.Args()
 
 	var concurrency int
@@ -65,13 +134,28 @@ func (c *BuildCommand) Run(args []string) int {
 
 	errorCount := 0
 
-	for _, deployment := range c.Config.Deployments {
+	if len(logDir) > 0 {
+		if err := os.MkdirAll(logDir, 0755); err != nil {
+			c.UI.Error(fmt.Sprintf("Unable to create the log directory: %s", err))
+			return 1
+		}
+	}
+
+	if len(provenanceDir) > 0 {
+		if err := os.MkdirAll(provenanceDir, 0755); err != nil {
+			c.UI.Error(fmt.Sprintf("Unable to create the provenance directory: %s", err))
+			return 1
+		}
+	}
+
+	for deploymentName, deployment := range c.Config.Deployments {
 		for key, build := range deployment.Builds {
 			sem <- true
-			go func(key string, build config.Build, verbose bool, errorCount *int) {
+			go func(deploymentName string, key string, build config.Build, verbose bool, errorCount *int) {
 				defer func() { <-sem }()
-				c.build(key, build, verbose, c.makeBuilder(), errorCount)
-			}(key, build, verbose, &errorCount)
+
+				c.runBuild(deploymentName, key, build, verbose, logDir, errorCount)
+			}(deploymentName, key, build, verbose, &errorCount)
 		}
 	}
 
@@ -79,6 +163,13 @@ func (c *BuildCommand) Run(args []string) int {
 		sem <- true
 	}
 
+	if len(outputsFile) > 0 {
+		if err := c.outputs.save(outputsFile); err != nil {
+			c.UI.Error(fmt.Sprintf("Unable to write the build outputs: %s", err))
+			errorCount++
+		}
+	}
+
 	if errorCount > 0 {
 		c.UI.Error("Exiting with errors.")
 		return 1
@@ -87,38 +178,106 @@ func (c *BuildCommand) Run(args []string) int {
 	return 0
 }
 
-// Create a docker builder to use.
-func (c *BuildCommand) makeBuilder() docker.Docker {
-	return new(docker.DefaultDocker)
+// runBuild creates the runner and builder of a build, then runs it.
+func (c *BuildCommand) runBuild(deploymentName string, name string, build config.Build, verbose bool, logDir string, errorCount *int) {
+	runner, closeLog, err := c.makeRunner(deploymentName, name, logDir)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, err))
+		*errorCount++
+		return
+	}
+
+	defer closeLog()
+
+	builder, err := c.makeBuilder(build, runner)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, err))
+		*errorCount++
+		return
+	}
+
+	c.build(deploymentName, name, build, verbose, builder, runner, errorCount)
+}
+
+// Create the builder to use for a build, either the builder of the build or of the config.
+func (c *BuildCommand) makeBuilder(build config.Build, runner docker.Runner) (docker.Docker, error) {
+	builder := build.Builder
+
+	if len(builder) == 0 {
+		builder = c.Config.Builder
+	}
+
+	return docker.NewBuilder(builder, build.Push, runner)
+}
+
+// Create the runner for the commands of a build, writing to a log file within logDir if given.
+//
+// The returned function closes the log file.
+func (c *BuildCommand) makeRunner(deploymentName string, name string, logDir string) (docker.Runner, func(), error) {
+	runner := docker.Runner{Output: c.Redactor.Writer(os.Stdout)}
+
+	if len(logDir) == 0 {
+		return runner, func() {}, nil
+	}
+
+	file, err := os.Create(filepath.Join(logDir, fmt.Sprintf("%s-%s.log", deploymentName, name)))
+
+	if err != nil {
+		return runner, nil, fmt.Errorf("unable to create the build log: %s", err)
+	}
+
+	log := c.Redactor.Writer(file)
+	runner.Log = log
+
+	return runner, func() {
+		log.Flush()
+		file.Close()
+	}, nil
+}
+
+// showFailedOutput shows the last lines of output of a failed command, unless they were already shown.
+func (c *BuildCommand) showFailedOutput(name string, err error, verbose bool) {
+	commandErr, ok := err.(*docker.CommandError)
+
+	if !ok || verbose {
+		return
+	}
+
+	for _, line := range commandErr.Tail {
+		c.UI.Error(fmt.Sprintf("===> [%s]    %s", name, line))
+	}
 }
 
 // Build the configured image and push to the configured tags.
-func (c *BuildCommand) build(name string, build config.Build, verbose bool, builder docker.Docker, errorCount *int) {
+func (c *BuildCommand) build(deploymentName string, name string, build config.Build, verbose bool, builder docker.Docker, runner docker.Runner, errorCount *int) {
 	c.UI.Output(fmt.Sprintf("===> [%s] Starting build.", name))
 
 	if len(build.Script) > 0 {
 		c.UI.Output(fmt.Sprintf("===> [%s] Running build script: %s", name, build.Script))
 
-		args := []string{build.Script}
-
-		cmd := exec.Command("bash", args...)
-
-		out, err := cmd.CombinedOutput()
+		outputs, err := c.runScript(deploymentName, name, build, verbose, runner)
 
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("===> [%s] Error running script %s: %s", name, build.Script, err))
+			c.showFailedOutput(name, err, verbose)
 			*errorCount++
 			return
 		}
 
 		if verbose {
-			lines := strings.Split(string(out), "\n")
-
-			for _, line := range lines {
-				fmt.Println(fmt.Sprintf("===> [%s]    ", name) + line)
+			for _, key := range sortedStringKeys(outputs) {
+				c.UI.Output(fmt.Sprintf("===> [%s] Output %s: %s", name, key, outputs[key]))
 			}
 		}
 
+		if c.outputs != nil {
+			c.outputs.add(deploymentName, name, outputs)
+		}
+
+		c.saveProvenance(deploymentName, name, build, "", nil, outputs["image"], outputs["digest"], errorCount)
+
 		c.UI.Info(fmt.Sprintf("===> [%s] Completed build and push process.", name))
 
 		return
@@ -134,31 +293,219 @@ func (c *BuildCommand) build(name string, build config.Build, verbose bool, buil
 
 	tags := buildTags(build.RegistryURL, build.Name, tagsToBuild)
 
-	err := builder.BuildImage(name, build.Context, tags, build.BuildArgs, build.Target, tags[len(tags)-1], build.File, verbose)
+	if err := builder.Capabilities().Check(len(build.Secrets) > 0, len(build.SSH) > 0); err != nil {
+		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, err))
+		*errorCount++
+		return
+	}
+
+	if build.SSH == docker.DefaultSSHAgent && len(os.Getenv("SSH_AUTH_SOCK")) == 0 {
+		c.UI.Error(fmt.Sprintf("===> [%s] Unable to forward the ssh agent, as SSH_AUTH_SOCK is not set.", name))
+		*errorCount++
+		return
+	}
+
+	templates, err := c.Config.Deployments[deploymentName].Templates.Values()
+
+	if err != nil {
+		c.UI.Warn(fmt.Sprintf("===> [%s] Unable to read the git repository, leaving it out of the image labels: %s", name, err))
+	}
+
+	// Build args may refer to secrets, which are resolved here so that they are also hidden from the output.
+	buildArgs, err := c.Secrets.ResolveMap(build.BuildArgValues())
+
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("===> [%s] Unable to resolve the build args: %s", name, err))
+		*errorCount++
+		return
+	}
+
+	labels := imageLabels(deploymentName, name, build, templates)
+
+	err = builder.BuildImage(name, build.Context, tags, buildArgs, labels, buildSecrets(build), build.SSH, build.Target, tags[len(tags)-1], build.File, verbose)
 
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("===> [%s] Failed building image: %s", name, err))
+		c.showFailedOutput(name, err, verbose)
 		*errorCount++
 		return
 	}
 
 	c.UI.Info(fmt.Sprintf("===> [%s] Finished build.", name))
 
-	if build.Push {
-		for _, tag := range tags {
-			c.UI.Output(fmt.Sprintf("===> [%s] Pushing tag: %s", name, tag))
-			err := builder.PushImage(name, tag, verbose)
+	if !build.Push && !builder.Capabilities().LocalImages {
+		c.UI.Warn(fmt.Sprintf("===> [%s] The %s builder does not keep the images it builds, so the image was only built as a check.", name, builder.Capabilities().Name))
+	}
 
-			if err != nil {
-				c.UI.Error(fmt.Sprintf("===> [%s] Failed pushing the tag %s, did you log in? (docker login)", name, tag))
-				*errorCount++
-			}
+	var digest string
+
+	if build.Push {
+		if c.pushTags(name, tags, builder, verbose, errorCount) {
+			digest = c.recordPushedImage(deploymentName, name, build, tags, builder, errorCount)
 		}
 	}
 
+	image := BuildTag(build.RegistryURL, build.Name, build.DeployTag)
+
+	c.saveProvenance(deploymentName, name, build, builder.Capabilities().Name, tags, image, digest, errorCount)
+
 	c.UI.Info(fmt.Sprintf("===> [%s] Completed build and push process.", name))
 }
 
+// recordPushedImage records the pushed deploy image and its digest as the outputs of the build, so that the
+// exact image is deployed, returning the digest if it was found.
+//
+// A deploy tag that was not pushed is reported, as an error when the build is to be deployed, since the image
+// deployed would not be the one just built.
+func (c *BuildCommand) recordPushedImage(deploymentName string, name string, build config.Build, tags []string, builder docker.Docker, errorCount *int) string {
+	digester, ok := builder.(docker.Digester)
+	image := BuildTag(build.RegistryURL, build.Name, build.DeployTag)
+
+	if !containsString(tags, image) {
+		if c.deploying {
+			c.UI.Error(fmt.Sprintf("===> [%s] The deploy image %s is not one of the pushed tags, add its tag to tags.", name, image))
+			*errorCount++
+		} else {
+			c.UI.Warn(fmt.Sprintf("===> [%s] The deploy image %s is not one of the pushed tags, so deploy will not use this build.", name, image))
+		}
+
+		return ""
+	}
+
+	if (c.outputs == nil && len(c.provenanceDir) == 0) || !ok {
+		return ""
+	}
+
+	digest, err := digester.ImageDigest(name, image)
+
+	if err != nil {
+		c.UI.Warn(fmt.Sprintf("===> [%s] Unable to find the digest of %s: %s", name, image, err))
+		return ""
+	}
+
+	if c.outputs != nil {
+		c.outputs.add(deploymentName, name, map[string]string{"image": image, "digest": digest})
+	}
+
+	return digest
+}
+
+// saveProvenance writes the provenance of a build within the provenance directory, if one was given.
+func (c *BuildCommand) saveProvenance(deploymentName string, name string, build config.Build, builder string, tags []string, image string, digest string, errorCount *int) {
+	if len(c.provenanceDir) == 0 {
+		return
+	}
+
+	// A repository that can not be read has already been reported by the build.
+	templates, _ := c.Config.Deployments[deploymentName].Templates.Values()
+
+	provenance := newProvenance(c.Config.Name, c.environment, deploymentName, name, build, builder, templates)
+	provenance.Tags = tags
+	provenance.Image = image
+	provenance.Digest = digest
+
+	if err := provenance.save(c.provenanceDir, c.Redactor); err != nil {
+		c.UI.Error(fmt.Sprintf("===> [%s] Unable to write the provenance: %s", name, err))
+		*errorCount++
+	}
+}
+
+// runScript runs the script of a build, returning the outputs it wrote to $TENT_OUTPUT.
+func (c *BuildCommand) runScript(deploymentName string, name string, build config.Build, verbose bool, runner docker.Runner) (map[string]string, error) {
+	outputFile, err := ioutil.TempFile("", "tent-output")
+
+	if err != nil {
+		return nil, err
+	}
+
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	env, err := c.Secrets.ResolveMap(build.Env)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range scriptEnvironment(c.Config.Name, c.environment, deploymentName, name, build, outputFile.Name()) {
+		env[key] = value
+	}
+
+	shell := strings.Fields(build.Shell)
+
+	if len(shell) == 0 {
+		shell = []string{"bash"}
+	}
+
+	cmd := exec.Command(shell[0], append(append(shell[1:], build.Script), build.Args...)...)
+	cmd.Dir = build.Workdir
+	cmd.Env = os.Environ()
+
+	for _, key := range sortedStringKeys(env) {
+		cmd.Env = append(cmd.Env, key+"="+env[key])
+	}
+
+	if err := runner.Run(name, cmd, verbose); err != nil {
+		return nil, err
+	}
+
+	outputs, err := readScriptOutputs(outputFile.Name())
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the outputs: %s", err)
+	}
+
+	return outputs, nil
+}
+
+// scriptEnvironment returns the TENT_ variables describing a build to its script.
+func scriptEnvironment(serviceName string, environment string, deploymentName string, name string, build config.Build, outputFile string) map[string]string {
+	tags := build.Tags
+
+	if len(tags) == 0 {
+		tags = []string{"latest"}
+	}
+
+	env := map[string]string{
+		"TENT_NAME":         serviceName,
+		"TENT_ENVIRONMENT":  environment,
+		"TENT_DEPLOYMENT":   deploymentName,
+		"TENT_BUILD_NAME":   name,
+		"TENT_IMAGE_NAME":   build.Name,
+		"TENT_REGISTRY_URL": build.RegistryURL,
+		"TENT_TAGS":         strings.Join(tags, " "),
+		"TENT_DEPLOY_TAG":   build.DeployTag,
+		"TENT_PUSH":         strconv.FormatBool(build.Push),
+		"TENT_OUTPUT":       outputFile,
+	}
+
+	if len(build.Name) > 0 {
+		env["TENT_IMAGES"] = strings.Join(buildTags(build.RegistryURL, build.Name, tags), " ")
+		env["TENT_DEPLOY_IMAGE"] = BuildTag(build.RegistryURL, build.Name, build.DeployTag)
+	}
+
+	return env
+}
+
+// buildSecrets returns the secrets of a build, in order of their ids.
+func buildSecrets(build config.Build) []docker.Secret {
+	ids := []string{}
+
+	for id := range build.Secrets {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	secrets := []docker.Secret{}
+
+	for _, id := range ids {
+		secrets = append(secrets, docker.Secret{ID: id, File: build.Secrets[id].File, Env: build.Secrets[id].Env})
+	}
+
+	return secrets
+}
+
 // BuildTags combines the list of tags into a list of tags including the repository and the image name.
 func buildTags(registryURL string, imageName string, tags []string) []string {
 	completeTags := []string{}
@@ -186,3 +533,13 @@ func BuildTag(registryURL string, imageName string, tag string) string {
 
 	return fmt.Sprintf("%s%s:%s", registryURL, imageName, tag)
 }
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,12 +1,17 @@
 package command
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mitchellh/cli"
 	config "github.com/pm-connect/tent/config"
 	"github.com/pm-connect/tent/docker"
+	"github.com/pm-connect/tent/secret"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -74,7 +79,7 @@ func TestBuildForSingleTag(t *testing.T) {
 		},
 	}
 
-	docker := TestDocker{
+	testDocker := TestDocker{
 		BuildImageCallCount: 0,
 		PushImageCallCount:  0,
 	}
@@ -82,15 +87,17 @@ func TestBuildForSingleTag(t *testing.T) {
 	errorCount := 0
 
 	buildCommand.build(
+		"test",
 		"test",
 		buildCommand.Meta.Config.Deployments["test"].Builds["app"],
 		true,
-		&docker,
+		&testDocker,
+		docker.Runner{},
 		&errorCount,
 	)
 
-	assert.Equal(t, 1, docker.BuildImageCallCount)
-	assert.Equal(t, 1, docker.PushImageCallCount)
+	assert.Equal(t, 1, testDocker.BuildImageCallCount)
+	assert.Equal(t, 1, testDocker.PushImageCallCount)
 	assert.Equal(t, 0, errorCount)
 }
 
@@ -121,7 +128,7 @@ func TestBuildForMultipleTags(t *testing.T) {
 		},
 	}
 
-	docker := TestDocker{
+	testDocker := TestDocker{
 		BuildImageCallCount: 0,
 		PushImageCallCount:  0,
 	}
@@ -129,15 +136,17 @@ func TestBuildForMultipleTags(t *testing.T) {
 	errorCount := 0
 
 	buildCommand.build(
+		"test",
 		"test",
 		buildCommand.Meta.Config.Deployments["test"].Builds["app"],
 		true,
-		&docker,
+		&testDocker,
+		docker.Runner{},
 		&errorCount,
 	)
 
-	assert.Equal(t, 1, docker.BuildImageCallCount)
-	assert.Equal(t, 2, docker.PushImageCallCount)
+	assert.Equal(t, 1, testDocker.BuildImageCallCount)
+	assert.Equal(t, 2, testDocker.PushImageCallCount)
 	assert.Equal(t, 0, errorCount)
 }
 
@@ -168,7 +177,7 @@ func TestBuildForMultipleTagsWithoutPush(t *testing.T) {
 		},
 	}
 
-	docker := TestDocker{
+	testDocker := TestDocker{
 		BuildImageCallCount: 0,
 		PushImageCallCount:  0,
 	}
@@ -176,39 +185,248 @@ func TestBuildForMultipleTagsWithoutPush(t *testing.T) {
 	errorCount := 0
 
 	buildCommand.build(
+		"test",
 		"test",
 		buildCommand.Meta.Config.Deployments["test"].Builds["app"],
 		true,
-		&docker,
+		&testDocker,
+		docker.Runner{},
 		&errorCount,
 	)
 
-	assert.Equal(t, 1, docker.BuildImageCallCount)
-	assert.Equal(t, 0, docker.PushImageCallCount)
+	assert.Equal(t, 1, testDocker.BuildImageCallCount)
+	assert.Equal(t, 0, testDocker.PushImageCallCount)
 	assert.Equal(t, 0, errorCount)
 }
 
 func TestMakeBuilder(t *testing.T) {
 	buildCommand := BuildCommand{}
 
-	d := buildCommand.makeBuilder()
+	d, err := buildCommand.makeBuilder(config.Build{}, docker.Runner{})
 
+	assert.Nil(t, err)
 	assert.IsType(t, new(docker.DefaultDocker), d)
 }
 
+func TestMakeBuilderUsesTheBuilderOfTheBuild(t *testing.T) {
+	buildCommand := BuildCommand{Meta: Meta{Config: config.Config{Builder: "podman"}}}
+
+	d, err := buildCommand.makeBuilder(config.Build{}, docker.Runner{})
+
+	assert.Nil(t, err)
+	assert.IsType(t, new(docker.Podman), d)
+
+	d, err = buildCommand.makeBuilder(config.Build{Builder: "kaniko", Push: true}, docker.Runner{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, true, d.(*docker.Kaniko).Push)
+}
+
+func TestBuildWithoutPushForBuildersThatDoNotKeepImages(t *testing.T) {
+	ui := cli.NewMockUi()
+	buildCommand := BuildCommand{Meta: Meta{UI: ui}}
+
+	builder := TestDocker{Capability: &docker.Capabilities{Name: "kaniko"}}
+	errorCount := 0
+
+	buildCommand.build("test", "test", config.Build{Name: "my-image", Push: false}, false, &builder, docker.Runner{}, &errorCount)
+
+	assert.Equal(t, 1, builder.BuildImageCallCount)
+	assert.Equal(t, 0, builder.PushImageCallCount)
+	assert.Equal(t, 0, errorCount)
+	assert.Contains(t, ui.ErrorWriter.String(), "The kaniko builder does not keep the images it builds, so the image was only built as a check.")
+}
+
+func TestBuildFailsWhenTheBuilderDoesNotSupportSecrets(t *testing.T) {
+	ui := cli.NewMockUi()
+	buildCommand := BuildCommand{Meta: Meta{UI: ui}}
+
+	builder := TestDocker{Capability: &docker.Capabilities{Name: "kaniko"}}
+	errorCount := 0
+
+	build := config.Build{Name: "my-image", Push: true, Secrets: map[string]config.BuildSecret{"npmrc": {File: ".npmrc"}}}
+
+	buildCommand.build("test", "test", build, false, &builder, docker.Runner{}, &errorCount)
+
+	assert.Equal(t, 0, builder.BuildImageCallCount)
+	assert.Equal(t, 1, errorCount)
+	assert.Contains(t, ui.ErrorWriter.String(), "the kaniko builder does not support build secrets")
+}
+
+func TestBuildResolvesSecretBuildArgs(t *testing.T) {
+	ui := cli.NewMockUi()
+	secrets := secret.NewResolver(map[string]secret.Provider{"env": secret.NewMemoryProvider(map[string]string{"NPM_TOKEN": "npm-secret"})})
+	buildCommand := BuildCommand{Meta: Meta{UI: ui, Secrets: secrets}}
+
+	builder := TestDocker{}
+	errorCount := 0
+
+	build := config.Build{Name: "my-image", BuildArgs: map[string]config.BuildArg{
+		"NPM_TOKEN": {Value: "env:NPM_TOKEN"},
+		"VERSION":   {Value: "1.2.0"},
+	}}
+
+	buildCommand.build("test", "test", build, false, &builder, docker.Runner{}, &errorCount)
+
+	assert.Equal(t, 0, errorCount)
+	assert.Equal(t, map[string]string{"NPM_TOKEN": "npm-secret", "VERSION": "1.2.0"}, builder.BuildArgs)
+	assert.Contains(t, secrets.Secrets(), "npm-secret")
+
+	build.BuildArgs["NPM_TOKEN"] = config.BuildArg{Value: "env:MISSING_TOKEN"}
+
+	buildCommand.build("test", "test", build, false, &builder, docker.Runner{}, &errorCount)
+
+	assert.Equal(t, 1, errorCount)
+	assert.Equal(t, 1, builder.BuildImageCallCount)
+	assert.Contains(t, ui.ErrorWriter.String(), "Unable to resolve the build args")
+}
+
+func TestBuildSecrets(t *testing.T) {
+	secrets := buildSecrets(config.Build{Secrets: map[string]config.BuildSecret{
+		"token": {Env: "GITHUB_TOKEN"},
+		"npmrc": {File: "/home/app/.npmrc"},
+	}})
+
+	assert.Equal(t, []docker.Secret{
+		{ID: "npmrc", File: "/home/app/.npmrc"},
+		{ID: "token", Env: "GITHUB_TOKEN"},
+	}, secrets)
+}
+
+func TestBuildRunsScriptsWithTheirEnvironment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tent-build")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "build.sh")
+	ioutil.WriteFile(script, []byte(`
+echo "image=$TENT_REGISTRY_URL/$TENT_BUILD_NAME:$1" >> "$TENT_OUTPUT"
+echo "digest=sha256:abc" >> "$TENT_OUTPUT"
+echo "dir=$(basename $(pwd))" >> "$TENT_OUTPUT"
+echo "stage=$STAGE" >> "$TENT_OUTPUT"
+echo "tags=$TENT_TAGS" >> "$TENT_OUTPUT"
+`), 0755)
+
+	ui := cli.NewMockUi()
+	buildCommand := BuildCommand{Meta: Meta{UI: ui, Config: config.Config{Name: "test"}}, outputs: newBuildOutputs("test")}
+	errorCount := 0
+
+	build := config.Build{
+		RegistryURL: "registry.example.com",
+		Tags:        []string{"v1", "latest"},
+		Script:      script,
+		Shell:       "sh -e",
+		Args:        []string{"v1"},
+		Workdir:     dir,
+		Env:         map[string]string{"STAGE": "staging"},
+	}
+
+	buildCommand.build("web", "app", build, false, &TestDocker{}, docker.Runner{}, &errorCount)
+
+	assert.Equal(t, 0, errorCount, ui.ErrorWriter.String())
+	assert.Equal(t, map[string]string{
+		"image":  "registry.example.com/app:v1",
+		"digest": "sha256:abc",
+		"dir":    filepath.Base(dir),
+		"stage":  "staging",
+		"tags":   "v1 latest",
+	}, buildCommand.outputs.Deployments["web"]["app"])
+}
+
+func TestBuildShowsTheOutputOfFailedScripts(t *testing.T) {
+	ui := cli.NewMockUi()
+	buildCommand := BuildCommand{Meta: Meta{UI: ui}}
+	errorCount := 0
+
+	build := config.Build{Script: "echo failing; exit 3", Shell: "sh -c"}
+
+	buildCommand.build("web", "app", build, false, &TestDocker{}, docker.Runner{}, &errorCount)
+
+	assert.Equal(t, 1, errorCount)
+	assert.Contains(t, ui.ErrorWriter.String(), "===> [app]    failing")
+}
+
+func TestScriptEnvironment(t *testing.T) {
+	env := scriptEnvironment("test", "production", "web", "app", config.Build{RegistryURL: "registry.example.com", Name: "my-image", DeployTag: "v1", Push: true}, "/tmp/output")
+
+	assert.Equal(t, map[string]string{
+		"TENT_NAME":         "test",
+		"TENT_ENVIRONMENT":  "production",
+		"TENT_DEPLOYMENT":   "web",
+		"TENT_BUILD_NAME":   "app",
+		"TENT_IMAGE_NAME":   "my-image",
+		"TENT_REGISTRY_URL": "registry.example.com",
+		"TENT_TAGS":         "latest",
+		"TENT_IMAGES":       "registry.example.com/my-image:latest",
+		"TENT_DEPLOY_TAG":   "v1",
+		"TENT_DEPLOY_IMAGE": "registry.example.com/my-image:v1",
+		"TENT_PUSH":         "true",
+		"TENT_OUTPUT":       "/tmp/output",
+	}, env)
+}
+
 type TestDocker struct {
 	BuildImageCallCount int
 	PushImageCallCount  int
+	Capability          *docker.Capabilities
+	Labels              map[string]string
+	BuildArgs           map[string]string
+	// PushErrors are returned by the first pushes, one per push.
+	PushErrors []error
+
+	lock sync.Mutex
 }
 
-func (b *TestDocker) BuildImage(name string, context string, tags []string, buildArgs map[string]string, target string, cacheFrom string, file string, output bool) error {
+func (b *TestDocker) BuildImage(name string, context string, tags []string, buildArgs map[string]string, labels map[string]string, secrets []docker.Secret, ssh string, target string, cacheFrom string, file string, output bool) error {
 	b.BuildImageCallCount++
+	b.Labels = labels
+	b.BuildArgs = buildArgs
 
 	return nil
 }
 
-func (b *TestDocker) PushImage(name string, image string, output bool) error {
+func (b *TestDocker) Capabilities() docker.Capabilities {
+	if b.Capability != nil {
+		return *b.Capability
+	}
+
+	return docker.Capabilities{Name: "test", LocalImages: true}
+}
+
+func (b *TestDocker) PushImage(name string, image string, output bool) (docker.PushProgress, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	b.PushImageCallCount++
 
-	return nil
+	if len(b.PushErrors) > 0 {
+		err := b.PushErrors[0]
+		b.PushErrors = b.PushErrors[1:]
+
+		return docker.PushProgress{}, err
+	}
+
+	return docker.PushProgress{Layers: 2, ExistingLayers: 1, Elapsed: time.Second}, nil
+}
+
+func TestRecordPushedImageReportsAnUnpushedDeployTag(t *testing.T) {
+	build := config.Build{RegistryURL: "registry.example.com", Name: "app", Tags: []string{"v1"}, DeployTag: "v2", Push: true}
+	tags := buildTags(build.RegistryURL, build.Name, build.Tags)
+
+	ui := cli.NewMockUi()
+	buildCommand := BuildCommand{Meta: Meta{UI: ui}, outputs: newBuildOutputs("app")}
+	errorCount := 0
+
+	buildCommand.recordPushedImage("web", "app", build, tags, &TestDocker{}, &errorCount)
+
+	assert.Equal(t, 0, errorCount)
+	assert.Contains(t, ui.ErrorWriter.String(), "===> [app] The deploy image registry.example.com/app:v2 is not one of the pushed tags, so deploy will not use this build.")
+
+	ui = cli.NewMockUi()
+	buildCommand = BuildCommand{Meta: Meta{UI: ui}, outputs: newBuildOutputs("app"), deploying: true}
+
+	buildCommand.recordPushedImage("web", "app", build, tags, &TestDocker{}, &errorCount)
+
+	assert.Equal(t, 1, errorCount)
+	assert.Contains(t, ui.ErrorWriter.String(), "===> [app] The deploy image registry.example.com/app:v2 is not one of the pushed tags, add its tag to tags.")
 }
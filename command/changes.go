@@ -0,0 +1,281 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	config "github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/git"
+)
+
+// selectChanged keeps only the deployments affected by the files changed since the given git ref, keeping them all
+// when no ref is given.
+//
+// With explain, the reasons each deployment was or was not selected are shown and false is returned, so that
+// nothing is built or deployed.
+func (m *Meta) selectChanged(since string, explain bool) (bool, error) {
+	if len(since) == 0 {
+		if explain {
+			return false, fmt.Errorf("-explain can only be used along with -since")
+		}
+
+		return true, nil
+	}
+
+	changed, err := git.ChangedFiles(".", since)
+
+	if err != nil {
+		return false, fmt.Errorf("unable to find the files changed since %s: %s", since, err)
+	}
+
+	affected := affectedDeployments(m.Config, changed)
+	selected := map[string]config.Deployment{}
+	names := []string{}
+
+	for _, name := range sortedDeploymentNames(m.Config.Deployments) {
+		reasons, ok := affected[name]
+
+		if !ok {
+			if explain {
+				m.UI.Output(fmt.Sprintf("===> [%s] Skipped, nothing it uses changed since %s.", name, since))
+			}
+
+			continue
+		}
+
+		selected[name] = m.Config.Deployments[name]
+		names = append(names, name)
+
+		if explain {
+			m.UI.Output(fmt.Sprintf("===> [%s] Selected, as:", name))
+
+			for _, reason := range reasons {
+				m.UI.Output(fmt.Sprintf("===> [%s]    %s", name, reason))
+			}
+		}
+	}
+
+	if explain {
+		return false, nil
+	}
+
+	m.UI.Output(fmt.Sprintf("===> Selected %d of %d deployments changed since %s: %s", len(names), len(m.Config.Deployments), since, strings.Join(names, ", ")))
+
+	m.Config.Deployments = selected
+
+	return true, nil
+}
+
+// affectedDeployments returns the reasons each deployment is affected by the changed files. Deployments that are
+// not affected are left out.
+//
+// A deployment is affected when the config file, the included config file declaring it, a file matching its paths,
+// its nomad file or one of its builds changes, or when a deployment it depends on is affected.
+func affectedDeployments(conf config.Config, changed []string) map[string][]string {
+	affected := map[string][]string{}
+
+	for _, name := range sortedDeploymentNames(conf.Deployments) {
+		if reasons := deploymentChanges(conf.Name, conf.File, name, conf.Deployments[name], changed); len(reasons) > 0 {
+			affected[name] = reasons
+		}
+	}
+
+	// Dependencies are followed until nothing else is affected, so that they apply transitively.
+	for found := true; found; {
+		found = false
+
+		for _, name := range sortedDeploymentNames(conf.Deployments) {
+			if _, ok := affected[name]; ok {
+				continue
+			}
+
+			for _, dependency := range conf.Deployments[name].DependsOn {
+				if _, ok := affected[dependency]; ok {
+					affected[name] = []string{fmt.Sprintf("it depends on %s, which is selected", dependency)}
+					found = true
+					break
+				}
+			}
+		}
+	}
+
+	return affected
+}
+
+// deploymentChanges describes the changed files used by a deployment. The config file holds the settings shared by
+// every deployment, so each deployment uses it, along with the included file declaring it.
+func deploymentChanges(serviceName string, configFile string, name string, deployment config.Deployment, changed []string) []string {
+	reasons := []string{}
+
+	configFiles := []string{}
+
+	for _, file := range []string{configFile, deployment.File} {
+		if len(file) > 0 && !containsString(configFiles, file) {
+			configFiles = append(configFiles, file)
+		}
+	}
+
+	reasons = append(reasons, pathChanges("config", configFiles, changed)...)
+	reasons = append(reasons, pathChanges("paths", deployment.Paths, changed)...)
+
+	if deployment.Job == nil {
+		nomadFile := generateNomadFileName(deployment.NomadFile, generateJobName(deployment.ServiceName, serviceName, name))
+
+		for _, file := range append([]string{nomadFile}, includedFiles(nomadFile, nil)...) {
+			reasons = append(reasons, pathChanges("nomad file", []string{file}, changed)...)
+		}
+	}
+
+	for _, buildName := range sortedBuildNames(deployment.Builds) {
+		build := deployment.Builds[buildName]
+		prefix := fmt.Sprintf("build %s ", buildName)
+
+		var buildReasons []string
+
+		buildReasons = append(buildReasons, pathChanges("paths", build.Paths, changed)...)
+
+		if len(build.Script) > 0 {
+			buildReasons = append(buildReasons, pathChanges("script", []string{build.Script}, changed)...)
+
+			if len(build.Workdir) > 0 {
+				buildReasons = append(buildReasons, pathChanges("workdir", []string{build.Workdir}, changed)...)
+			}
+		} else {
+			context := build.Context
+
+			// Without a context, the image is built from the working directory, which any change is within.
+			if len(context) == 0 {
+				context = "."
+			}
+
+			buildReasons = append(buildReasons, pathChanges("context", []string{context}, changed)...)
+
+			if len(build.File) > 0 {
+				buildReasons = append(buildReasons, pathChanges("file", []string{build.File}, changed)...)
+			}
+		}
+
+		for _, reason := range buildReasons {
+			reasons = append(reasons, prefix+reason)
+		}
+	}
+
+	return reasons
+}
+
+// pathChanges describes the first changed file matching each of the patterns.
+func pathChanges(kind string, patterns []string, changed []string) []string {
+	reasons := []string{}
+
+	for _, pattern := range patterns {
+		absPattern, err := filepath.Abs(pattern)
+
+		if err != nil {
+			continue
+		}
+
+		for _, file := range changed {
+			if matchPath(absPattern, file) {
+				reasons = append(reasons, fmt.Sprintf("%s %s matches the changed file %s", kind, relativePath(pattern), relativePath(file)))
+				break
+			}
+		}
+	}
+
+	return reasons
+}
+
+// matchPath reports whether the file, or any directory containing it, matches the glob pattern.
+//
+// As well as the wildcards of filepath.Match, ** matches any number of directories.
+func matchPath(pattern string, file string) bool {
+	regex, err := globRegex(pattern)
+
+	if err != nil {
+		return false
+	}
+
+	for path := file; ; path = filepath.Dir(path) {
+		if regex.MatchString(path) {
+			return true
+		}
+
+		if filepath.Dir(path) == path {
+			return false
+		}
+	}
+}
+
+func globRegex(pattern string) (*regexp.Regexp, error) {
+	var out strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			out.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			out.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			out.WriteString("[^/]*")
+		case pattern[i] == '?':
+			out.WriteString("[^/]")
+		default:
+			out.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		}
+	}
+
+	return regexp.Compile("^" + out.String() + "$")
+}
+
+// includedFiles returns the files included by a nomad file, and by the files it includes.
+func includedFiles(path string, stack []string) []string {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil
+	}
+
+	absPath, _ := filepath.Abs(path)
+
+	for _, parent := range stack {
+		if parent == absPath {
+			return nil
+		}
+	}
+
+	files := []string{}
+
+	for _, match := range includeRegex.FindAllStringSubmatch(string(data), -1) {
+		includePath := match[1]
+
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+
+		files = append(files, includePath)
+		files = append(files, includedFiles(includePath, append(stack, absPath))...)
+	}
+
+	return files
+}
+
+// relativePath shortens a path to be relative to the working directory, where possible.
+func relativePath(path string) string {
+	wd, err := os.Getwd()
+
+	if err != nil {
+		return path
+	}
+
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+
+	return path
+}
@@ -0,0 +1,145 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pm-connect/tent/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		file    string
+		match   bool
+	}{
+		{"/repo/api", "/repo/api/main.go", true},
+		{"/repo/api", "/repo/apis/main.go", false},
+		{"/repo/api/*.go", "/repo/api/main.go", true},
+		{"/repo/api/*.go", "/repo/api/handlers/user.go", false},
+		{"/repo/api/**/*.go", "/repo/api/main.go", true},
+		{"/repo/api/**/*.go", "/repo/api/handlers/user.go", true},
+		{"/repo/**/go.mod", "/repo/go.mod", true},
+		{"/repo/**", "/repo/web/index.html", true},
+		{"/repo/web/?.txt", "/repo/web/a.txt", true},
+		{"/repo/web/?.txt", "/repo/web/ab.txt", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.match, matchPath(test.pattern, test.file), "%s against %s", test.pattern, test.file)
+	}
+}
+
+func TestAffectedDeployments(t *testing.T) {
+	conf := config.Config{
+		Name: "app",
+		Deployments: map[string]config.Deployment{
+			"api": {
+				Job:   &config.Job{},
+				Paths: []string{"/repo/shared/**"},
+				Builds: map[string]config.Build{
+					"image": {Context: "/repo/api"},
+				},
+			},
+			"web": {
+				Job: &config.Job{},
+				Builds: map[string]config.Build{
+					"image": {Context: "/repo/web", File: "/repo/docker/web.Dockerfile"},
+				},
+			},
+			"worker": {
+				Job:       &config.Job{},
+				DependsOn: []string{"api"},
+			},
+			"docs": {
+				Job: &config.Job{},
+				Builds: map[string]config.Build{
+					"site": {Script: "/repo/docs/build.sh", Workdir: "/repo/docs", Paths: []string{"/repo/README.md"}},
+				},
+			},
+		},
+	}
+
+	affected := affectedDeployments(conf, []string{"/repo/api/main.go", "/repo/README.md"})
+
+	assert.Equal(t, map[string][]string{
+		"api":    {"build image context /repo/api matches the changed file /repo/api/main.go"},
+		"worker": {"it depends on api, which is selected"},
+		"docs":   {"build site paths /repo/README.md matches the changed file /repo/README.md"},
+	}, affected)
+
+	affected = affectedDeployments(conf, []string{"/repo/shared/lib/util.go", "/repo/docker/web.Dockerfile"})
+
+	assert.Equal(t, map[string][]string{
+		"api":    {"paths /repo/shared/** matches the changed file /repo/shared/lib/util.go"},
+		"web":    {"build image file /repo/docker/web.Dockerfile matches the changed file /repo/docker/web.Dockerfile"},
+		"worker": {"it depends on api, which is selected"},
+	}, affected)
+}
+
+func TestAffectedDeploymentsByConfigFiles(t *testing.T) {
+	conf := config.Config{
+		Name: "app",
+		File: "/repo/tent.yaml",
+		Deployments: map[string]config.Deployment{
+			"api":    {Job: &config.Job{}, File: "/repo/tent.yaml"},
+			"worker": {Job: &config.Job{}, File: "/repo/deployments/worker.yaml"},
+			"cron":   {Job: &config.Job{}, File: "/repo/deployments/cron.yaml"},
+		},
+	}
+
+	affected := affectedDeployments(conf, []string{"/repo/deployments/worker.yaml"})
+
+	assert.Equal(t, map[string][]string{
+		"worker": {"config /repo/deployments/worker.yaml matches the changed file /repo/deployments/worker.yaml"},
+	}, affected)
+
+	affected = affectedDeployments(conf, []string{"/repo/tent.yaml"})
+
+	assert.Equal(t, map[string][]string{
+		"api":    {"config /repo/tent.yaml matches the changed file /repo/tent.yaml"},
+		"worker": {"config /repo/tent.yaml matches the changed file /repo/tent.yaml"},
+		"cron":   {"config /repo/tent.yaml matches the changed file /repo/tent.yaml"},
+	}, affected)
+}
+
+func TestAffectedDeploymentsWithoutABuildContext(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+
+	conf := config.Config{
+		Name: "app",
+		Deployments: map[string]config.Deployment{
+			"web": {
+				Job:    &config.Job{},
+				Builds: map[string]config.Build{"image": {}},
+			},
+		},
+	}
+
+	changed := filepath.Join(wd, "anything", "main.go")
+
+	affected := affectedDeployments(conf, []string{changed})
+
+	assert.Equal(t, map[string][]string{
+		"web": {"build image context . matches the changed file " + filepath.Join("anything", "main.go")},
+	}, affected)
+
+	assert.Empty(t, affectedDeployments(conf, []string{"/elsewhere/main.go"}))
+}
+
+func TestSelectChangedRequiresSinceToExplain(t *testing.T) {
+	meta := Meta{Config: config.Config{}}
+
+	run, err := meta.selectChanged("", false)
+
+	assert.True(t, run)
+	assert.Nil(t, err)
+
+	run, err = meta.selectChanged("", true)
+
+	assert.False(t, run)
+	assert.EqualError(t, err, "-explain can only be used along with -since")
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/mitchellh/cli"
 	config "github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/secret"
 )
 
 func generalOptionsUsage() string {
@@ -20,7 +21,8 @@ func generalOptionsUsage() string {
 // Commands creates all of the possible commands that can be run.
 func Commands(conf config.Config) map[string]cli.CommandFactory {
 	meta := Meta{
-		Config: conf,
+		Config:  conf,
+		Secrets: secret.NewResolver(secret.DefaultProviders()),
 	}
 
 	meta.UI = &cli.BasicUi{
@@ -36,12 +38,34 @@ func Commands(conf config.Config) map[string]cli.CommandFactory {
 		InfoColor:  cli.UiColorGreen,
 	}
 
+	meta.Redactor = newRedactor(conf, meta.Secrets)
+
+	meta.UI = &redactedUI{
+		Ui:       meta.UI,
+		redactor: meta.Redactor,
+	}
+
 	return map[string]cli.CommandFactory{
 		"build": func() (cli.Command, error) {
 			return &BuildCommand{
 				Meta: meta,
 			}, nil
 		},
+		"config": func() (cli.Command, error) {
+			return &ConfigCommand{
+				Meta: meta,
+			}, nil
+		},
+		"config schema": func() (cli.Command, error) {
+			return &ConfigSchemaCommand{
+				Meta: meta,
+			}, nil
+		},
+		"config show": func() (cli.Command, error) {
+			return &ConfigShowCommand{
+				Meta: meta,
+			}, nil
+		},
 		"deploy": func() (cli.Command, error) {
 			return &DeployCommand{
 				Meta: meta,
@@ -52,5 +76,45 @@ func Commands(conf config.Config) map[string]cli.CommandFactory {
 				Meta: meta,
 			}, nil
 		},
+		"generate": func() (cli.Command, error) {
+			return &GenerateCommand{
+				Meta: meta,
+			}, nil
+		},
+		"images": func() (cli.Command, error) {
+			return &ImagesCommand{
+				Meta: meta,
+			}, nil
+		},
+		"images prune": func() (cli.Command, error) {
+			return &ImagesPruneCommand{
+				Meta: meta,
+			}, nil
+		},
+		"lock": func() (cli.Command, error) {
+			return &LockCommand{
+				Meta: meta,
+			}, nil
+		},
+		"lock status": func() (cli.Command, error) {
+			return &LockStatusCommand{
+				Meta: meta,
+			}, nil
+		},
+		"lock release": func() (cli.Command, error) {
+			return &LockReleaseCommand{
+				Meta: meta,
+			}, nil
+		},
+		"ship": func() (cli.Command, error) {
+			return &ShipCommand{
+				Meta: meta,
+			}, nil
+		},
+		"validate": func() (cli.Command, error) {
+			return &ValidateCommand{
+				Meta: meta,
+			}, nil
+		},
 	}
 }
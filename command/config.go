@@ -0,0 +1,240 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/cli"
+	config "github.com/pm-connect/tent/config"
+)
+
+// ConfigCommand groups the config subcommands.
+type ConfigCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *ConfigCommand) Help() string {
+	helpText := `
+Usage: tent config <subcommand> [args]
+
+	Inspect the tent config.
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *ConfigCommand) Synopsis() string { return "Inspect the tent config." }
+
+// Name returns the name of the command.
+func (c *ConfigCommand) Name() string { return "config" }
+
+// Run shows the help for the subcommands.
+func (c *ConfigCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+// ConfigShowCommand prints the effective config for an environment.
+type ConfigShowCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *ConfigShowCommand) Help() string {
+	helpText := `
+Usage: tent config show [-env=]
+
+	Config show prints the config with the overrides of an environment merged in.
+
+	Each value is followed by where it came from, either the config or the environment overrides.
+
+	-env=
+		Specify the environment configuration to use.
+
+General Options:
+
+    ` + generalOptionsUsage() + `
+    `
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *ConfigShowCommand) Synopsis() string { return "Show the effective config for an environment." }
+
+// Name returns the name of the command.
+func (c *ConfigShowCommand) Name() string { return "config show" }
+
+// Run prints the config.
+func (c *ConfigShowCommand) Run(args []string) int {
+	var verbose bool
+	var environment string
+
+	flags := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	flags.BoolVar(&verbose, "verbose", false, "Turn on verbose output.")
+	flags.StringVar(&environment, "env", "production", "Specify the environment to use.")
+	err := flags.Parse(args)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	envConfig, ok := c.Config.Environments[environment]
+
+	if !ok {
+		c.UI.Error(fmt.Sprintf("Unable to find any environment config for environment: %s", environment))
+		return 1
+	}
+
+	merged, sources := c.Config.ForEnvironment(environment)
+
+	// Only the chosen environment is relevant, and its overrides are already merged in.
+	envConfig.Overrides = nil
+	merged.Environments = map[string]config.Environment{environment: envConfig}
+
+	c.UI.Output(strings.Join(describeConfig(merged, sources), "\n"))
+
+	return 0
+}
+
+// ConfigSchemaCommand prints the JSON Schema of the config file.
+type ConfigSchemaCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *ConfigSchemaCommand) Help() string {
+	helpText := `
+Usage: tent config schema
+
+	Config schema prints the JSON Schema of tent.yaml, for use by editors and other tools.
+
+General Options:
+
+    ` + generalOptionsUsage() + `
+    `
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *ConfigSchemaCommand) Synopsis() string { return "Print the JSON Schema of the config file." }
+
+// Name returns the name of the command.
+func (c *ConfigSchemaCommand) Name() string { return "config schema" }
+
+// Run prints the schema.
+func (c *ConfigSchemaCommand) Run(args []string) int {
+	schema, err := config.Schema()
+
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Unable to generate the schema: %s", err))
+		return 1
+	}
+
+	c.UI.Output(string(schema))
+
+	return 0
+}
+
+// describeConfig renders the config as yaml, with the source of each value as a comment.
+func describeConfig(conf config.Config, sources config.Sources) []string {
+	return describeValue(reflect.ValueOf(conf), "", 0, sources)
+}
+
+func describeValue(value reflect.Value, path string, depth int, sources config.Sources) []string {
+	indent := strings.Repeat("  ", depth)
+	lines := []string{}
+
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return lines
+		}
+
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			key := strings.Split(value.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			field := value.Field(i)
+
+			if len(key) == 0 || key == "-" || isEmptyValue(field.Interface()) || reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface()) {
+				continue
+			}
+
+			lines = append(lines, describeEntry(indent, key, field, joinPath(path, key), depth, sources)...)
+		}
+	case reflect.Map:
+		keys := []string{}
+
+		for _, key := range value.MapKeys() {
+			keys = append(keys, key.String())
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			lines = append(lines, describeEntry(indent, key, value.MapIndex(reflect.ValueOf(key)), joinPath(path, key), depth, sources)...)
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			item := value.Index(i)
+
+			if isScalar(item) {
+				lines = append(lines, fmt.Sprintf("%s- %v", indent, item.Interface()))
+				continue
+			}
+
+			nested := describeValue(item, fmt.Sprintf("%s.%d", path, i), depth+1, sources)
+
+			if len(nested) > 0 {
+				nested[0] = indent + "- " + strings.TrimPrefix(nested[0], indent+"  ")
+			}
+
+			lines = append(lines, nested...)
+		}
+	}
+
+	return lines
+}
+
+func describeEntry(indent string, key string, value reflect.Value, path string, depth int, sources config.Sources) []string {
+	// Build args that are not secret are shown as the plain value they are usually given as.
+	if arg, ok := value.Interface().(config.BuildArg); ok && !arg.Secret {
+		value = reflect.ValueOf(arg.Value)
+	}
+
+	if isScalar(value) {
+		return []string{fmt.Sprintf("%s%s: %v  # %s", indent, key, value.Interface(), sources.Source(path))}
+	}
+
+	if value.Kind() == reflect.Slice && value.Len() > 0 && isScalar(value.Index(0)) {
+		return append([]string{fmt.Sprintf("%s%s:  # %s", indent, key, sources.Source(path))}, describeValue(value, path, depth+1, sources)...)
+	}
+
+	return append([]string{fmt.Sprintf("%s%s:", indent, key)}, describeValue(value, path, depth+1, sources)...)
+}
+
+func isScalar(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Ptr, reflect.Interface:
+		return false
+	}
+
+	return true
+}
+
+func joinPath(path string, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+
+	return path + "." + key
+}
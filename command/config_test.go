@@ -0,0 +1,51 @@
+package command
+
+import (
+	"testing"
+
+	config "github.com/pm-connect/tent/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeConfig(t *testing.T) {
+	startInstances := 1
+
+	conf := config.Config{
+		Name: "app",
+		Environments: map[string]config.Environment{
+			"staging": {
+				NomadURL: "http://example.com",
+				Overrides: map[string]config.DeploymentOverride{
+					"web": {StartInstances: &startInstances},
+				},
+			},
+		},
+		Deployments: map[string]config.Deployment{
+			"web": {
+				StartInstances: 3,
+				Builds: map[string]config.Build{
+					"app": {Name: "test", Tags: []string{"latest", "v1"}},
+				},
+			},
+		},
+	}
+
+	merged, sources := conf.ForEnvironment("staging")
+	merged.Environments = map[string]config.Environment{"staging": {NomadURL: "http://example.com"}}
+
+	assert.Equal(t, []string{
+		"name: app  # config",
+		"environments:",
+		"  staging:",
+		"    nomad_url: http://example.com  # config",
+		"deployments:",
+		"  web:",
+		"    builds:",
+		"      app:",
+		"        name: test  # config",
+		"        tags:  # config",
+		"          - latest",
+		"          - v1",
+		"    start_instances: 1  # environments.staging.overrides",
+	}, describeConfig(merged, sources))
+}
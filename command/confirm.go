@@ -0,0 +1,48 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var errNotTerminal = errors.New("refusing to ask for confirmation as stdin is not a terminal, use -yes to confirm")
+
+// stdinIsTerminal reports whether the user is able to answer a prompt.
+var stdinIsTerminal = func() bool {
+	stat, err := os.Stdin.Stat()
+
+	if err != nil {
+		return false
+	}
+
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks the user a yes or no question. The answer may be piped in, eg, echo Y | tent destroy.
+func (m *Meta) confirm(question string) (bool, error) {
+	result, err := m.UI.Ask(fmt.Sprintf("%s [Y|n]", question))
+
+	if err != nil {
+		return false, err
+	}
+
+	return result == "Y" || result == "y", nil
+}
+
+// confirmEnvironment asks the user to type the name of the environment they are about to change. Unlike confirm,
+// the answer must be typed, so that a piped answer can not change a protected environment.
+func (m *Meta) confirmEnvironment(environment string) (bool, error) {
+	if !stdinIsTerminal() {
+		return false, errNotTerminal
+	}
+
+	result, err := m.UI.Ask(fmt.Sprintf("The %s environment is protected. Type the environment name to confirm:", environment))
+
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimSpace(result) == environment, nil
+}
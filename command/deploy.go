@@ -1,18 +1,21 @@
 package command
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	config "github.com/pm-connect/tent/config"
 	nomad "github.com/pm-connect/tent/nomad"
+	"github.com/pm-connect/tent/secret"
 	"github.com/valyala/fasttemplate"
 )
 
@@ -24,17 +27,31 @@ var healthyIsZeroSleep = time.Second * 5
 // DeployCommand runs the build to prepare the project for deployment.
 type DeployCommand struct {
 	Meta
+
+	manifest *deployManifest
 }
 
 // Help displays help output for the command.
 func (c *DeployCommand) Help() string {
 	helpText := `
-Usage: tent deploy [-env=]
+Usage: tent deploy [-env=] [-manifest=] [-outputs=] [-yes] [-lock-timeout=] [-since=] [-explain]
 
 	Deploy is used to build the project ready for deployment.
 	
 	-env=
         Specify the environment configuration to use.
+	-manifest=
+        Write the ids of the deployed jobs to the given file, for use with destroy.
+	-outputs=
+        Use the images reported by build scripts, from a file written by build -outputs.
+	-yes
+        Deploy to a protected environment without asking for confirmation.
+	-lock-timeout=
+        How long to wait for the deploy lock when another run holds it. (eg, 5m)
+	-since=
+        Only select the deployments affected by the files changed since the given git ref. (eg, origin/main)
+	-explain
+        Show why each deployment was or was not selected by -since, then exit without running.
 
 General Options:
 
@@ -54,10 +71,22 @@ func (c *DeployCommand) Name() string { return "deploy" }
 func (c *DeployCommand) Run(args []string) int {
 	var verbose bool
 	var environment string
+	var manifestFile string
+	var outputsFile string
+	var yes bool
+	var lockTimeout time.Duration
+	var since string
+	var explain bool
 
 	flags := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
 	flags.BoolVar(&verbose, "verbose", false, "Turn on verbose output.")
 	flags.StringVar(&environment, "env", "production", "Specify the environment to use.")
+	flags.StringVar(&manifestFile, "manifest", "", "Write the deployed job ids to the given file.")
+	flags.StringVar(&outputsFile, "outputs", "", "Read the build outputs from the given file.")
+	flags.BoolVar(&yes, "yes", false, "Confirm deploying to a protected environment.")
+	flags.DurationVar(&lockTimeout, "lock-timeout", 0, "How long to wait for the deploy lock.")
+	flags.StringVar(&since, "since", "", "Only select the deployments changed since the given git ref.")
+	flags.BoolVar(&explain, "explain", false, "Show why each deployment was selected by -since, without running.")
 	err := flags.Parse(args)
 
 	if err != nil {
@@ -72,6 +101,30 @@ func (c *DeployCommand) Run(args []string) int {
 		return 1
 	}
 
+	c.Config, _ = c.Config.ForEnvironment(environment)
+
+	run, err := c.selectChanged(since, explain)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	if !run {
+		return 0
+	}
+
+	if len(outputsFile) > 0 {
+		outputs, err := loadBuildOutputs(outputsFile)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprint(err))
+			return 1
+		}
+
+		outputs.apply(&c.Config)
+	}
+
 	flags.Args()
 
 	if environment == "production" {
@@ -95,14 +148,67 @@ func (c *DeployCommand) Run(args []string) int {
 		concurrency = 1
 	}
 
-	sem := make(chan bool, concurrency)
+	if len(manifestFile) > 0 {
+		c.manifest = newDeployManifest(c.Config.Name, environment)
+	}
+
+	releaseLocks, err := c.acquireLocks(environment, sortedDeploymentNames(c.Config.Deployments), c.Name(), lockTimeout)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Unable to acquire deploy lock: %s", err))
+		return 1
+	}
+
+	defer releaseLocks()
 
 	errorCount := 0
 
+	plans := map[string]*deployPlan{}
+
+	if envConfig.Protected {
+		for _, name := range sortedDeploymentNames(c.Config.Deployments) {
+			plan := c.plan(name, c.Config.Deployments[name], verbose, &errorCount, nomadClient, envConfig)
+
+			if plan != nil {
+				plans[name] = plan
+			}
+		}
+
+		if errorCount != 0 {
+			c.UI.Error("Unable to plan the deployment.")
+			return errorCount
+		}
+
+		c.showPlans(environment, sortedDeploymentNames(c.Config.Deployments), plans)
+
+		if !yes {
+			confirmed, err := c.confirmEnvironment(environment)
+
+			if err != nil {
+				c.UI.Error(fmt.Sprint(err))
+				return 1
+			}
+
+			if !confirmed {
+				c.UI.Error("Deployment cancelled.")
+				return 1
+			}
+		}
+	}
+
+	sem := make(chan bool, concurrency)
+
 	for name, deployment := range c.Config.Deployments {
 		sem <- true
 		go func(name string, deployment config.Deployment, verbose bool, nomadClient nomad.Client, envConfig config.Environment) {
 			defer func() { <-sem }()
+
+			if plan, ok := plans[name]; ok {
+				c.UI.Output(fmt.Sprintf("===> [%s] Starting deployment.", name))
+				c.submit(plan, verbose, &errorCount, nomadClient)
+				return
+			}
+
 			c.deploy(name, deployment, verbose, &errorCount, nomadClient, envConfig)
 		}(name, deployment, verbose, nomadClient, envConfig)
 	}
@@ -111,6 +217,15 @@ func (c *DeployCommand) Run(args []string) int {
 		sem <- true
 	}
 
+	if c.manifest != nil {
+		err = c.manifest.save(manifestFile)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Unable to write deploy manifest: %s", err))
+			errorCount++
+		}
+	}
+
 	if errorCount != 0 {
 		c.UI.Error("Exiting with errors.")
 		return errorCount
@@ -119,9 +234,35 @@ func (c *DeployCommand) Run(args []string) int {
 	return 0
 }
 
+// showPlans lists the jobs, images and task group count changes that will be deployed to the environment.
+func (c *DeployCommand) showPlans(environment string, names []string, plans map[string]*deployPlan) {
+	c.UI.Output(fmt.Sprintf("The following jobs will be deployed to the %s environment:", environment))
+
+	for _, name := range names {
+		for _, line := range plans[name].describe() {
+			c.UI.Output("    " + line)
+		}
+	}
+}
+
 func (c *DeployCommand) deploy(name string, deployment config.Deployment, verbose bool, errorCount *int, nomadClient nomad.Client, envConfig config.Environment) {
 	c.UI.Output(fmt.Sprintf("===> [%s] Starting deployment.", name))
 
+	plan := c.plan(name, deployment, verbose, errorCount, nomadClient, envConfig)
+
+	if plan == nil {
+		return
+	}
+
+	c.submit(plan, verbose, errorCount, nomadClient)
+}
+
+// plan renders the nomad file for a deployment and works out what submitting it will change.
+func (c *DeployCommand) plan(name string, deployment config.Deployment, verbose bool, errorCount *int, nomadClient nomad.Client, envConfig config.Environment) *deployPlan {
+	if deployment.Job != nil {
+		return c.planGenerated(name, deployment, verbose, errorCount, nomadClient, envConfig)
+	}
+
 	if verbose {
 		c.UI.Output(fmt.Sprintf("===> [%s] Loading nomad file: %s", name, deployment.NomadFile))
 	}
@@ -130,38 +271,38 @@ func (c *DeployCommand) deploy(name string, deployment config.Deployment, verbos
 
 	nomadFile := generateNomadFileName(deployment.NomadFile, jobName)
 
-	nomadFileContents, err := loadNomadFile(nomadFile)
+	nomadFileContents, sources, err := loadNomadFile(nomadFile)
 
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, err))
 		*errorCount++
-		return
+		return nil
 	}
 
 	if verbose {
 		c.UI.Output(fmt.Sprintf("===> [%s] Parsing nomad file and doing variable replacement: %s", name, deployment.NomadFile))
 	}
 
-	parsedFile, err := parseNomadFile(nomadFileContents, c.Config.Name, name, deployment, map[string]int{}, envConfig)
+	parsedFile, err := parseNomadFile(nomadFileContents, c.Config.Name, name, deployment, map[string]int{}, envConfig, c.Secrets)
 
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, err))
+		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, sources.translate(err)))
 		*errorCount++
-		return
+		return nil
 	}
 
 	job, err := nomadClient.ParseJob(parsedFile)
 
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("===> [%s] Error building job spec:\n  %s", name, err))
+		c.UI.Error(fmt.Sprintf("===> [%s] Error building job spec:\n  %s", name, jobSources(deployment, sources).translate(err)))
 		*errorCount++
-		return
+		return nil
 	}
 
 	if len(*job.ID) == 0 {
 		c.UI.Error(fmt.Sprintf("===> [%s] Invalid JobID returned from nomad.", name))
 		*errorCount++
-		return
+		return nil
 	}
 
 	existingJob, err := nomadClient.ReadJob(*job.ID)
@@ -174,12 +315,12 @@ func (c *DeployCommand) deploy(name string, deployment config.Deployment, verbos
 		}
 	}
 
-	parsedFile, err = parseNomadFile(nomadFileContents, c.Config.Name, name, deployment, groupSizes, envConfig)
+	parsedFile, err = parseNomadFile(nomadFileContents, c.Config.Name, name, deployment, groupSizes, envConfig, c.Secrets)
 
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, err))
+		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, sources.translate(err)))
 		*errorCount++
-		return
+		return nil
 	}
 
 	if verbose {
@@ -193,17 +334,25 @@ func (c *DeployCommand) deploy(name string, deployment config.Deployment, verbos
 	job, err = nomadClient.ParseJob(parsedFile)
 
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("===> [%s] Error building job spec:\n  %s", name, err))
+		c.UI.Error(fmt.Sprintf("===> [%s] Error building job spec:\n  %s", name, jobSources(deployment, sources).translate(err)))
 		*errorCount++
-		return
+		return nil
 	}
 
 	if len(*job.ID) == 0 {
 		c.UI.Error(fmt.Sprintf("===> [%s] Invalid JobID returned from nomad.", name))
 		*errorCount++
-		return
+		return nil
 	}
 
+	return newDeployPlan(name, deployment, job, groupSizes)
+}
+
+// submit sends a planned job to nomad and monitors the deployment until it completes.
+func (c *DeployCommand) submit(plan *deployPlan, verbose bool, errorCount *int, nomadClient nomad.Client) {
+	name := plan.Name
+	job := plan.Job
+
 	if verbose {
 		c.UI.Output(fmt.Sprintf("===> [%s] Nomad Job: \n %+v", name, job))
 	}
@@ -220,6 +369,10 @@ func (c *DeployCommand) deploy(name string, deployment config.Deployment, verbos
 
 	c.UI.Info(fmt.Sprintf("===> [%s] Job successfully sent to nomad.", name))
 
+	if c.manifest != nil {
+		c.manifest.add(name, *job.ID)
+	}
+
 	newJob, err := nomadClient.ReadJob(*job.ID)
 
 	if err != nil {
@@ -239,25 +392,14 @@ func (c *DeployCommand) deploy(name string, deployment config.Deployment, verbos
 
 	c.UI.Output(fmt.Sprintf("===> [%s] Monitoring deployment for success.", name))
 
-	eval, err := nomadClient.ReadEvaluation(result.EvalID)
+	err = c.monitorEvaluation(name, result.EvalID, verbose, nomadClient)
 
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("===> [%s] Error reading evaluation for job \"%s\":\n %s", name, c.Config.Name, e))
+		c.UI.Error(fmt.Sprintf("===> [%s] Error reading evaluation for job \"%s\":\n %s", name, c.Config.Name, err))
 		*errorCount++
 		return
 	}
 
-	for eval.Status != "complete" {
-		evalStatus, _ := nomadClient.ReadEvaluation(result.EvalID)
-		eval = evalStatus
-
-		if verbose {
-			c.UI.Warn(fmt.Sprintf("===> [%s] Evaluation Status: %s", name, eval.Status))
-		}
-
-		time.Sleep(evaluationNotCompleteSleep)
-	}
-
 	nomadDeployment, err := nomadClient.GetLatestDeployment(*job.ID)
 
 	if err != nil {
@@ -321,25 +463,127 @@ func (c *DeployCommand) deploy(name string, deployment config.Deployment, verbos
 	}
 }
 
-func loadNomadFile(path string) (string, error) {
+// loadNomadFile reads a nomad file and expands its includes, returning where each of its lines came from.
+func loadNomadFile(path string) (string, sourceLines, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return "", fmt.Errorf("unable to find nomad file: %s", path)
+		return "", nil, fmt.Errorf("unable to find nomad file: %s", path)
 	}
 
 	file, err := ioutil.ReadFile(path)
 
 	if err != nil {
-		return "", fmt.Errorf("unable to load nomad file: %s err: %s", path, err)
+		return "", nil, fmt.Errorf("unable to load nomad file: %s err: %s", path, err)
 	}
 
-	return string(file), nil
+	return expandIncludes(path, string(file), nil)
 }
 
-func parseNomadFile(file string, serviceName string, deploymentName string, deployment config.Deployment, groupSizes map[string]int, environment config.Environment) (string, error) {
+// jobSources returns where the lines of a rendered nomad file came from. A go template can add or remove lines,
+// so its output no longer lines up with the nomad file.
+func jobSources(deployment config.Deployment, sources sourceLines) sourceLines {
+	if deployment.TemplateEngine == "go" {
+		return nil
+	}
+
+	return sources
+}
+
+// parseNomadFile renders a nomad file, replacing the [!variable!] tags.
+//
+// Variables that refer to a secret are only resolved when they are used, and left as they are if secrets is nil.
+func parseNomadFile(file string, serviceName string, deploymentName string, deployment config.Deployment, groupSizes map[string]int, environment config.Environment, secrets *secret.Resolver) (string, error) {
 	template := file
 
+	if deployment.TemplateEngine == "go" {
+		context, err := resolveTemplateContext(newTemplateContext(file, serviceName, deploymentName, deployment, groupSizes, environment), secrets)
+
+		if err != nil {
+			return "", err
+		}
+
+		rendered, err := renderGoTemplate(deploymentName, file, context, false)
+
+		if err != nil {
+			return "", err
+		}
+
+		template = rendered
+	}
+
 	t := fasttemplate.New(template, "[!", "!]")
 
+	context := nomadFileVariables(serviceName, deploymentName, deployment, groupSizes, environment)
+
+	var out bytes.Buffer
+
+	_, err := t.ExecuteFunc(&out, func(w io.Writer, tag string) (int, error) {
+		if context[tag] != "" {
+			value, err := secrets.Resolve(context[tag])
+
+			if err != nil {
+				return 0, fmt.Errorf("[!%s!] %s", tag, err)
+			}
+
+			return w.Write([]byte(value))
+		}
+
+		if strings.HasPrefix(tag, "group_") && strings.HasSuffix(tag, "_size") {
+			group := strings.TrimSuffix(strings.TrimPrefix(tag, "group_"), "_size")
+
+			return w.Write([]byte(strconv.Itoa(groupSize(group, deploymentName, deployment, groupSizes))))
+		}
+
+		if name, ok := templateVariable(tag); ok {
+			value, err := deployment.Templates.Value(name)
+
+			if err != nil {
+				return 0, fmt.Errorf("[!%s!] %s", tag, err)
+			}
+
+			return w.Write([]byte(value))
+		}
+
+		return w.Write([]byte(""))
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// planGenerated plans a deployment whose nomad job is generated from the config rather than a nomad file.
+func (c *DeployCommand) planGenerated(name string, deployment config.Deployment, verbose bool, errorCount *int, nomadClient nomad.Client, envConfig config.Environment) *deployPlan {
+	jobName := generateJobName(deployment.ServiceName, c.Config.Name, name)
+
+	if verbose {
+		c.UI.Output(fmt.Sprintf("===> [%s] Generating nomad job: %s", name, jobName))
+	}
+
+	groupSizes := map[string]int{}
+
+	existingJob, err := nomadClient.ReadJob(jobName)
+
+	if err == nil {
+		for _, group := range existingJob.TaskGroups {
+			groupSizes[*group.Name] = *group.Count
+		}
+	}
+
+	job, err := generateJob(c.Config.Name, name, deployment, groupSizes, envConfig, c.Secrets, false)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("===> [%s] Error generating job spec:\n  %s", name, err))
+		*errorCount++
+		return nil
+	}
+
+	return newDeployPlan(name, deployment, job, groupSizes)
+}
+
+// nomadFileVariables returns the values of the [!variable!] tags available within a nomad file.
+func nomadFileVariables(serviceName string, deploymentName string, deployment config.Deployment, groupSizes map[string]int, environment config.Environment) map[string]string {
 	context := map[string]string{
 		"name":            serviceName,
 		"deployment_name": deploymentName,
@@ -347,7 +591,11 @@ func parseNomadFile(file string, serviceName string, deploymentName string, depl
 	}
 
 	for key, build := range deployment.Builds {
-		context["image_"+key] = BuildTag(build.RegistryURL, build.Name, build.DeployTag)
+		context["image_"+key] = deployImage(build)
+
+		for output, value := range build.Outputs {
+			context["output_"+key+"_"+output] = value
+		}
 	}
 
 	for variable, value := range deployment.Variables {
@@ -362,33 +610,45 @@ func parseNomadFile(file string, serviceName string, deploymentName string, depl
 		context["group_"+group+"_size"] = strconv.Itoa(size)
 	}
 
-	out := t.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
-		if context[tag] != "" {
-			return w.Write([]byte(context[tag]))
-		}
+	return context
+}
 
-		if strings.HasPrefix(tag, "group_") && strings.HasSuffix(tag, "_size") {
-			group := strings.TrimSuffix(strings.TrimPrefix(tag, "group_"), "_size")
+// templateVariable returns the {git.sha} style template that a [!git_sha!] style variable refers to.
+func templateVariable(tag string) (string, bool) {
+	name := strings.Replace(tag, "_", ".", 1)
 
-			if group == "" && groupSizes[deploymentName] != 0 {
-				return w.Write([]byte(strconv.Itoa(groupSizes[deploymentName])))
-			}
+	return name, config.IsTemplateValue(name)
+}
 
-			if groupSizes[group] != 0 {
-				return w.Write([]byte(strconv.Itoa(groupSizes[group])))
-			}
+// groupSize returns the count for a task group, falling back to the configured start instances.
+//
+// An empty group name refers to the group named after the deployment.
+func groupSize(group string, deploymentName string, deployment config.Deployment, groupSizes map[string]int) int {
+	if group == "" {
+		group = deploymentName
+	}
 
-			if deployment.StartInstances > 0 {
-				return w.Write([]byte(strconv.Itoa(deployment.StartInstances)))
-			}
+	if groupSizes[group] != 0 {
+		return groupSizes[group]
+	}
 
-			return w.Write([]byte("2"))
-		}
+	if deployment.StartInstances > 0 {
+		return deployment.StartInstances
+	}
 
-		return w.Write([]byte(""))
-	})
+	return 2
+}
+
+func sortedDeploymentNames(deployments map[string]config.Deployment) []string {
+	names := []string{}
+
+	for name := range deployments {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
 
-	return out, nil
+	return names
 }
 
 func generateJobName(serviceName string, tentName string, deploymentName string) string {
@@ -1,7 +1,11 @@
 package command
 
 import (
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,6 +13,7 @@ import (
 	nomadAPI "github.com/hashicorp/nomad/api"
 	"github.com/mitchellh/cli"
 	"github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/secret"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -32,6 +37,11 @@ func (c *mockNomadClient) ParseJob(hcl string) (*nomadAPI.Job, error) {
 	return args.Get(0).(*nomadAPI.Job), args.Error(1)
 }
 
+func (c *mockNomadClient) ValidateJob(job *nomadAPI.Job) (*nomadAPI.JobValidateResponse, error) {
+	args := c.Called(job)
+	return args.Get(0).(*nomadAPI.JobValidateResponse), args.Error(1)
+}
+
 func (c *mockNomadClient) UpdateJob(job *nomadAPI.Job) (*nomadAPI.JobRegisterResponse, error) {
 	args := c.Called(job)
 	return args.Get(0).(*nomadAPI.JobRegisterResponse), args.Error(1)
@@ -42,9 +52,9 @@ func (c *mockNomadClient) GetLatestDeployment(name string) (*nomadAPI.Deployment
 	return args.Get(0).(*nomadAPI.Deployment), args.Error(1)
 }
 
-func (c *mockNomadClient) StopJob(ID string, purge bool) error {
+func (c *mockNomadClient) StopJob(ID string, purge bool) (string, error) {
 	args := c.Called(ID, purge)
-	return args.Error(0)
+	return args.String(0), args.Error(1)
 }
 
 func (c *mockNomadClient) ReadJob(ID string) (*nomadAPI.Job, error) {
@@ -52,6 +62,31 @@ func (c *mockNomadClient) ReadJob(ID string) (*nomadAPI.Job, error) {
 	return args.Get(0).(*nomadAPI.Job), args.Error(1)
 }
 
+func (c *mockNomadClient) ListJobs(prefix string) ([]*nomadAPI.JobListStub, error) {
+	args := c.Called(prefix)
+	return args.Get(0).([]*nomadAPI.JobListStub), args.Error(1)
+}
+
+func (c *mockNomadClient) ListNamespaceJobs(namespace string) ([]*nomadAPI.JobListStub, error) {
+	args := c.Called(namespace)
+	return args.Get(0).([]*nomadAPI.JobListStub), args.Error(1)
+}
+
+func (c *mockNomadClient) ReadNamespaceJob(namespace string, ID string) (*nomadAPI.Job, error) {
+	args := c.Called(namespace, ID)
+	return args.Get(0).(*nomadAPI.Job), args.Error(1)
+}
+
+func (c *mockNomadClient) ListNamespaces() ([]string, error) {
+	args := c.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (c *mockNomadClient) ReadJobAllocations(ID string) ([]*nomadAPI.AllocationListStub, error) {
+	args := c.Called(ID)
+	return args.Get(0).([]*nomadAPI.AllocationListStub), args.Error(1)
+}
+
 func TestParseNomadFile(t *testing.T) {
 	result, err := parseNomadFile(
 		"job \"[!job_name!]\" { group \"[!name!]\" count = [!group_size!] { task \"[!deployment_name!]\" { config { image = \"[!image_web!]\" } } } }",
@@ -69,6 +104,7 @@ func TestParseNomadFile(t *testing.T) {
 		},
 		map[string]int{},
 		config.Environment{},
+		nil,
 	)
 
 	assert.Nil(t, err)
@@ -91,6 +127,7 @@ func TestParseNomadFileWithoutStartInstances(t *testing.T) {
 		},
 		map[string]int{},
 		config.Environment{},
+		nil,
 	)
 
 	assert.Nil(t, err)
@@ -113,6 +150,7 @@ func TestParseNomadFileWithGroupSizes(t *testing.T) {
 		},
 		map[string]int{"deployment": 4},
 		config.Environment{},
+		nil,
 	)
 
 	assert.Nil(t, err)
@@ -128,14 +166,14 @@ func TestLoadNomadFile(t *testing.T) {
 
 	filet.File(t, "test.nomad", data)
 
-	result, err := loadNomadFile("test.nomad")
+	result, _, err := loadNomadFile("test.nomad")
 
 	assert.Nil(t, err)
 	assert.Equal(t, data, result)
 }
 
 func TestLoadNomadFileWithMissingFile(t *testing.T) {
-	result, err := loadNomadFile("my-file-somewhere.nomad")
+	result, _, err := loadNomadFile("my-file-somewhere.nomad")
 
 	assert.NotNil(t, err)
 	assert.Empty(t, result)
@@ -387,3 +425,444 @@ func TestDeployForJobThatFails(t *testing.T) {
 	nomadClient.AssertExpectations(t)
 	assert.Equal(t, 1, errorCount)
 }
+
+func TestDeployManifest(t *testing.T) {
+	manifest := newDeployManifest("app", "staging")
+	manifest.add("web", "app-web")
+	manifest.add("api", "my-api")
+
+	err := manifest.save("deploy-manifest.json")
+	defer os.Remove("deploy-manifest.json")
+
+	assert.Nil(t, err)
+
+	loaded, err := loadDeployManifest("deploy-manifest.json")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "app", loaded.Name)
+	assert.Equal(t, "staging", loaded.Environment)
+	assert.Equal(t, map[string]string{"web": "app-web", "api": "my-api"}, loaded.Jobs)
+}
+
+func TestBuildOutputs(t *testing.T) {
+	outputs := newBuildOutputs("app")
+	outputs.add("web", "app", map[string]string{"image": "registry.example.com/app:v1", "digest": "sha256:abc"})
+
+	err := outputs.save("build-outputs.json")
+	defer os.Remove("build-outputs.json")
+
+	assert.Nil(t, err)
+
+	loaded, err := loadBuildOutputs("build-outputs.json")
+
+	assert.Nil(t, err)
+
+	conf := config.Config{Deployments: map[string]config.Deployment{
+		"web": {Builds: map[string]config.Build{"app": {Name: "app", DeployTag: "latest"}}},
+	}}
+
+	loaded.apply(&conf)
+
+	result, err := parseNomadFile(
+		"image = \"[!image_app!]\" digest = \"[!output_app_digest!]\"",
+		"service",
+		"web",
+		conf.Deployments["web"],
+		map[string]int{},
+		config.Environment{},
+		nil,
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "image = \"registry.example.com/app:v1@sha256:abc\" digest = \"sha256:abc\"", result)
+}
+
+func TestReadScriptOutputs(t *testing.T) {
+	file, _ := ioutil.TempFile("", "tent-output")
+	defer os.Remove(file.Name())
+
+	file.WriteString("# outputs\nimage = app:v1\n\ndigest=sha256:abc=\n")
+	file.Close()
+
+	outputs, err := readScriptOutputs(file.Name())
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"image": "app:v1", "digest": "sha256:abc="}, outputs)
+
+	ioutil.WriteFile(file.Name(), []byte("image\n"), 0644)
+
+	_, err = readScriptOutputs(file.Name())
+
+	assert.EqualError(t, err, "invalid output on line 1, expected key=value: image")
+}
+
+func TestNewDeployPlan(t *testing.T) {
+	jobID := "app-web"
+	web := "web"
+	webCount := 4
+	worker := "worker"
+
+	plan := newDeployPlan(
+		"web",
+		config.Deployment{
+			Builds: map[string]config.Build{
+				"app": {
+					RegistryURL: "some-registry.com",
+					Name:        "test",
+					DeployTag:   "v2",
+				},
+			},
+		},
+		&nomadAPI.Job{
+			ID: &jobID,
+			TaskGroups: []*nomadAPI.TaskGroup{
+				{Name: &worker},
+				{Name: &web, Count: &webCount},
+			},
+		},
+		map[string]int{"web": 2},
+	)
+
+	assert.Equal(t, []string{
+		"web: app-web",
+		"    image app: some-registry.com/test:v2",
+		"    group web: 2 -> 4",
+		"    group worker: new -> 1",
+	}, plan.describe())
+}
+
+func TestConfirmEnvironment(t *testing.T) {
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+
+	stdinIsTerminal = func() bool { return true }
+
+	ui := cli.NewMockUi()
+	ui.InputReader = strings.NewReader("production\n")
+
+	meta := Meta{UI: ui}
+
+	confirmed, err := meta.confirmEnvironment("production")
+
+	assert.Nil(t, err)
+	assert.True(t, confirmed)
+
+	ui.InputReader = strings.NewReader("y\n")
+
+	confirmed, err = meta.confirmEnvironment("production")
+
+	assert.Nil(t, err)
+	assert.False(t, confirmed)
+}
+
+func TestConfirmAcceptsPipedAnswers(t *testing.T) {
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+
+	stdinIsTerminal = func() bool { return false }
+
+	ui := cli.NewMockUi()
+	ui.InputReader = strings.NewReader("Y\n")
+
+	meta := Meta{UI: ui}
+
+	confirmed, err := meta.confirm("Are you sure?")
+
+	assert.Nil(t, err)
+	assert.True(t, confirmed)
+}
+
+func TestConfirmEnvironmentWithoutTerminal(t *testing.T) {
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+
+	stdinIsTerminal = func() bool { return false }
+
+	meta := Meta{UI: cli.NewMockUi()}
+
+	confirmed, err := meta.confirmEnvironment("production")
+
+	assert.Equal(t, errNotTerminal, err)
+	assert.False(t, confirmed)
+}
+
+func TestParseNomadFileResolvesSecrets(t *testing.T) {
+	memory := secret.NewMemoryProvider(map[string]string{
+		"secret/data/app#password": "hunter2",
+		"secret/data/app#unused":   "unused",
+	})
+	secrets := secret.NewResolver(map[string]secret.Provider{"vault": memory})
+
+	deployment := config.Deployment{
+		Variables: map[string]string{
+			"password": "vault:secret/data/app#password",
+			"unused":   "vault:secret/data/app#unused",
+		},
+	}
+
+	result, err := parseNomadFile(`env { PASSWORD = "[!var_password!]" }`, "service", "deployment", deployment, map[string]int{}, config.Environment{}, secrets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `env { PASSWORD = "hunter2" }`, result)
+	assert.Equal(t, 0, memory.Resolved["secret/data/app#unused"])
+
+	result, err = parseNomadFile(`env { PASSWORD = "[!var_password!]" }`, "service", "deployment", deployment, map[string]int{}, config.Environment{}, nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `env { PASSWORD = "vault:secret/data/app#password" }`, result)
+
+	deployment.TemplateEngine = "go"
+
+	result, err = parseNomadFile(`env { PASSWORD = "[[ .Vars.password ]]" }`, "service", "deployment", deployment, map[string]int{}, config.Environment{}, secrets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `env { PASSWORD = "hunter2" }`, result)
+}
+
+func TestParseNomadFileWithMissingSecret(t *testing.T) {
+	secrets := secret.NewResolver(map[string]secret.Provider{"vault": secret.NewMemoryProvider(map[string]string{})})
+
+	_, err := parseNomadFile(`env { PASSWORD = "[!env_password!]" }`, "service", "deployment", config.Deployment{}, map[string]int{}, config.Environment{
+		Variables: map[string]string{"password": "vault:secret/data/app#password"},
+	}, secrets)
+
+	assert.EqualError(t, err, "[!env_password!] unable to resolve secret vault:secret/data/app#password: secret secret/data/app#password does not exist")
+}
+
+func TestParseNomadFileWithGoTemplateEngine(t *testing.T) {
+	result, err := parseNomadFile(
+		`job "[[ .JobName ]]" {
+  group "api" {
+    count = [[ .Groups.api.Count ]]
+    task "web" { config { image = "[[ .Images.web ]]" } }
+[[- if eq (default "production" .Env.Vars.stage) "staging" ]]
+    task "sidecar" { env { VARS = [[ toJson .Vars ]] } }
+[[- end ]]
+    template { data = "{{ key \"app\" }}" }
+  }
+  meta { deployment = "[!deployment_name!]" }
+}`,
+		"service",
+		"deployment",
+		config.Deployment{
+			Builds: map[string]config.Build{
+				"web": {
+					RegistryURL: "some-registry.com",
+					Name:        "test",
+					DeployTag:   "latest",
+				},
+			},
+			Variables:      map[string]string{"port": "80"},
+			StartInstances: 3,
+			TemplateEngine: "go",
+		},
+		map[string]int{},
+		config.Environment{Variables: map[string]string{"stage": "staging"}},
+		nil,
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `job "service-deployment" {
+  group "api" {
+    count = 3
+    task "web" { config { image = "some-registry.com/test:latest" } }
+    task "sidecar" { env { VARS = {"port":"80"} } }
+    template { data = "{{ key \"app\" }}" }
+  }
+  meta { deployment = "deployment" }
+}`, result)
+}
+
+func TestParseNomadFileWithTagTemplates(t *testing.T) {
+	result, err := parseNomadFile(
+		`meta { sha = "[!git_short_sha!][!git_dirty!]" branch = "[[ .Git.Branch ]]" built = "[!timestamp!]" }`,
+		"service",
+		"deployment",
+		config.Deployment{
+			TemplateEngine: "go",
+			Templates: config.NewTemplates(map[string]string{
+				"git.short_sha": "abc1234",
+				"git.branch":    "feature-login",
+				"git.dirty":     "-dirty",
+				"timestamp":     "20190801120000",
+			}),
+		},
+		map[string]int{},
+		config.Environment{},
+		nil,
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `meta { sha = "abc1234-dirty" branch = "feature-login" built = "20190801120000" }`, result)
+}
+
+func TestParseNomadFileWithInvalidGoTemplate(t *testing.T) {
+	_, err := parseNomadFile(
+		`job "[[ .Unknown ]]" {}`,
+		"service",
+		"deployment",
+		config.Deployment{TemplateEngine: "go"},
+		map[string]int{},
+		config.Environment{},
+		nil,
+	)
+
+	assert.NotNil(t, err)
+}
+
+func TestParseNomadFileWithGoTemplateEngineMissingKey(t *testing.T) {
+	result, err := parseNomadFile(
+		`job "x" { meta { region = "[[ .Vars.missing ]]" dc = "[[ default "dc1" .Env.Vars.missing ]]" } }`,
+		"service",
+		"deployment",
+		config.Deployment{TemplateEngine: "go"},
+		map[string]int{},
+		config.Environment{},
+		nil,
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `job "x" { meta { region = "" dc = "dc1" } }`, result)
+}
+
+func TestParseNomadFileWithGoTemplateEngineRequiredValue(t *testing.T) {
+	_, err := parseNomadFile(
+		`job "x" { datacenters = ["[[ required "dc variable is required" .Env.Vars.dc ]]"] }`,
+		"service",
+		"deployment",
+		config.Deployment{TemplateEngine: "go"},
+		map[string]int{},
+		config.Environment{},
+		nil,
+	)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "dc variable is required")
+}
+
+func TestTemplateHelpers(t *testing.T) {
+	assert.Equal(t, "fallback", templateDefault("fallback", ""))
+	assert.Equal(t, "value", templateDefault("fallback", "value"))
+	assert.Equal(t, 2, templateDefault(2, 0))
+	assert.Equal(t, "  a\n  b", templateIndent(2, "a\nb"))
+}
+
+func TestLoadNomadFileWithIncludes(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+	os.MkdirAll(filepath.Join(dir, "partials"), 0755)
+
+	filet.File(t, filepath.Join(dir, "partials/service.hcl"), `service {
+  name = "[!arg_name!]"
+  port = "[!arg_port!]"
+  [!include "check.hcl"!]
+}
+`)
+	filet.File(t, filepath.Join(dir, "partials/check.hcl"), `check { name = "[!job_name!] alive" }`)
+	filet.File(t, filepath.Join(dir, "test.nomad"), `job "test" {
+  [!include "partials/service.hcl" name="web" port="http"!]
+}
+`)
+
+	result, sources, err := loadNomadFile(filepath.Join(dir, "test.nomad"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, `job "test" {
+  service {
+  name = "web"
+  port = "http"
+  check { name = "[!job_name!] alive" }
+}
+}
+`, result)
+
+	nomadFile := filepath.Join(dir, "test.nomad")
+	service := filepath.Join(dir, "partials/service.hcl")
+
+	assert.Equal(t, sourceLines{
+		nomadFile + ":1",
+		service + ":1",
+		service + ":2",
+		service + ":3",
+		filepath.Join(dir, "partials/check.hcl") + ":1",
+		service + ":5",
+		nomadFile + ":3",
+	}, sources)
+}
+
+func TestIncludedFileErrorsReportTheIncludedLine(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+
+	filet.File(t, filepath.Join(dir, "broken.hcl"), "group \"web\" {\n  count = [[ .Size ]\n}\n")
+	filet.File(t, filepath.Join(dir, "test.nomad"), "job \"test\" {\n\n  [!include \"broken.hcl\"!]\n}\n")
+
+	contents, sources, err := loadNomadFile(filepath.Join(dir, "test.nomad"))
+
+	assert.Nil(t, err)
+
+	_, err = parseNomadFile(contents, "service", "web", config.Deployment{TemplateEngine: "go"}, map[string]int{}, config.Environment{}, nil)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, sources.translate(err).Error(), "line 4 is "+filepath.Join(dir, "broken.hcl")+":2")
+
+	err = sources.translate(errors.New("At 5:1: expected: IDENT | STRING got: RBRACE"))
+
+	assert.Equal(t, "At 5:1: expected: IDENT | STRING got: RBRACE (line 5 is "+filepath.Join(dir, "broken.hcl")+":3)", err.Error())
+}
+
+func TestLoadNomadFileWithIncludeCycle(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+
+	filet.File(t, filepath.Join(dir, "a.hcl"), `[!include "b.hcl"!]`)
+	filet.File(t, filepath.Join(dir, "b.hcl"), `[!include "a.hcl"!]`)
+	filet.File(t, filepath.Join(dir, "test.nomad"), "job \"test\" {\n  [!include \"a.hcl\"!]\n}\n")
+
+	_, _, err := loadNomadFile(filepath.Join(dir, "test.nomad"))
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), filepath.Join(dir, "test.nomad")+":2")
+	assert.Contains(t, err.Error(), "include cycle detected")
+}
+
+func TestLoadNomadFileWithMissingInclude(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+
+	filet.File(t, filepath.Join(dir, "test.nomad"), "job \"test\" {\n\n  [!include \"missing.hcl\"!]\n}\n")
+
+	_, _, err := loadNomadFile(filepath.Join(dir, "test.nomad"))
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), filepath.Join(dir, "test.nomad")+":3")
+}
+
+func TestParseNomadFileFailsWhenGitCanNotBeRead(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+
+	c, err := config.LoadFromFile(filet.TmpFile(t, dir, `
+name: test
+environments:
+  production:
+    nomad_url: http://example.com/prod
+deployments:
+  web:
+    template_engine: go
+`).Name())
+
+	assert.Nil(t, err)
+
+	_, err = parseNomadFile(`meta { sha = "[!git_sha!]" }`, "service", "web", c.Deployments["web"], map[string]int{}, config.Environment{}, nil)
+
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "[!git_sha!] unable to resolve {git.sha}: "), err.Error())
+
+	_, err = parseNomadFile(`meta { branch = "[[ .Git.Branch ]]" }`, "service", "web", c.Deployments["web"], map[string]int{}, config.Environment{}, nil)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unable to resolve {git.branch}: ")
+}
@@ -3,9 +3,10 @@ package command
 import (
 	"flag"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
-	config "github.com/pm-connect/tent/config"
 	nomad "github.com/pm-connect/tent/nomad"
 )
 
@@ -17,14 +18,31 @@ type DestroyCommand struct {
 // Help displays help output for the command.
 func (c *DestroyCommand) Help() string {
 	helpText := `
-Usage: tent destroy [-env=] [-purge] [-force]
+Usage: tent destroy [-env=] [-purge] [-detach] [-stop-timeout=] [-force] [-yes] [-manifest=] [-prefix=] [-lock-timeout=]
 
-	Destroy is used to build the project ready for deployment.
+	Destroy is used to stop the deployed jobs within nomad.
+
+	By default the jobs to stop are worked out from the deployments in the config,
+	without loading the nomad files.
 	
 	-env=
 		Specify the environment configuration to use.
 	-purge
-		Forces garbage collection of the job within nomad.
+		Garbage collects the job within nomad once all of its allocations have stopped.
+	-detach
+		Return as soon as the job has been stopped, without waiting for its allocations.
+	-stop-timeout=
+		How long to wait for the allocations to stop, defaults to 10m.
+	-force
+		Do not ask for confirmation, unless the environment is protected.
+	-yes
+		Do not ask for confirmation, even if the environment is protected.
+	-manifest=
+		Stop the jobs listed in a manifest written by deploy.
+	-prefix=
+		Stop every job in nomad whose id starts with the given prefix.
+	-lock-timeout=
+		How long to wait for the deploy lock when another run holds it. (eg, 5m)
 
 General Options:
 
@@ -38,20 +56,40 @@ General Options:
 func (c *DestroyCommand) Synopsis() string { return "Destroy the project according to the config." }
 
 // Name returns the name of the command.
-func (c *DestroyCommand) Name() string { return "build" }
+func (c *DestroyCommand) Name() string { return "destroy" }
+
+// destroyTarget is a single job to be stopped.
+type destroyTarget struct {
+	Name   string
+	JobID  string
+	Status string
+	Groups []groupChange
+}
 
 // Run starts the build procedure.
 func (c *DestroyCommand) Run(args []string) int {
 	var verbose bool
 	var environment string
 	var purge bool
+	var detach bool
+	var stopTimeout time.Duration
 	var force bool
+	var yes bool
+	var manifestFile string
+	var prefix string
+	var lockTimeout time.Duration
 
 	flags := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
 	flags.BoolVar(&verbose, "verbose", false, "Turn on verbose output.")
-	flags.BoolVar(&purge, "purge", false, "Purge the job on nomad immediately.")
+	flags.BoolVar(&purge, "purge", false, "Purge the job on nomad once it has stopped.")
+	flags.BoolVar(&detach, "detach", false, "Do not wait for the job to stop.")
+	flags.DurationVar(&stopTimeout, "stop-timeout", defaultStopTimeout, "How long to wait for the allocations to stop.")
 	flags.BoolVar(&force, "force", false, "Force the descruction and to not ask for confirmation.")
+	flags.BoolVar(&yes, "yes", false, "Do not ask for confirmation, even for protected environments.")
 	flags.StringVar(&environment, "env", "production", "Specify the environment to use.")
+	flags.StringVar(&manifestFile, "manifest", "", "Stop the jobs listed in a deploy manifest.")
+	flags.StringVar(&prefix, "prefix", "", "Stop the jobs whose id starts with the given prefix.")
+	flags.DurationVar(&lockTimeout, "lock-timeout", 0, "How long to wait for the deploy lock.")
 	err := flags.Parse(args)
 
 	if err != nil {
@@ -66,29 +104,80 @@ func (c *DestroyCommand) Run(args []string) int {
 		return 1
 	}
 
+	c.Config, _ = c.Config.ForEnvironment(environment)
+
 	flags.Args()
 
 	if environment == "production" {
 		c.UI.Warn("You are running using the Production environment!")
 	}
 
-	if !force {
-		result, _ := c.UI.Ask("Are you sure? [Y|n]")
+	nomadURL := generateNomadURL(envConfig.NomadURL)
+
+	nomadClient, err := nomad.NewDefaultClient(nomadURL, 5)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	targets, err := c.resolveTargets(manifestFile, prefix, nomadClient)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	if len(targets) == 0 {
+		c.UI.Output("No jobs found to stop.")
+		return 0
+	}
+
+	c.UI.Output(fmt.Sprintf("The following jobs will be stopped in the %s environment:", environment))
 
-		if result != "Y" && result != "y" {
-			return 0
+	for _, target := range targets {
+		c.UI.Output(fmt.Sprintf("    %s: %s (%s)", target.Name, target.JobID, target.Status))
+
+		for _, group := range target.Groups {
+			c.UI.Output("        " + group.describe())
 		}
 	}
 
-	nomadURL := generateNomadURL(envConfig.NomadURL)
+	var confirmed bool
 
-	nomadClient, err := nomad.NewDefaultClient(nomadURL, 5)
+	if yes || (force && !envConfig.Protected) {
+		confirmed = true
+	} else if envConfig.Protected {
+		confirmed, err = c.confirmEnvironment(environment)
+	} else {
+		confirmed, err = c.confirm("Are you sure?")
+	}
 
 	if err != nil {
 		c.UI.Error(fmt.Sprint(err))
 		return 1
 	}
 
+	if !confirmed {
+		return 0
+	}
+
+	jobIDs := []string{}
+
+	for _, target := range targets {
+		jobIDs = append(jobIDs, target.JobID)
+	}
+
+	// The same locks as deploy are taken, so a job is never stopped while it is being deployed.
+	releaseLocks, err := c.acquireLockKeys(c.jobLockKeys(environment, jobIDs), c.Name(), lockTimeout)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Unable to acquire deploy lock: %s", err))
+		return 1
+	}
+
+	defer releaseLocks()
+
 	var concurrency int
 
 	if c.Config.Concurrent {
@@ -101,12 +190,12 @@ func (c *DestroyCommand) Run(args []string) int {
 
 	errorCount := 0
 
-	for name, deployment := range c.Config.Deployments {
+	for _, target := range targets {
 		sem <- true
-		go func(name string, deployment config.Deployment, verbose bool, errorCount *int, nomadClient nomad.Client) {
+		go func(target destroyTarget, verbose bool, errorCount *int, nomadClient nomad.Client) {
 			defer func() { <-sem }()
-			c.destroy(name, deployment, envConfig, purge, verbose, errorCount, nomadClient)
-		}(name, deployment, verbose, &errorCount, nomadClient)
+			c.destroy(target.Name, target.JobID, purge, detach, stopTimeout, verbose, errorCount, nomadClient)
+		}(target, verbose, &errorCount, nomadClient)
 	}
 
 	for i := 0; i < cap(sem); i++ {
@@ -121,68 +210,139 @@ func (c *DestroyCommand) Run(args []string) int {
 	return 0
 }
 
-func (c *DestroyCommand) destroy(name string, deployment config.Deployment, environment config.Environment, purge bool, verbose bool, errorCount *int, nomadClient nomad.Client) {
-	c.UI.Output(fmt.Sprintf("===> [%s] Starting destruction process.", name))
+// resolveTargets works out which jobs should be stopped and which of them exist in nomad.
+//
+// Jobs are taken from a nomad prefix search, a deploy manifest or the configured deployments, in that order.
+func (c *DestroyCommand) resolveTargets(manifestFile string, prefix string, nomadClient nomad.Client) ([]destroyTarget, error) {
+	targets := []destroyTarget{}
+
+	if len(prefix) > 0 {
+		jobs, err := nomadClient.ListJobs(prefix)
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to search for jobs with prefix %s: %s", prefix, err)
+		}
 
-	if verbose {
-		c.UI.Output(fmt.Sprintf("===> [%s] Loading nomad file: %s.", name, deployment.NomadFile))
+		for _, job := range jobs {
+			targets = append(targets, destroyTarget{Name: job.ID, JobID: job.ID, Status: job.Status})
+		}
+
+		sortDestroyTargets(targets)
+
+		return targets, nil
 	}
 
-	jobName := generateJobName(deployment.ServiceName, c.Config.Name, name)
+	jobIDs := map[string]string{}
 
-	existingJob, err := nomadClient.ReadJob(jobName)
+	if len(manifestFile) > 0 {
+		manifest, err := loadDeployManifest(manifestFile)
 
-	groupSizes := map[string]int{}
+		if err != nil {
+			return nil, err
+		}
 
-	if err == nil {
-		for _, group := range existingJob.TaskGroups {
-			groupSizes[*group.Name] = *group.Count
+		jobIDs = manifest.Jobs
+	} else {
+		for name, deployment := range c.Config.Deployments {
+			jobIDs[name] = generateJobName(deployment.ServiceName, c.Config.Name, name)
 		}
 	}
 
-	nomadFile := generateNomadFileName(deployment.NomadFile, jobName)
+	for name, jobID := range jobIDs {
+		job, err := nomadClient.ReadJob(jobID)
 
-	nomadFileContents, err := loadNomadFile(nomadFile)
+		// Only a job nomad does not have is skipped, any other error means the job may still be running.
+		if err != nil && !nomad.IsNotFound(err) {
+			return nil, fmt.Errorf("unable to read the job %s: %s", jobID, err)
+		}
 
-	if err != nil {
-		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, err))
-		*errorCount++
-		return
-	}
+		if err != nil || job == nil {
+			c.UI.Warn(fmt.Sprintf("===> [%s] Job %s not found, skipping.", name, jobID))
+			continue
+		}
+
+		status := "unknown"
+
+		if job.Status != nil {
+			status = *job.Status
+		}
+
+		target := destroyTarget{Name: name, JobID: jobID, Status: status}
 
-	if verbose {
-		c.UI.Output(fmt.Sprintf("===> [%s] Parsing nomad file and doing variable replacement: %s.", name, deployment.NomadFile))
+		for _, group := range job.TaskGroups {
+			if group.Name == nil || group.Count == nil {
+				continue
+			}
+
+			target.Groups = append(target.Groups, groupChange{Name: *group.Name, Current: *group.Count, Existing: true})
+		}
+
+		targets = append(targets, target)
 	}
 
-	parsedFile, err := parseNomadFile(nomadFileContents, c.Config.Name, name, deployment, groupSizes, environment)
+	sortDestroyTargets(targets)
+
+	return targets, nil
+}
+
+func sortDestroyTargets(targets []destroyTarget) {
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Name < targets[j].Name
+	})
+}
+
+func (c *DestroyCommand) destroy(name string, jobID string, purge bool, detach bool, stopTimeout time.Duration, verbose bool, errorCount *int, nomadClient nomad.Client) {
+	c.UI.Output(fmt.Sprintf("===> [%s] Starting destruction process.", name))
+
+	c.UI.Output(fmt.Sprintf("===> [%s] Stopping job.", name))
+
+	// When detached there is nothing to wait for, so the job is purged straight away.
+	evalID, err := nomadClient.StopJob(jobID, purge && detach)
 
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, err))
+		c.UI.Error(fmt.Sprintf("===> [%s] Error stopping job %s: %s", name, jobID, err))
 		*errorCount++
 		return
 	}
 
-	if verbose {
-		c.UI.Output(fmt.Sprintf("===> [%s] Converting job file to json for job: %s.", name, c.Config.Name))
+	if detach {
+		c.UI.Info(fmt.Sprintf("===> [%s] Job stop submitted for job: %s", name, jobID))
+		return
 	}
 
-	job, err := nomadClient.ParseJob(parsedFile)
+	if len(evalID) > 0 {
+		c.UI.Output(fmt.Sprintf("===> [%s] Monitoring evaluation: %s", name, evalID))
 
-	if err != nil {
-		c.UI.Error(fmt.Sprintf("===> [%s] %s", name, err))
-		*errorCount++
-		return
+		err = c.monitorEvaluation(name, evalID, verbose, nomadClient)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("===> [%s] Error monitoring evaluation for job %s: %s", name, jobID, err))
+			*errorCount++
+			return
+		}
 	}
 
-	c.UI.Output(fmt.Sprintf("===> [%s] Stopping job.", name))
+	c.UI.Output(fmt.Sprintf("===> [%s] Waiting for allocations to stop.", name))
 
-	err = nomadClient.StopJob(*job.ID, false)
+	err = c.waitForAllocationsToStop(name, jobID, stopTimeout, verbose, nomadClient)
 
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("===> [%s] Error stopping job %s: %s", name, *job.ID, err))
+		c.UI.Error(fmt.Sprintf("===> [%s] Error waiting for allocations of job %s to stop: %s", name, jobID, err))
 		*errorCount++
 		return
 	}
 
-	c.UI.Info(fmt.Sprintf("===> [%s] Successfully stopped job: %s", name, *job.ID))
+	c.UI.Info(fmt.Sprintf("===> [%s] Successfully stopped job: %s", name, jobID))
+
+	if purge {
+		_, err = nomadClient.StopJob(jobID, true)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("===> [%s] Error purging job %s: %s", name, jobID, err))
+			*errorCount++
+			return
+		}
+
+		c.UI.Info(fmt.Sprintf("===> [%s] Successfully purged job: %s", name, jobID))
+	}
 }
@@ -0,0 +1,233 @@
+package command
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Flaque/filet"
+	nomadAPI "github.com/hashicorp/nomad/api"
+	"github.com/mitchellh/cli"
+	"github.com/pm-connect/tent/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestDestroyCommand() DestroyCommand {
+	return DestroyCommand{
+		Meta: Meta{
+			UI: &cli.BasicUi{
+				Reader:      os.Stdin,
+				Writer:      os.Stdout,
+				ErrorWriter: os.Stderr,
+			},
+			Config: config.Config{
+				Name: "app",
+				Deployments: map[string]config.Deployment{
+					"test": {
+						NomadFile: "missing.nomad",
+					},
+					"worker": {
+						ServiceName: "my-worker",
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	destroyCommand := newTestDestroyCommand()
+
+	nomadClient := new(mockNomadClient)
+
+	expectedJobID := "app-test"
+
+	nomadClient.On("StopJob", expectedJobID, false).Return("eval-id", nil).Once()
+	nomadClient.On("ReadEvaluation", "eval-id").Return(&nomadAPI.Evaluation{Status: "pending"}, nil).Once()
+	nomadClient.On("ReadEvaluation", "eval-id").Return(&nomadAPI.Evaluation{Status: "complete"}, nil).Once()
+	nomadClient.On("ReadJobAllocations", expectedJobID).Return([]*nomadAPI.AllocationListStub{
+		{ClientStatus: "running"},
+		{ClientStatus: "complete"},
+	}, nil).Once()
+	nomadClient.On("ReadJobAllocations", expectedJobID).Return([]*nomadAPI.AllocationListStub{
+		{ClientStatus: "complete"},
+		{ClientStatus: "complete"},
+	}, nil).Once()
+
+	evaluationNotCompleteSleep = time.Millisecond * 1
+	allocationsRunningSleep = time.Millisecond * 1
+
+	var errorCount int
+
+	destroyCommand.destroy("test", expectedJobID, false, false, time.Minute, true, &errorCount, nomadClient)
+
+	nomadClient.AssertExpectations(t)
+	assert.Equal(t, 0, errorCount)
+}
+
+func TestDestroyWithPurge(t *testing.T) {
+	destroyCommand := newTestDestroyCommand()
+
+	nomadClient := new(mockNomadClient)
+
+	expectedJobID := "app-test"
+
+	nomadClient.On("StopJob", expectedJobID, false).Return("eval-id", nil).Once()
+	nomadClient.On("ReadEvaluation", "eval-id").Return(&nomadAPI.Evaluation{Status: "complete"}, nil).Once()
+	nomadClient.On("ReadJobAllocations", expectedJobID).Return([]*nomadAPI.AllocationListStub{}, nil).Once()
+	nomadClient.On("StopJob", expectedJobID, true).Return("", nil).Once()
+
+	var errorCount int
+
+	destroyCommand.destroy("test", expectedJobID, true, false, time.Minute, true, &errorCount, nomadClient)
+
+	nomadClient.AssertExpectations(t)
+	assert.Equal(t, 0, errorCount)
+}
+
+func TestDestroyWithDetach(t *testing.T) {
+	destroyCommand := newTestDestroyCommand()
+
+	nomadClient := new(mockNomadClient)
+
+	expectedJobID := "app-test"
+
+	nomadClient.On("StopJob", expectedJobID, true).Return("eval-id", nil).Once()
+
+	var errorCount int
+
+	destroyCommand.destroy("test", expectedJobID, true, true, time.Minute, true, &errorCount, nomadClient)
+
+	nomadClient.AssertExpectations(t)
+	nomadClient.AssertNotCalled(t, "ReadEvaluation", mock.Anything)
+	assert.Equal(t, 0, errorCount)
+}
+
+func TestDestroyWithFailedEvaluation(t *testing.T) {
+	destroyCommand := newTestDestroyCommand()
+
+	nomadClient := new(mockNomadClient)
+
+	expectedJobID := "app-test"
+
+	nomadClient.On("StopJob", expectedJobID, false).Return("eval-id", nil).Once()
+	nomadClient.On("ReadEvaluation", "eval-id").Return(&nomadAPI.Evaluation{Status: "failed"}, nil).Once()
+
+	var errorCount int
+
+	destroyCommand.destroy("test", expectedJobID, false, false, time.Minute, true, &errorCount, nomadClient)
+
+	nomadClient.AssertExpectations(t)
+	assert.Equal(t, 1, errorCount)
+}
+
+func TestDestroyGivesUpWhenAllocationsDoNotStop(t *testing.T) {
+	destroyCommand := newTestDestroyCommand()
+
+	nomadClient := new(mockNomadClient)
+
+	expectedJobID := "app-test"
+
+	nomadClient.On("StopJob", expectedJobID, false).Return("", nil).Once()
+	nomadClient.On("ReadJobAllocations", expectedJobID).Return([]*nomadAPI.AllocationListStub{
+		{ClientStatus: "running"},
+	}, nil)
+
+	allocationsRunningSleep = time.Millisecond * 1
+
+	var errorCount int
+
+	destroyCommand.destroy("test", expectedJobID, false, false, time.Millisecond*5, true, &errorCount, nomadClient)
+
+	assert.Equal(t, 1, errorCount)
+}
+
+func TestMonitorEvaluationGivesUpOnErrors(t *testing.T) {
+	meta := Meta{UI: cli.NewMockUi()}
+
+	nomadClient := new(mockNomadClient)
+
+	nomadClient.On("ReadEvaluation", "eval-id").Return(&nomadAPI.Evaluation{Status: "pending"}, nil).Once()
+	nomadClient.On("ReadEvaluation", "eval-id").Return(&nomadAPI.Evaluation{}, assert.AnError)
+
+	evaluationNotCompleteSleep = time.Millisecond * 1
+
+	err := meta.monitorEvaluation("test", "eval-id", false, nomadClient)
+
+	assert.EqualError(t, err, "unable to read evaluation eval-id after 6 attempts: "+assert.AnError.Error())
+	nomadClient.AssertNumberOfCalls(t, "ReadEvaluation", 7)
+}
+
+func TestResolveTargetsFromConfig(t *testing.T) {
+	destroyCommand := newTestDestroyCommand()
+
+	nomadClient := new(mockNomadClient)
+
+	running := "running"
+
+	nomadClient.On("ReadJob", "app-test").Return(&nomadAPI.Job{Status: &running}, nil).Once()
+	nomadClient.On("ReadJob", "my-worker").Return((*nomadAPI.Job)(nil), errors.New("Unexpected response code: 404 (job not found)")).Once()
+
+	targets, err := destroyCommand.resolveTargets("", "", nomadClient)
+
+	nomadClient.AssertExpectations(t)
+	assert.Nil(t, err)
+	assert.Equal(t, []destroyTarget{{Name: "test", JobID: "app-test", Status: "running"}}, targets)
+}
+
+func TestResolveTargetsFailsWhenNomadDoes(t *testing.T) {
+	destroyCommand := newTestDestroyCommand()
+
+	nomadClient := new(mockNomadClient)
+
+	running := "running"
+
+	nomadClient.On("ReadJob", "app-test").Return(&nomadAPI.Job{Status: &running}, nil)
+	nomadClient.On("ReadJob", "my-worker").Return((*nomadAPI.Job)(nil), errors.New("Unexpected response code: 403 (Permission denied)")).Once()
+
+	_, err := destroyCommand.resolveTargets("", "", nomadClient)
+
+	assert.EqualError(t, err, "unable to read the job my-worker: Unexpected response code: 403 (Permission denied)")
+}
+
+func TestResolveTargetsFromManifest(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	destroyCommand := newTestDestroyCommand()
+
+	filet.File(t, "deploy.json", `{"name": "app", "environment": "staging", "jobs": {"api": "custom-api"}}`)
+
+	nomadClient := new(mockNomadClient)
+
+	running := "running"
+
+	nomadClient.On("ReadJob", "custom-api").Return(&nomadAPI.Job{Status: &running}, nil).Once()
+
+	targets, err := destroyCommand.resolveTargets("deploy.json", "", nomadClient)
+
+	nomadClient.AssertExpectations(t)
+	assert.Nil(t, err)
+	assert.Equal(t, []destroyTarget{{Name: "api", JobID: "custom-api", Status: "running"}}, targets)
+}
+
+func TestResolveTargetsFromPrefix(t *testing.T) {
+	destroyCommand := newTestDestroyCommand()
+
+	nomadClient := new(mockNomadClient)
+
+	nomadClient.On("ListJobs", "my-service-").Return([]*nomadAPI.JobListStub{
+		{ID: "my-service-web", Status: "running"},
+		{ID: "my-service-api", Status: "pending"},
+	}, nil).Once()
+
+	targets, err := destroyCommand.resolveTargets("", "my-service-", nomadClient)
+
+	nomadClient.AssertExpectations(t)
+	assert.Nil(t, err)
+	assert.Equal(t, []destroyTarget{
+		{Name: "my-service-api", JobID: "my-service-api", Status: "pending"},
+		{Name: "my-service-web", JobID: "my-service-web", Status: "running"},
+	}, targets)
+}
@@ -0,0 +1,528 @@
+package command
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	nomadAPI "github.com/hashicorp/nomad/api"
+	config "github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/secret"
+)
+
+// GenerateCommand writes nomad files for deployments configured with a job section.
+type GenerateCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *GenerateCommand) Help() string {
+	helpText := `
+Usage: tent generate [-env=] [-force] [-stdout] [deployments...]
+
+	Generate writes a nomad file for each deployment with a job section in the config.
+
+	The file is written to the deployment's nomad_file, or {job_name}.nomad if none is set.
+
+	-env=
+		Specify the environment whose overrides to apply.
+	-force
+		Overwrite existing nomad files.
+	-stdout
+		Print the nomad files instead of writing them.
+
+General Options:
+
+    ` + generalOptionsUsage() + `
+    `
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *GenerateCommand) Synopsis() string { return "Generate nomad files from the config." }
+
+// Name returns the name of the command.
+func (c *GenerateCommand) Name() string { return "generate" }
+
+// Run generates the nomad files.
+func (c *GenerateCommand) Run(args []string) int {
+	var verbose bool
+	var environment string
+	var force bool
+	var stdout bool
+
+	flags := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	flags.BoolVar(&verbose, "verbose", false, "Turn on verbose output.")
+	flags.StringVar(&environment, "env", "production", "Specify the environment to use.")
+	flags.BoolVar(&force, "force", false, "Overwrite existing nomad files.")
+	flags.BoolVar(&stdout, "stdout", false, "Print the nomad files instead of writing them.")
+	err := flags.Parse(args)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	envConfig, ok := c.Config.Environments[environment]
+
+	if !ok {
+		c.UI.Error(fmt.Sprintf("Unable to find any environment config for environment: %s", environment))
+		return 1
+	}
+
+	c.Config, _ = c.Config.ForEnvironment(environment)
+
+	deployments := flags.Args()
+
+	if len(deployments) == 0 {
+		deployments = sortedDeploymentNames(c.Config.Deployments)
+	}
+
+	errorCount := 0
+
+	for _, name := range deployments {
+		deployment, ok := c.Config.Deployments[name]
+
+		if !ok {
+			c.UI.Error(fmt.Sprintf("===> [%s] Unknown deployment.", name))
+			errorCount++
+			continue
+		}
+
+		if deployment.Job == nil {
+			if verbose {
+				c.UI.Output(fmt.Sprintf("===> [%s] No job configured, skipping.", name))
+			}
+
+			continue
+		}
+
+		// The file is rendered by tent on each deploy, so the images, counts and env values are left as
+		// variables rather than the values of a single environment.
+		job, err := generateJob(c.Config.Name, name, deployment, map[string]int{}, envConfig, c.Secrets, true)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("===> [%s] %s", name, err))
+			errorCount++
+			continue
+		}
+
+		hcl := generateHCL(job, groupSizePlaceholders(deployment.Job))
+
+		if stdout {
+			c.UI.Output(hcl)
+			continue
+		}
+
+		nomadFile := generateNomadFileName(deployment.NomadFile, *job.ID)
+
+		if _, err := os.Stat(nomadFile); err == nil && !force {
+			c.UI.Error(fmt.Sprintf("===> [%s] Nomad file already exists, use -force to overwrite: %s", name, nomadFile))
+			errorCount++
+			continue
+		}
+
+		err = ioutil.WriteFile(nomadFile, []byte(hcl), 0644)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("===> [%s] Unable to write nomad file: %s", name, err))
+			errorCount++
+			continue
+		}
+
+		c.UI.Info(fmt.Sprintf("===> [%s] Generated nomad file: %s", name, nomadFile))
+	}
+
+	if errorCount > 0 {
+		c.UI.Error("Exiting with errors.")
+		return 1
+	}
+
+	return 0
+}
+
+// generateJob builds a nomad job from the job section of a deployment.
+//
+// With placeholders, the job is generated to be written out as a nomad file: builds are given as [!image_x!] and
+// env values are left unrendered, so the file works in every environment. A secret reference can not be written
+// out, as it would reach nomad as is.
+func generateJob(serviceName string, deploymentName string, deployment config.Deployment, groupSizes map[string]int, environment config.Environment, secrets *secret.Resolver, placeholders bool) (*nomadAPI.Job, error) {
+	spec := deployment.Job
+	jobName := generateJobName(deployment.ServiceName, serviceName, deploymentName)
+
+	jobType := spec.Type
+
+	if len(jobType) == 0 {
+		jobType = "service"
+	}
+
+	job := &nomadAPI.Job{
+		ID:          &jobName,
+		Name:        &jobName,
+		Type:        &jobType,
+		Datacenters: spec.Datacenters,
+	}
+
+	groupNames := []string{}
+
+	for name := range spec.Groups {
+		groupNames = append(groupNames, name)
+	}
+
+	sort.Strings(groupNames)
+
+	for _, groupName := range groupNames {
+		group := spec.Groups[groupName]
+
+		count := groupSizes[groupName]
+
+		if count == 0 && group.Count != nil {
+			count = *group.Count
+		} else if count == 0 {
+			count = groupSize(groupName, deploymentName, deployment, groupSizes)
+		}
+
+		taskGroup := nomadAPI.NewTaskGroup(groupName, count)
+
+		taskNames := []string{}
+
+		for name := range group.Tasks {
+			taskNames = append(taskNames, name)
+		}
+
+		sort.Strings(taskNames)
+
+		for _, taskName := range taskNames {
+			task, err := generateTask(taskName, group.Tasks[taskName], serviceName, deploymentName, deployment, groupSizes, environment, secrets, placeholders)
+
+			if err != nil {
+				return nil, fmt.Errorf("group %s: %s", groupName, err)
+			}
+
+			taskGroup.Tasks = append(taskGroup.Tasks, task)
+		}
+
+		job.TaskGroups = append(job.TaskGroups, taskGroup)
+	}
+
+	return job, nil
+}
+
+func generateTask(name string, spec config.Task, serviceName string, deploymentName string, deployment config.Deployment, groupSizes map[string]int, environment config.Environment, secrets *secret.Resolver, placeholders bool) (*nomadAPI.Task, error) {
+	task := nomadAPI.NewTask(name, "docker")
+
+	image := spec.Image
+
+	if len(spec.Build) > 0 {
+		build, ok := deployment.Builds[spec.Build]
+
+		if !ok {
+			return nil, fmt.Errorf("task %s: unknown build %s", name, spec.Build)
+		}
+
+		image = deployImage(build)
+
+		if placeholders {
+			image = fmt.Sprintf("[!image_%s!]", spec.Build)
+		}
+	}
+
+	task.Config = map[string]interface{}{"image": image}
+
+	if len(spec.Env) > 0 {
+		task.Env = map[string]string{}
+
+		// Environment values may use the same variables as a nomad file, or refer to a secret.
+		for key, value := range spec.Env {
+			if placeholders {
+				if secrets.IsReference(value) {
+					return nil, fmt.Errorf("task %s env %s: refers to a secret, which can not be written to a nomad file, declare it as a variable and use [!var_name!] instead", name, key)
+				}
+
+				task.Env[key] = value
+				continue
+			}
+
+			rendered, err := parseNomadFile(value, serviceName, deploymentName, deployment, groupSizes, environment, secrets)
+
+			if err == nil {
+				rendered, err = secrets.Resolve(rendered)
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("task %s env %s: %s", name, key, err)
+			}
+
+			task.Env[key] = rendered
+		}
+	}
+
+	resources := &nomadAPI.Resources{}
+
+	if spec.Resources.CPU > 0 {
+		cpu := spec.Resources.CPU
+		resources.CPU = &cpu
+	}
+
+	if spec.Resources.Memory > 0 {
+		memory := spec.Resources.Memory
+		resources.MemoryMB = &memory
+	}
+
+	if len(spec.Ports) > 0 {
+		portMap := map[string]interface{}{}
+		network := &nomadAPI.NetworkResource{}
+
+		for _, label := range sortedKeys(spec.Ports) {
+			network.DynamicPorts = append(network.DynamicPorts, nomadAPI.Port{Label: label})
+
+			if spec.Ports[label] > 0 {
+				portMap[label] = spec.Ports[label]
+			}
+		}
+
+		resources.Networks = []*nomadAPI.NetworkResource{network}
+
+		if len(portMap) > 0 {
+			task.Config["port_map"] = []map[string]interface{}{portMap}
+		}
+	}
+
+	task.Resources = resources
+
+	for _, serviceSpec := range spec.Services {
+		service := &nomadAPI.Service{
+			Name:      serviceSpec.Name,
+			PortLabel: serviceSpec.Port,
+			Tags:      serviceSpec.Tags,
+		}
+
+		for _, checkSpec := range serviceSpec.Checks {
+			check := nomadAPI.ServiceCheck{
+				Name: checkSpec.Name,
+				Type: checkSpec.Type,
+				Path: checkSpec.Path,
+			}
+
+			check.Interval, _ = parseOptionalDuration(checkSpec.Interval, time.Second*10)
+			check.Timeout, _ = parseOptionalDuration(checkSpec.Timeout, time.Second*2)
+
+			service.Checks = append(service.Checks, check)
+		}
+
+		task.Services = append(task.Services, service)
+	}
+
+	return task, nil
+}
+
+func parseOptionalDuration(value string, def time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := []string{}
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// groupSizePlaceholders returns [!group_x_size!] for each group without a count, so that the size is worked out on
+// each deploy, as it is for a job generated by deploy.
+func groupSizePlaceholders(spec *config.Job) map[string]string {
+	placeholders := map[string]string{}
+
+	for name, group := range spec.Groups {
+		if group.Count == nil {
+			placeholders[name] = fmt.Sprintf("[!group_%s_size!]", name)
+		}
+	}
+
+	return placeholders
+}
+
+// generateHCL writes a generated job out as a nomad file, with the count of the given groups written as is.
+//
+// Only the parts of a job that generateJob sets are written.
+func generateHCL(job *nomadAPI.Job, counts map[string]string) string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "job %s {\n", strconv.Quote(*job.ID))
+	fmt.Fprintf(&b, "  datacenters = %s\n", hclList(job.Datacenters))
+	fmt.Fprintf(&b, "  type = %s\n", strconv.Quote(*job.Type))
+
+	for _, group := range job.TaskGroups {
+		fmt.Fprintf(&b, "\n  group %s {\n", strconv.Quote(*group.Name))
+		if count, ok := counts[*group.Name]; ok {
+			fmt.Fprintf(&b, "    count = %s\n", count)
+		} else {
+			fmt.Fprintf(&b, "    count = %d\n", *group.Count)
+		}
+
+		for _, task := range group.Tasks {
+			fmt.Fprintf(&b, "\n    task %s {\n", strconv.Quote(task.Name))
+			fmt.Fprintf(&b, "      driver = %s\n", strconv.Quote(task.Driver))
+
+			fmt.Fprintf(&b, "\n      config {\n")
+			fmt.Fprintf(&b, "        image = %s\n", strconv.Quote(task.Config["image"].(string)))
+
+			if portMaps, ok := task.Config["port_map"].([]map[string]interface{}); ok {
+				fmt.Fprintf(&b, "\n        port_map {\n")
+
+				for _, label := range sortedInterfaceKeys(portMaps[0]) {
+					fmt.Fprintf(&b, "          %s = %v\n", label, portMaps[0][label])
+				}
+
+				fmt.Fprintf(&b, "        }\n")
+			}
+
+			fmt.Fprintf(&b, "      }\n")
+
+			if len(task.Env) > 0 {
+				fmt.Fprintf(&b, "\n      env {\n")
+
+				keys := []string{}
+
+				for key := range task.Env {
+					keys = append(keys, key)
+				}
+
+				sort.Strings(keys)
+
+				for _, key := range keys {
+					fmt.Fprintf(&b, "        %s = %s\n", strconv.Quote(key), strconv.Quote(task.Env[key]))
+				}
+
+				fmt.Fprintf(&b, "      }\n")
+			}
+
+			writeResourcesHCL(&b, task.Resources)
+
+			for _, service := range task.Services {
+				writeServiceHCL(&b, service)
+			}
+
+			fmt.Fprintf(&b, "    }\n")
+		}
+
+		fmt.Fprintf(&b, "  }\n")
+	}
+
+	fmt.Fprintf(&b, "}\n")
+
+	return b.String()
+}
+
+func writeResourcesHCL(b *bytes.Buffer, resources *nomadAPI.Resources) {
+	if resources == nil || (resources.CPU == nil && resources.MemoryMB == nil && len(resources.Networks) == 0) {
+		return
+	}
+
+	fmt.Fprintf(b, "\n      resources {\n")
+
+	if resources.CPU != nil {
+		fmt.Fprintf(b, "        cpu = %d\n", *resources.CPU)
+	}
+
+	if resources.MemoryMB != nil {
+		fmt.Fprintf(b, "        memory = %d\n", *resources.MemoryMB)
+	}
+
+	for _, network := range resources.Networks {
+		fmt.Fprintf(b, "\n        network {\n")
+
+		for _, port := range network.DynamicPorts {
+			fmt.Fprintf(b, "          port %s {}\n", strconv.Quote(port.Label))
+		}
+
+		fmt.Fprintf(b, "        }\n")
+	}
+
+	fmt.Fprintf(b, "      }\n")
+}
+
+func writeServiceHCL(b *bytes.Buffer, service *nomadAPI.Service) {
+	fmt.Fprintf(b, "\n      service {\n")
+
+	if len(service.Name) > 0 {
+		fmt.Fprintf(b, "        name = %s\n", strconv.Quote(service.Name))
+	}
+
+	if len(service.PortLabel) > 0 {
+		fmt.Fprintf(b, "        port = %s\n", strconv.Quote(service.PortLabel))
+	}
+
+	if len(service.Tags) > 0 {
+		fmt.Fprintf(b, "        tags = %s\n", hclList(service.Tags))
+	}
+
+	for _, check := range service.Checks {
+		fmt.Fprintf(b, "\n        check {\n")
+
+		if len(check.Name) > 0 {
+			fmt.Fprintf(b, "          name = %s\n", strconv.Quote(check.Name))
+		}
+
+		fmt.Fprintf(b, "          type = %s\n", strconv.Quote(check.Type))
+
+		if len(check.Path) > 0 {
+			fmt.Fprintf(b, "          path = %s\n", strconv.Quote(check.Path))
+		}
+
+		fmt.Fprintf(b, "          interval = %s\n", strconv.Quote(check.Interval.String()))
+		fmt.Fprintf(b, "          timeout = %s\n", strconv.Quote(check.Timeout.String()))
+		fmt.Fprintf(b, "        }\n")
+	}
+
+	fmt.Fprintf(b, "      }\n")
+}
+
+func hclList(values []string) string {
+	quoted := []string{}
+
+	for _, value := range values {
+		quoted = append(quoted, strconv.Quote(value))
+	}
+
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func sortedInterfaceKeys(m map[string]interface{}) []string {
+	keys := []string{}
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := []string{}
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
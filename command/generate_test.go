@@ -0,0 +1,179 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	config "github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func generateTestDeployment() config.Deployment {
+	count := 3
+
+	return config.Deployment{
+		Builds: map[string]config.Build{
+			"app": {
+				RegistryURL: "some-registry.com",
+				Name:        "test",
+				DeployTag:   "v2",
+			},
+		},
+		Variables: map[string]string{"log_level": "info"},
+		Job: &config.Job{
+			Datacenters: []string{"dc1"},
+			Groups: map[string]config.Group{
+				"web": {
+					Count: &count,
+					Tasks: map[string]config.Task{
+						"app": {
+							Build:     "app",
+							Env:       map[string]string{"LOG_LEVEL": "[!var_log_level!]"},
+							Resources: config.Resources{CPU: 200, Memory: 256},
+							Ports:     map[string]int{"http": 8080},
+							Services: []config.Service{
+								{
+									Name: "app-web",
+									Port: "http",
+									Checks: []config.Check{
+										{Type: "http", Path: "/health", Interval: "5s"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestGenerateJob(t *testing.T) {
+	job, err := generateJob("app", "web", generateTestDeployment(), map[string]int{}, config.Environment{}, nil, false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "app-web", *job.ID)
+	assert.Equal(t, "service", *job.Type)
+	assert.Equal(t, []string{"dc1"}, job.Datacenters)
+	assert.Len(t, job.TaskGroups, 1)
+	assert.Equal(t, 3, *job.TaskGroups[0].Count)
+
+	task := job.TaskGroups[0].Tasks[0]
+
+	assert.Equal(t, "docker", task.Driver)
+	assert.Equal(t, "some-registry.com/test:v2", task.Config["image"])
+	assert.Equal(t, "info", task.Env["LOG_LEVEL"])
+	assert.Equal(t, 200, *task.Resources.CPU)
+	assert.Equal(t, 256, *task.Resources.MemoryMB)
+	assert.Equal(t, "http", task.Resources.Networks[0].DynamicPorts[0].Label)
+	assert.Equal(t, time.Second*5, task.Services[0].Checks[0].Interval)
+	assert.Equal(t, time.Second*2, task.Services[0].Checks[0].Timeout)
+}
+
+func TestGenerateJobKeepsExistingGroupSizes(t *testing.T) {
+	job, err := generateJob("app", "web", generateTestDeployment(), map[string]int{"web": 5}, config.Environment{}, nil, false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5, *job.TaskGroups[0].Count)
+}
+
+func TestGenerateJobKeepsAnExplicitCountOfZero(t *testing.T) {
+	deployment := generateTestDeployment()
+	count := 0
+	group := deployment.Job.Groups["web"]
+	group.Count = &count
+	deployment.Job.Groups["web"] = group
+
+	job, err := generateJob("app", "web", deployment, map[string]int{}, config.Environment{}, nil, false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, *job.TaskGroups[0].Count)
+}
+
+func TestGenerateHCL(t *testing.T) {
+	job, err := generateJob("app", "web", generateTestDeployment(), map[string]int{}, config.Environment{}, nil, true)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `job "app-web" {
+  datacenters = ["dc1"]
+  type = "service"
+
+  group "web" {
+    count = 3
+
+    task "app" {
+      driver = "docker"
+
+      config {
+        image = "[!image_app!]"
+
+        port_map {
+          http = 8080
+        }
+      }
+
+      env {
+        "LOG_LEVEL" = "[!var_log_level!]"
+      }
+
+      resources {
+        cpu = 200
+        memory = 256
+
+        network {
+          port "http" {}
+        }
+      }
+
+      service {
+        name = "app-web"
+        port = "http"
+
+        check {
+          type = "http"
+          path = "/health"
+          interval = "5s"
+          timeout = "2s"
+        }
+      }
+    }
+  }
+}
+`, generateHCL(job, groupSizePlaceholders(generateTestDeployment().Job)))
+}
+
+func TestGenerateJobResolvesEnvSecrets(t *testing.T) {
+	deployment := generateTestDeployment()
+	deployment.Job.Groups["web"].Tasks["app"].Env["DATABASE_URL"] = "env:TENT_TEST_DATABASE_URL"
+
+	secrets := secret.NewResolver(map[string]secret.Provider{"env": secret.NewMemoryProvider(map[string]string{"TENT_TEST_DATABASE_URL": "postgres://secret"})})
+
+	job, err := generateJob("app", "web", deployment, map[string]int{}, config.Environment{}, secrets, false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "postgres://secret", job.TaskGroups[0].Tasks[0].Env["DATABASE_URL"])
+}
+
+func TestGenerateHCLLeavesGroupSizesToTheDeploy(t *testing.T) {
+	deployment := generateTestDeployment()
+	group := deployment.Job.Groups["web"]
+	group.Count = nil
+	deployment.Job.Groups["web"] = group
+
+	job, err := generateJob("app", "web", deployment, map[string]int{}, config.Environment{}, nil, true)
+
+	assert.Nil(t, err)
+	assert.Contains(t, generateHCL(job, groupSizePlaceholders(deployment.Job)), "    count = [!group_web_size!]\n")
+}
+
+func TestGenerateJobRefusesToWriteSecrets(t *testing.T) {
+	deployment := generateTestDeployment()
+	deployment.Job.Groups["web"].Tasks["app"].Env["DATABASE_URL"] = "env:TENT_TEST_DATABASE_URL"
+
+	secrets := secret.NewResolver(map[string]secret.Provider{"env": secret.NewMemoryProvider(map[string]string{"TENT_TEST_DATABASE_URL": "postgres://secret"})})
+
+	_, err := generateJob("app", "web", deployment, map[string]int{}, config.Environment{}, secrets, true)
+
+	assert.EqualError(t, err, "group web: task app env DATABASE_URL: refers to a secret, which can not be written to a nomad file, declare it as a variable and use [!var_name!] instead")
+}
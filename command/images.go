@@ -0,0 +1,609 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	nomadAPI "github.com/hashicorp/nomad/api"
+	"github.com/mitchellh/cli"
+	config "github.com/pm-connect/tent/config"
+	nomad "github.com/pm-connect/tent/nomad"
+	"github.com/pm-connect/tent/registry"
+)
+
+var defaultKeepImages = 10
+
+// imageRepository is an image pushed by one or more builds, in any environment.
+type imageRepository struct {
+	Image       string
+	RegistryURL string
+	Name        string
+	// Tags are the tags configured for the builds, which are always kept.
+	Tags []string
+}
+
+// pruneCandidate is an image to delete, along with every tag pointing at it.
+type pruneCandidate struct {
+	Digest  string
+	Tags    []string
+	Created time.Time
+}
+
+// prunePlan is what will be deleted from, and kept within, an image repository.
+type prunePlan struct {
+	Repository imageRepository
+	Registry   registry.Registry
+	Path       string
+	TagCount   int
+	Deletes    []pruneCandidate
+	// Kept explains why the tags that are not among the newest are kept.
+	Kept []string
+}
+
+// ImagesCommand groups the images subcommands.
+type ImagesCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *ImagesCommand) Help() string {
+	helpText := `
+Usage: tent images <subcommand> [args]
+
+	Manage the images pushed to the registry.
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *ImagesCommand) Synopsis() string { return "Manage the images pushed to the registry." }
+
+// Name returns the name of the command.
+func (c *ImagesCommand) Name() string { return "images" }
+
+// Run shows the help for the subcommands.
+func (c *ImagesCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+// ImagesPruneCommand deletes old tags of the built images from their registries.
+type ImagesPruneCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *ImagesPruneCommand) Help() string {
+	helpText := `
+Usage: tent images prune [-keep=] [-older-than=] [-dry-run] [-yes] [deployments...]
+
+	Images prune deletes old tags of the images built by the deployments from their registries.
+
+	The newest images are kept, along with the tags configured for the builds and any image
+	used by a job in nomad within any namespace of any of the environments.
+
+	-keep=
+		How many of the newest images to keep for each build, defaults to 10.
+	-older-than=
+		Only delete images created longer ago than this, eg, 30d or 12h. Must be greater than 0.
+	-dry-run
+		List what would be deleted, without deleting anything.
+	-yes
+		Do not ask for confirmation.
+
+General Options:
+
+    ` + generalOptionsUsage() + `
+    `
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *ImagesPruneCommand) Synopsis() string { return "Delete old images from the registry." }
+
+// Name returns the name of the command.
+func (c *ImagesPruneCommand) Name() string { return "images prune" }
+
+// Run prunes the images.
+func (c *ImagesPruneCommand) Run(args []string) int {
+	var verbose bool
+	var keep int
+	var olderThanValue string
+	var dryRun bool
+	var yes bool
+
+	flags := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	flags.BoolVar(&verbose, "verbose", false, "Turn on verbose output.")
+	flags.IntVar(&keep, "keep", defaultKeepImages, "How many of the newest images to keep.")
+	flags.StringVar(&olderThanValue, "older-than", "", "Only delete images older than this.")
+	flags.BoolVar(&dryRun, "dry-run", false, "List what would be deleted.")
+	flags.BoolVar(&yes, "yes", false, "Do not ask for confirmation.")
+	err := flags.Parse(args)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	if keep < 0 {
+		c.UI.Error(fmt.Sprintf("-keep must not be negative, got %d", keep))
+		return 1
+	}
+
+	var olderThan time.Duration
+
+	if len(olderThanValue) > 0 {
+		olderThan, err = parseAge(olderThanValue)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("-older-than must be a duration greater than 0 (eg, 30d), got '%s'", olderThanValue))
+			return 1
+		}
+	}
+
+	clients := map[string]nomad.Client{}
+
+	for name, environment := range c.Config.Environments {
+		client, err := nomad.NewDefaultClient(generateNomadURL(environment.NomadURL), 5)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprint(err))
+			return 1
+		}
+
+		clients[name] = client
+	}
+
+	// Without knowing what is running nothing can be safely deleted, so any failure stops the prune.
+	inUse, err := c.runningImages(clients)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Unable to find the images used by nomad, nothing was deleted: %s", err))
+		return 1
+	}
+
+	errorCount := 0
+	plans := []prunePlan{}
+	deleteCount := 0
+
+	for _, repository := range c.imageRepositories(flags.Args()) {
+		if len(repository.RegistryURL) == 0 {
+			c.UI.Warn(fmt.Sprintf("===> [%s] No registry_url is set, skipping.", repository.Image))
+			continue
+		}
+
+		address, path := registry.SplitImage(repository.RegistryURL, repository.Name)
+
+		plan, err := planPrune(repository, registry.NewClient(address), path, inUse, keep, olderThan, time.Now())
+
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("===> [%s] Unable to read the registry: %s", repository.Image, err))
+			errorCount++
+			continue
+		}
+
+		c.showPrunePlan(plan, verbose)
+
+		plans = append(plans, plan)
+		deleteCount += len(plan.Deletes)
+	}
+
+	if deleteCount == 0 {
+		c.UI.Output("Nothing to prune.")
+	} else if dryRun {
+		c.UI.Output(fmt.Sprintf("Dry run, %d images would be deleted.", deleteCount))
+	} else {
+		confirmed := yes
+
+		if !confirmed {
+			confirmed, err = c.confirm(fmt.Sprintf("Delete %d images?", deleteCount))
+
+			if err != nil {
+				c.UI.Error(fmt.Sprint(err))
+				return 1
+			}
+		}
+
+		if !confirmed {
+			return 0
+		}
+
+		for _, plan := range plans {
+			c.prune(plan, &errorCount)
+		}
+	}
+
+	if errorCount > 0 {
+		c.UI.Error("Exiting with errors.")
+		return 1
+	}
+
+	return 0
+}
+
+// imageRepositories returns the images pushed by the builds of the given deployments, or of every deployment,
+// across the environments, as an environment may override the registry or name of a build.
+func (m *Meta) imageRepositories(deployments []string) []imageRepository {
+	configs := []config.Config{m.Config}
+
+	for _, name := range sortedEnvironmentNames(m.Config.Environments) {
+		conf, _ := m.Config.ForEnvironment(name)
+		configs = append(configs, conf)
+	}
+
+	repositories := map[string]*imageRepository{}
+
+	for _, conf := range configs {
+		for _, name := range sortedDeploymentNames(conf.Deployments) {
+			if len(deployments) > 0 && !containsString(deployments, name) {
+				continue
+			}
+
+			for _, build := range conf.Deployments[name].Builds {
+				if len(build.Name) == 0 {
+					continue
+				}
+
+				image := strings.TrimSuffix(BuildTag(build.RegistryURL, build.Name, "latest"), ":latest")
+
+				repository, ok := repositories[image]
+
+				if !ok {
+					repository = &imageRepository{Image: image, RegistryURL: strings.TrimSuffix(build.RegistryURL, "/"), Name: build.Name}
+					repositories[image] = repository
+				}
+
+				tags := build.Tags
+
+				// Like a build, no tags means latest.
+				if len(tags) == 0 {
+					tags = []string{"latest"}
+				}
+
+				for _, tag := range append([]string{build.DeployTag}, tags...) {
+					if len(tag) == 0 {
+						tag = "latest"
+					}
+
+					if !containsString(repository.Tags, tag) {
+						repository.Tags = append(repository.Tags, tag)
+					}
+				}
+			}
+		}
+	}
+
+	result := []imageRepository{}
+
+	for _, repository := range repositories {
+		sort.Strings(repository.Tags)
+		result = append(result, *repository)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Image < result[j].Image
+	})
+
+	return result
+}
+
+// runningImages returns the images used by the jobs that are not dead within each environment, keyed by the
+// image, with the jobs using them.
+func (m *Meta) runningImages(clients map[string]nomad.Client) (map[string][]string, error) {
+	images := map[string][]string{}
+
+	for environment, client := range clients {
+		namespaces, err := client.ListNamespaces()
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the namespaces of the %s environment: %s", environment, err)
+		}
+
+		for _, namespace := range namespaces {
+			jobs, err := client.ListNamespaceJobs(namespace)
+
+			if err != nil {
+				return nil, fmt.Errorf("unable to list the jobs of the %s environment: %s", environment, err)
+			}
+
+			for _, stub := range jobs {
+				if stub.Status == "dead" {
+					continue
+				}
+
+				job, err := client.ReadNamespaceJob(namespace, stub.ID)
+
+				if err != nil {
+					return nil, fmt.Errorf("unable to read the job %s in the %s environment: %s", stub.ID, environment, err)
+				}
+
+				user := environment + "/" + stub.ID
+
+				if namespace != nomadAPI.DefaultNamespace {
+					user = environment + "/" + namespace + "/" + stub.ID
+				}
+
+				for _, group := range job.TaskGroups {
+					for _, task := range group.Tasks {
+						image, ok := task.Config["image"].(string)
+
+						if !ok || len(image) == 0 {
+							continue
+						}
+
+						if !containsString(images[image], user) {
+							images[image] = append(images[image], user)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for image := range images {
+		sort.Strings(images[image])
+	}
+
+	return images, nil
+}
+
+// planPrune works out which images of a repository can be deleted.
+//
+// The newest images are kept, as are images with a configured tag or used by a job. As deleting an image deletes
+// every tag pointing at it, any tag sharing an image with a kept tag is kept too.
+func planPrune(repository imageRepository, reg registry.Registry, path string, inUse map[string][]string, keep int, olderThan time.Duration, now time.Time) (prunePlan, error) {
+	plan := prunePlan{Repository: repository, Registry: reg, Path: path}
+
+	tags, err := reg.Tags(path)
+
+	if err != nil {
+		return plan, err
+	}
+
+	plan.TagCount = len(tags)
+
+	manifests := map[string]registry.Manifest{}
+
+	for _, tag := range tags {
+		manifest, err := reg.Manifest(path, tag)
+
+		if err != nil {
+			return plan, err
+		}
+
+		manifests[tag] = manifest
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		a, b := manifests[tags[i]].Created, manifests[tags[j]].Created
+
+		if a.Equal(b) {
+			return tags[i] < tags[j]
+		}
+
+		return a.After(b)
+	})
+
+	// Images are counted rather than tags, as an image is often pushed with several tags.
+	newest := map[string]bool{}
+	reasons := map[string]string{}
+
+	for _, tag := range tags {
+		manifest := manifests[tag]
+		users := imageUsers(repository.Image, tag, manifest.Digest, inUse)
+
+		if !newest[manifest.Digest] && len(newest) < keep {
+			newest[manifest.Digest] = true
+		}
+
+		switch {
+		case newest[manifest.Digest]:
+			reasons[tag] = fmt.Sprintf("it is one of the newest %d images", keep)
+		case containsString(repository.Tags, tag):
+			reasons[tag] = "it is configured for a build"
+		case len(users) > 0:
+			reasons[tag] = "it is used by " + strings.Join(users, ", ")
+		case olderThan > 0 && manifest.Created.IsZero():
+			reasons[tag] = "its age is unknown"
+		case olderThan > 0 && now.Sub(manifest.Created) < olderThan:
+			reasons[tag] = "it is newer than " + formatAge(olderThan)
+		}
+	}
+
+	keptDigests := map[string]string{}
+
+	for _, tag := range tags {
+		if _, ok := reasons[tag]; ok {
+			if _, ok := keptDigests[manifests[tag].Digest]; !ok {
+				keptDigests[manifests[tag].Digest] = tag
+			}
+		}
+	}
+
+	candidates := map[string]*pruneCandidate{}
+	digests := []string{}
+
+	for _, tag := range tags {
+		manifest := manifests[tag]
+		reason, kept := reasons[tag]
+
+		if !kept {
+			if keptTag, ok := keptDigests[manifest.Digest]; ok {
+				reason, kept = fmt.Sprintf("it is the same image as %s, which is kept", keptTag), true
+			}
+		}
+
+		if kept {
+			if !newest[manifest.Digest] {
+				plan.Kept = append(plan.Kept, fmt.Sprintf("%s: %s", tag, reason))
+			}
+
+			continue
+		}
+
+		candidate, ok := candidates[manifest.Digest]
+
+		if !ok {
+			candidate = &pruneCandidate{Digest: manifest.Digest, Created: manifest.Created}
+			candidates[manifest.Digest] = candidate
+			digests = append(digests, manifest.Digest)
+		}
+
+		candidate.Tags = append(candidate.Tags, tag)
+	}
+
+	for _, digest := range digests {
+		plan.Deletes = append(plan.Deletes, *candidates[digest])
+	}
+
+	return plan, nil
+}
+
+// imageUsers returns the jobs using a tag, or the image it points at, of the given image.
+func imageUsers(image string, tag string, digest string, inUse map[string][]string) []string {
+	users := []string{}
+
+	for reference, jobs := range inUse {
+		referenceTag, referenceDigest, ok := splitImageReference(image, reference)
+
+		if !ok || (referenceTag != tag && referenceDigest != digest) {
+			continue
+		}
+
+		for _, job := range jobs {
+			if !containsString(users, job) {
+				users = append(users, job)
+			}
+		}
+	}
+
+	sort.Strings(users)
+
+	return users
+}
+
+// splitImageReference splits a reference to the given image, eg, registry.example.com/app:v1@sha256:abc, into its
+// tag and digest, where a reference without either is to latest. It returns false if the reference is to another
+// image.
+func splitImageReference(image string, reference string) (string, string, bool) {
+	if !strings.HasPrefix(reference, image) {
+		return "", "", false
+	}
+
+	rest := reference[len(image):]
+	digest := ""
+
+	if i := strings.Index(rest, "@"); i >= 0 {
+		rest, digest = rest[:i], rest[i+1:]
+	}
+
+	switch {
+	case len(rest) == 0 && len(digest) == 0:
+		return "latest", "", true
+	case len(rest) == 0:
+		return "", digest, true
+	case strings.HasPrefix(rest, ":") && !strings.Contains(rest, "/"):
+		return rest[1:], digest, true
+	}
+
+	return "", "", false
+}
+
+func (c *ImagesPruneCommand) showPrunePlan(plan prunePlan, verbose bool) {
+	tagCount := 0
+
+	for _, candidate := range plan.Deletes {
+		tagCount += len(candidate.Tags)
+	}
+
+	c.UI.Output(fmt.Sprintf("===> [%s] %d tags, deleting %d images with %d tags.", plan.Repository.Image, plan.TagCount, len(plan.Deletes), tagCount))
+
+	for _, candidate := range plan.Deletes {
+		c.UI.Output(fmt.Sprintf("    delete %s (%s, created %s)", strings.Join(candidate.Tags, ", "), candidate.Digest, formatCreated(candidate.Created)))
+	}
+
+	if verbose {
+		for _, kept := range plan.Kept {
+			c.UI.Output("    keep " + kept)
+		}
+	}
+}
+
+// prune deletes the images of a plan.
+func (c *ImagesPruneCommand) prune(plan prunePlan, errorCount *int) {
+	for _, candidate := range plan.Deletes {
+		err := plan.Registry.Delete(plan.Path, candidate.Digest)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("===> [%s] Unable to delete %s: %s", plan.Repository.Image, strings.Join(candidate.Tags, ", "), err))
+			*errorCount++
+			continue
+		}
+
+		c.UI.Info(fmt.Sprintf("===> [%s] Deleted %s.", plan.Repository.Image, strings.Join(candidate.Tags, ", ")))
+	}
+}
+
+// parseAge parses a duration that may also be given in days or weeks, eg, 30d or 2w.
+func parseAge(value string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": time.Hour * 24, "w": time.Hour * 24 * 7}
+
+	if len(value) == 0 {
+		return 0, fmt.Errorf("invalid age %s", value)
+	}
+
+	if unit, ok := units[value[len(value)-1:]]; ok {
+		count, err := strconv.Atoi(value[:len(value)-1])
+
+		if err != nil || count <= 0 {
+			return 0, fmt.Errorf("invalid age %s, it must be greater than 0", value)
+		}
+
+		return time.Duration(count) * unit, nil
+	}
+
+	age, err := time.ParseDuration(value)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if age <= 0 {
+		return 0, fmt.Errorf("invalid age %s, it must be greater than 0", value)
+	}
+
+	return age, nil
+}
+
+func formatAge(age time.Duration) string {
+	if age >= time.Hour*24 && age%(time.Hour*24) == 0 {
+		return fmt.Sprintf("%dd", age/(time.Hour*24))
+	}
+
+	return age.String()
+}
+
+func formatCreated(created time.Time) string {
+	if created.IsZero() {
+		return "unknown"
+	}
+
+	return created.Format("2006-01-02")
+}
+
+func sortedEnvironmentNames(environments map[string]config.Environment) []string {
+	names := []string{}
+
+	for name := range environments {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,269 @@
+package command
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	nomadAPI "github.com/hashicorp/nomad/api"
+	"github.com/mitchellh/cli"
+	"github.com/pm-connect/tent/config"
+	nomad "github.com/pm-connect/tent/nomad"
+	"github.com/pm-connect/tent/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRegistry stands in for a registry, holding the manifests of each tag.
+type TestRegistry struct {
+	Manifests  map[string]registry.Manifest
+	Order      []string
+	Deleted    []string
+	DeleteErrs map[string]error
+}
+
+func (r *TestRegistry) Tags(repository string) ([]string, error) {
+	return r.Order, nil
+}
+
+func (r *TestRegistry) Manifest(repository string, tag string) (registry.Manifest, error) {
+	return r.Manifests[tag], nil
+}
+
+func (r *TestRegistry) Delete(repository string, digest string) error {
+	if err, ok := r.DeleteErrs[digest]; ok {
+		return err
+	}
+
+	r.Deleted = append(r.Deleted, digest)
+
+	return nil
+}
+
+var pruneNow = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+func newTestRegistry() *TestRegistry {
+	day := func(days int) time.Time { return pruneNow.Add(-time.Hour * 24 * time.Duration(days)) }
+
+	return &TestRegistry{
+		Order: []string{"v1", "v2", "v3", "v4", "v5", "latest", "old"},
+		Manifests: map[string]registry.Manifest{
+			"v1":     {Digest: "sha256:1", Created: day(50)},
+			"old":    {Digest: "sha256:1", Created: day(50)},
+			"v2":     {Digest: "sha256:2", Created: day(40)},
+			"v3":     {Digest: "sha256:3", Created: day(35)},
+			"v4":     {Digest: "sha256:4", Created: day(10)},
+			"v5":     {Digest: "sha256:5", Created: day(1)},
+			"latest": {Digest: "sha256:5", Created: day(1)},
+		},
+	}
+}
+
+var pruneRepository = imageRepository{Image: "registry.example.com/app", RegistryURL: "registry.example.com", Name: "app", Tags: []string{"latest"}}
+
+func TestPlanPruneKeepsTheNewestImages(t *testing.T) {
+	plan, err := planPrune(pruneRepository, newTestRegistry(), "app", map[string][]string{}, 2, 0, pruneNow)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 7, plan.TagCount)
+	assert.Equal(t, []pruneCandidate{
+		{Digest: "sha256:3", Tags: []string{"v3"}, Created: pruneNow.Add(-time.Hour * 24 * 35)},
+		{Digest: "sha256:2", Tags: []string{"v2"}, Created: pruneNow.Add(-time.Hour * 24 * 40)},
+		{Digest: "sha256:1", Tags: []string{"old", "v1"}, Created: pruneNow.Add(-time.Hour * 24 * 50)},
+	}, plan.Deletes)
+}
+
+func TestPlanPruneNeverDeletesImagesInUse(t *testing.T) {
+	inUse := map[string][]string{
+		"registry.example.com/app:v2":                     {"production/app-web"},
+		"registry.example.com/app@sha256:1":               {"staging/app-worker"},
+		"registry.example.com/app-other:v3":               {"production/other"},
+		"registry.example.com/app:v9@sha256:3":            {"staging/app-web"},
+		"another.example.com/registry.example.com/app:v4": {"production/copy"},
+	}
+
+	plan, err := planPrune(pruneRepository, newTestRegistry(), "app", inUse, 1, 0, pruneNow)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []pruneCandidate{
+		{Digest: "sha256:4", Tags: []string{"v4"}, Created: pruneNow.Add(-time.Hour * 24 * 10)},
+	}, plan.Deletes)
+	assert.Equal(t, []string{
+		"v3: it is used by staging/app-web",
+		"v2: it is used by production/app-web",
+		"old: it is used by staging/app-worker",
+		"v1: it is used by staging/app-worker",
+	}, plan.Kept)
+}
+
+func TestPlanPruneOnlyDeletesOlderImages(t *testing.T) {
+	reg := newTestRegistry()
+	reg.Order = append(reg.Order, "unknown")
+	reg.Manifests["unknown"] = registry.Manifest{Digest: "sha256:6"}
+
+	plan, err := planPrune(pruneRepository, reg, "app", map[string][]string{}, 0, time.Hour*24*38, pruneNow)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(plan.Deletes))
+	assert.Equal(t, []string{"v2"}, plan.Deletes[0].Tags)
+	assert.Equal(t, []string{"old", "v1"}, plan.Deletes[1].Tags)
+	assert.Equal(t, []string{
+		"latest: it is configured for a build",
+		"v5: it is newer than 38d",
+		"v4: it is newer than 38d",
+		"v3: it is newer than 38d",
+		"unknown: its age is unknown",
+	}, plan.Kept)
+}
+
+func TestPrune(t *testing.T) {
+	ui := cli.NewMockUi()
+	reg := &TestRegistry{DeleteErrs: map[string]error{"sha256:2": errors.New("denied")}}
+	command := ImagesPruneCommand{Meta: Meta{UI: ui}}
+	errorCount := 0
+
+	command.prune(prunePlan{
+		Repository: pruneRepository,
+		Registry:   reg,
+		Path:       "app",
+		Deletes: []pruneCandidate{
+			{Digest: "sha256:1", Tags: []string{"old", "v1"}},
+			{Digest: "sha256:2", Tags: []string{"v2"}},
+		},
+	}, &errorCount)
+
+	assert.Equal(t, []string{"sha256:1"}, reg.Deleted)
+	assert.Equal(t, 1, errorCount)
+	assert.Contains(t, ui.OutputWriter.String(), "===> [registry.example.com/app] Deleted old, v1.")
+	assert.Contains(t, ui.ErrorWriter.String(), "===> [registry.example.com/app] Unable to delete v2: denied")
+}
+
+func TestRunningImages(t *testing.T) {
+	nomadClient := new(mockNomadClient)
+
+	image := "registry.example.com/app:v2"
+	other := "registry.example.com/app:v3"
+
+	nomadClient.On("ListNamespaces").Return([]string{"default", "team"}, nil).Once()
+	nomadClient.On("ListNamespaceJobs", "default").Return([]*nomadAPI.JobListStub{
+		{ID: "app-web", Status: "running"},
+		{ID: "app-old", Status: "dead"},
+	}, nil).Once()
+	nomadClient.On("ReadNamespaceJob", "default", "app-web").Return(&nomadAPI.Job{TaskGroups: []*nomadAPI.TaskGroup{
+		{Tasks: []*nomadAPI.Task{
+			{Config: map[string]interface{}{"image": image}},
+			{Config: map[string]interface{}{"command": "/bin/true"}},
+		}},
+	}}, nil).Once()
+	nomadClient.On("ListNamespaceJobs", "team").Return([]*nomadAPI.JobListStub{
+		{ID: "app-worker", Status: "running"},
+	}, nil).Once()
+	nomadClient.On("ReadNamespaceJob", "team", "app-worker").Return(&nomadAPI.Job{TaskGroups: []*nomadAPI.TaskGroup{
+		{Tasks: []*nomadAPI.Task{
+			{Config: map[string]interface{}{"image": other}},
+		}},
+	}}, nil).Once()
+
+	meta := Meta{UI: cli.NewMockUi()}
+
+	images, err := meta.runningImages(map[string]nomad.Client{"production": nomadClient})
+
+	nomadClient.AssertExpectations(t)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		image: {"production/app-web"},
+		other: {"production/team/app-worker"},
+	}, images)
+}
+
+func TestRunningImagesFailsWhenNomadDoes(t *testing.T) {
+	nomadClient := new(mockNomadClient)
+
+	nomadClient.On("ListNamespaces").Return([]string{"default"}, nil).Once()
+	nomadClient.On("ListNamespaceJobs", "default").Return([]*nomadAPI.JobListStub{}, errors.New("connection refused")).Once()
+
+	meta := Meta{UI: cli.NewMockUi()}
+
+	_, err := meta.runningImages(map[string]nomad.Client{"production": nomadClient})
+
+	assert.EqualError(t, err, "unable to list the jobs of the production environment: connection refused")
+
+	nomadClient = new(mockNomadClient)
+	nomadClient.On("ListNamespaces").Return([]string{}, errors.New("permission denied")).Once()
+
+	_, err = meta.runningImages(map[string]nomad.Client{"production": nomadClient})
+
+	assert.EqualError(t, err, "unable to list the namespaces of the production environment: permission denied")
+}
+
+func TestImageRepositories(t *testing.T) {
+	registryURL := "staging.example.com"
+
+	meta := Meta{Config: config.Config{
+		Deployments: map[string]config.Deployment{
+			"web": {Builds: map[string]config.Build{
+				"app":   {RegistryURL: "registry.example.com", Name: "app", DeployTag: "v1"},
+				"local": {},
+			}},
+			"worker": {Builds: map[string]config.Build{
+				"app": {RegistryURL: "registry.example.com/", Name: "app", Tags: []string{"stable"}},
+			}},
+		},
+		Environments: map[string]config.Environment{
+			"staging": {Overrides: map[string]config.DeploymentOverride{
+				"web": {Builds: map[string]config.BuildOverride{"app": {RegistryURL: &registryURL}}},
+			}},
+		},
+	}}
+
+	assert.Equal(t, []imageRepository{
+		{Image: "registry.example.com/app", RegistryURL: "registry.example.com", Name: "app", Tags: []string{"latest", "stable", "v1"}},
+		{Image: "staging.example.com/app", RegistryURL: "staging.example.com", Name: "app", Tags: []string{"latest", "v1"}},
+	}, meta.imageRepositories(nil))
+
+	assert.Equal(t, []string{"latest", "stable"}, meta.imageRepositories([]string{"worker"})[0].Tags)
+}
+
+func TestSplitImageReference(t *testing.T) {
+	tag, digest, ok := splitImageReference("registry.example.com/app", "registry.example.com/app")
+
+	assert.True(t, ok)
+	assert.Equal(t, "latest", tag)
+	assert.Equal(t, "", digest)
+
+	tag, digest, ok = splitImageReference("registry.example.com/app", "registry.example.com/app@sha256:abc")
+
+	assert.True(t, ok)
+	assert.Equal(t, "", tag)
+	assert.Equal(t, "sha256:abc", digest)
+
+	_, _, ok = splitImageReference("registry.example.com/app", "registry.example.com/app/worker:v1")
+
+	assert.False(t, ok)
+}
+
+func TestParseAge(t *testing.T) {
+	age, err := parseAge("30d")
+
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour*24*30, age)
+
+	age, err = parseAge("2w")
+
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour*24*14, age)
+
+	age, err = parseAge("12h")
+
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour*12, age)
+
+	_, err = parseAge("xd")
+
+	assert.NotNil(t, err)
+
+	for _, value := range []string{"-5h", "0s", "0d", "-2w"} {
+		_, err = parseAge(value)
+
+		assert.NotNil(t, err, value)
+	}
+}
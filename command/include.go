@@ -0,0 +1,206 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasttemplate"
+)
+
+var includeRegex = regexp.MustCompile(`\[!include\s+"([^"]+)"((?:\s+[a-zA-Z0-9_]+="[^"]*")*)\s*!\]`)
+var includeArgRegex = regexp.MustCompile(`([a-zA-Z0-9_]+)="([^"]*)"`)
+var lineReferenceRegex = regexp.MustCompile(`(?:\bAt |\bline |template: [^:\s]+:)(\d+)`)
+
+// sourceLines maps each line of a nomad file with its includes expanded to the file and line it came from.
+type sourceLines []string
+
+// translate notes the file and line behind every line number referred to by err.
+func (s sourceLines) translate(err error) error {
+	if err == nil || len(s) == 0 {
+		return err
+	}
+
+	seen := map[int]bool{}
+
+	for _, match := range lineReferenceRegex.FindAllStringSubmatch(err.Error(), -1) {
+		line, _ := strconv.Atoi(match[1])
+
+		if line >= 1 && line <= len(s) {
+			seen[line] = true
+		}
+	}
+
+	if len(seen) == 0 {
+		return err
+	}
+
+	lines := []int{}
+
+	for line := range seen {
+		lines = append(lines, line)
+	}
+
+	sort.Ints(lines)
+
+	sources := []string{}
+
+	for _, line := range lines {
+		sources = append(sources, fmt.Sprintf("line %d is %s", line, s[line-1]))
+	}
+
+	return errors.New(err.Error() + " (" + strings.Join(sources, ", ") + ")")
+}
+
+// sourceWriter builds an expanded nomad file, recording where each of its lines came from.
+type sourceWriter struct {
+	out   strings.Builder
+	lines sourceLines
+	open  bool
+}
+
+// write appends text, attributing every line it starts to source.
+func (w *sourceWriter) write(text string, source string) {
+	for _, segment := range strings.SplitAfter(text, "\n") {
+		if segment == "" {
+			continue
+		}
+
+		if !w.open {
+			w.lines = append(w.lines, source)
+		}
+
+		w.out.WriteString(segment)
+		w.open = !strings.HasSuffix(segment, "\n")
+	}
+}
+
+// include appends an included file. Its first line takes over the line the include directive is on.
+func (w *sourceWriter) include(text string, lines sourceLines) {
+	for i, segment := range strings.SplitAfter(text, "\n") {
+		if segment == "" || i >= len(lines) {
+			continue
+		}
+
+		if w.open {
+			w.lines[len(w.lines)-1] = lines[i]
+		} else {
+			w.lines = append(w.lines, lines[i])
+		}
+
+		w.out.WriteString(segment)
+		w.open = !strings.HasSuffix(segment, "\n")
+	}
+}
+
+// expandIncludes replaces every [!include "file" arg="value"!] directive with the contents of the included file.
+//
+// Included paths are relative to the including file. Within the included file the arguments are
+// available as [!arg_{name}!]. The returned lines record which file and line each expanded line came from.
+func expandIncludes(path string, contents string, stack []string) (string, sourceLines, error) {
+	absPath, err := filepath.Abs(path)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	stack = append(stack, absPath)
+
+	var out sourceWriter
+
+	lines := strings.SplitAfter(contents, "\n")
+
+	for i, line := range lines {
+		location := fmt.Sprintf("%s:%d", path, i+1)
+
+		if !strings.Contains(line, "[!include") {
+			out.write(line, location)
+			continue
+		}
+
+		matches := includeRegex.FindAllStringSubmatchIndex(line, -1)
+
+		if len(matches) != strings.Count(line, "[!include") {
+			return "", nil, fmt.Errorf("%s: invalid include directive, expected [!include \"file\" arg=\"value\"!]", location)
+		}
+
+		last := 0
+
+		for _, match := range matches {
+			out.write(line[last:match[0]], location)
+
+			includePath := line[match[2]:match[3]]
+
+			if !filepath.IsAbs(includePath) {
+				includePath = filepath.Join(filepath.Dir(path), includePath)
+			}
+
+			included, includedLines, err := includeFile(includePath, parseIncludeArgs(line[match[4]:match[5]]), stack)
+
+			if err != nil {
+				return "", nil, fmt.Errorf("%s: %s", location, err)
+			}
+
+			out.include(included, includedLines)
+
+			last = match[1]
+		}
+
+		out.write(line[last:], location)
+	}
+
+	return out.out.String(), out.lines, nil
+}
+
+func includeFile(path string, args map[string]string, stack []string) (string, sourceLines, error) {
+	absPath, err := filepath.Abs(path)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, parent := range stack {
+		if parent == absPath {
+			return "", nil, fmt.Errorf("include cycle detected: %s -> %s", strings.Join(stack, " -> "), absPath)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to include file: %s err: %s", path, err)
+	}
+
+	t, err := fasttemplate.NewTemplate(string(data), "[!", "!]")
+
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to parse included file: %s err: %s", path, err)
+	}
+
+	contents := t.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+		if strings.HasPrefix(tag, "arg_") {
+			return w.Write([]byte(args[strings.TrimPrefix(tag, "arg_")]))
+		}
+
+		// Anything else is left for the nomad file variable replacement.
+		return w.Write([]byte("[!" + tag + "!]"))
+	})
+
+	return expandIncludes(path, strings.TrimSuffix(contents, "\n"), stack)
+}
+
+func parseIncludeArgs(args string) map[string]string {
+	parsed := map[string]string{}
+
+	for _, match := range includeArgRegex.FindAllStringSubmatch(args, -1) {
+		parsed[match[1]] = match[2]
+	}
+
+	return parsed
+}
@@ -0,0 +1,357 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/pm-connect/tent/lock"
+)
+
+var defaultLockTTL = time.Minute * 30
+var lockRetrySleep = time.Second * 5
+
+var errNoLockBackend = errors.New("no lock backend configured, set lock.backend in the config")
+
+// makeLockBackend creates the configured lock backend, or nil if locking is disabled.
+func (m *Meta) makeLockBackend() lock.Backend {
+	switch m.Config.Lock.Backend {
+	case "file":
+		path := m.Config.Lock.Path
+
+		if len(path) == 0 {
+			path = ".tent/locks"
+		}
+
+		return lock.NewFileBackend(path)
+	}
+
+	return nil
+}
+
+// lockKeys returns the keys of the locks guarding the given deployments within an environment.
+func (m *Meta) lockKeys(environment string, deployments []string) []string {
+	jobIDs := []string{}
+
+	for _, name := range deployments {
+		deployment := m.Config.Deployments[name]
+		jobIDs = append(jobIDs, generateJobName(deployment.ServiceName, m.Config.Name, name))
+	}
+
+	return m.jobLockKeys(environment, jobIDs)
+}
+
+// jobLockKeys returns the keys of the locks guarding the given jobs within an environment.
+func (m *Meta) jobLockKeys(environment string, jobIDs []string) []string {
+	if m.Config.Lock.Scope == "environment" {
+		return []string{environment}
+	}
+
+	keys := []string{}
+
+	for _, jobID := range jobIDs {
+		keys = append(keys, environment+"/"+jobID)
+	}
+
+	return keys
+}
+
+// acquireLocks takes the deploy locks for the given deployments, returning a function to release them.
+//
+// If any lock is held by someone else it is retried until the timeout passes.
+func (m *Meta) acquireLocks(environment string, deployments []string, command string, timeout time.Duration) (func(), error) {
+	return m.acquireLockKeys(m.lockKeys(environment, deployments), command, timeout)
+}
+
+// acquireLockKeys takes the locks with the given keys, returning a function to release them. The locks are renewed
+// in the background until they are released, so a deploy that takes longer than the ttl keeps its locks.
+func (m *Meta) acquireLockKeys(keys []string, command string, timeout time.Duration) (func(), error) {
+	backend := m.makeLockBackend()
+
+	if backend == nil {
+		return func() {}, nil
+	}
+
+	ttl := defaultLockTTL
+
+	if len(m.Config.Lock.TTL) > 0 {
+		ttl, _ = time.ParseDuration(m.Config.Lock.TTL)
+	}
+
+	holder := lock.CurrentHolder(command)
+
+	var held []*lock.Lock
+
+	release := func() {
+		for _, l := range held {
+			err := backend.Release(l)
+
+			if err != nil {
+				m.UI.Warn(fmt.Sprintf("Unable to release lock %s: %s", l.Key, err))
+			}
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for _, key := range keys {
+		for {
+			l, err := backend.Acquire(key, holder, ttl)
+
+			if err == nil {
+				held = append(held, l)
+				break
+			}
+
+			if _, locked := err.(*lock.LockedError); !locked || time.Now().Add(lockRetrySleep).After(deadline) {
+				release()
+				return nil, err
+			}
+
+			m.UI.Warn(fmt.Sprintf("Waiting for lock: %s", err))
+			time.Sleep(lockRetrySleep)
+		}
+	}
+
+	stop := make(chan bool)
+	stopped := make(chan bool)
+
+	go func() {
+		defer close(stopped)
+		m.renewLocks(backend, held, ttl, stop)
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+		release()
+	}, nil
+}
+
+// renewLocks renews the held locks every third of their ttl, until stop is closed.
+func (m *Meta) renewLocks(backend lock.Backend, held []*lock.Lock, ttl time.Duration, stop chan bool) {
+	if ttl <= 0 {
+		<-stop
+		return
+	}
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, l := range held {
+				if err := backend.Renew(l, ttl); err != nil {
+					m.UI.Warn(fmt.Sprintf("Unable to renew lock %s: %s", l.Key, err))
+				}
+			}
+		}
+	}
+}
+
+// LockCommand groups the lock subcommands.
+type LockCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *LockCommand) Help() string {
+	helpText := `
+Usage: tent lock <subcommand> [args]
+
+	Show or release the deploy locks.
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *LockCommand) Synopsis() string { return "Show or release the deploy locks." }
+
+// Name returns the name of the command.
+func (c *LockCommand) Name() string { return "lock" }
+
+// Run shows the help for the subcommands.
+func (c *LockCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+// LockStatusCommand shows the current holders of the deploy locks.
+type LockStatusCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *LockStatusCommand) Help() string {
+	helpText := `
+Usage: tent lock status [-env=] [deployments...]
+
+	Lock status shows who is holding the deploy locks for an environment.
+
+	-env=
+		Specify the environment configuration to use.
+
+General Options:
+
+    ` + generalOptionsUsage() + `
+    `
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *LockStatusCommand) Synopsis() string { return "Show the holders of the deploy locks." }
+
+// Name returns the name of the command.
+func (c *LockStatusCommand) Name() string { return "lock status" }
+
+// Run shows the lock status.
+func (c *LockStatusCommand) Run(args []string) int {
+	var verbose bool
+	var environment string
+
+	flags := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	flags.BoolVar(&verbose, "verbose", false, "Turn on verbose output.")
+	flags.StringVar(&environment, "env", "production", "Specify the environment to use.")
+	err := flags.Parse(args)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	envConfig := c.Config.Environments[environment]
+
+	if envConfig.NomadURL == "" {
+		c.UI.Error(fmt.Sprintf("Unable to find any environment config for environment: %s", environment))
+		return 1
+	}
+
+	c.Config, _ = c.Config.ForEnvironment(environment)
+
+	backend := c.makeLockBackend()
+
+	if backend == nil {
+		c.UI.Error(errNoLockBackend.Error())
+		return 1
+	}
+
+	deployments := flags.Args()
+
+	if len(deployments) == 0 {
+		deployments = sortedDeploymentNames(c.Config.Deployments)
+	}
+
+	for _, key := range c.lockKeys(environment, deployments) {
+		l, err := backend.Read(key)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("===> [%s] Unable to read lock: %s", key, err))
+			return 1
+		}
+
+		if l == nil {
+			c.UI.Output(fmt.Sprintf("===> [%s] Not locked.", key))
+		} else if l.Expired(time.Now()) {
+			c.UI.Output(fmt.Sprintf("===> [%s] Expired lock held by %s since %s.", key, l.Holder, l.AcquiredAt.Format(time.RFC3339)))
+		} else {
+			c.UI.Warn(fmt.Sprintf("===> [%s] Locked by %s since %s, expires %s.", key, l.Holder, l.AcquiredAt.Format(time.RFC3339), l.ExpiresAt.Format(time.RFC3339)))
+		}
+	}
+
+	return 0
+}
+
+// LockReleaseCommand force releases deploy locks.
+type LockReleaseCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *LockReleaseCommand) Help() string {
+	helpText := `
+Usage: tent lock release [-env=] [deployments...]
+
+	Lock release removes the deploy locks for an environment, regardless of who holds them.
+
+	-env=
+		Specify the environment configuration to use.
+
+General Options:
+
+    ` + generalOptionsUsage() + `
+    `
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *LockReleaseCommand) Synopsis() string { return "Release the deploy locks." }
+
+// Name returns the name of the command.
+func (c *LockReleaseCommand) Name() string { return "lock release" }
+
+// Run releases the locks.
+func (c *LockReleaseCommand) Run(args []string) int {
+	var verbose bool
+	var environment string
+
+	flags := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	flags.BoolVar(&verbose, "verbose", false, "Turn on verbose output.")
+	flags.StringVar(&environment, "env", "production", "Specify the environment to use.")
+	err := flags.Parse(args)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	envConfig := c.Config.Environments[environment]
+
+	if envConfig.NomadURL == "" {
+		c.UI.Error(fmt.Sprintf("Unable to find any environment config for environment: %s", environment))
+		return 1
+	}
+
+	c.Config, _ = c.Config.ForEnvironment(environment)
+
+	backend := c.makeLockBackend()
+
+	if backend == nil {
+		c.UI.Error(errNoLockBackend.Error())
+		return 1
+	}
+
+	deployments := flags.Args()
+
+	if len(deployments) == 0 {
+		deployments = sortedDeploymentNames(c.Config.Deployments)
+	}
+
+	errorCount := 0
+
+	for _, key := range c.lockKeys(environment, deployments) {
+		err := backend.ForceRelease(key)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("===> [%s] Unable to release lock: %s", key, err))
+			errorCount++
+			continue
+		}
+
+		c.UI.Info(fmt.Sprintf("===> [%s] Lock released.", key))
+	}
+
+	if errorCount > 0 {
+		c.UI.Error("Exiting with errors.")
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,185 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/lock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockKeys(t *testing.T) {
+	meta := Meta{
+		Config: config.Config{
+			Name: "app",
+			Deployments: map[string]config.Deployment{
+				"web":    {},
+				"worker": {ServiceName: "my-worker"},
+			},
+		},
+	}
+
+	assert.Equal(t, []string{"staging/app-web", "staging/my-worker"}, meta.lockKeys("staging", []string{"web", "worker"}))
+
+	meta.Config.Lock.Scope = "environment"
+
+	assert.Equal(t, []string{"staging"}, meta.lockKeys("staging", []string{"web", "worker"}))
+}
+
+func TestAcquireLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tent-lock")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	meta := Meta{
+		UI: cli.NewMockUi(),
+		Config: config.Config{
+			Name: "app",
+			Deployments: map[string]config.Deployment{
+				"web": {},
+			},
+			Lock: config.Lock{
+				Backend: "file",
+				Path:    dir,
+			},
+		},
+	}
+
+	release, err := meta.acquireLocks("staging", []string{"web"}, "deploy", 0)
+
+	assert.Nil(t, err)
+
+	_, err = meta.acquireLocks("staging", []string{"web"}, "deploy", 0)
+
+	assert.NotNil(t, err)
+
+	release()
+
+	release, err = meta.acquireLocks("staging", []string{"web"}, "deploy", 0)
+
+	assert.Nil(t, err)
+
+	release()
+}
+
+func TestAcquireLocksRenewsTheLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tent-lock")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	meta := Meta{
+		UI: cli.NewMockUi(),
+		Config: config.Config{
+			Name: "app",
+			Deployments: map[string]config.Deployment{
+				"web": {},
+			},
+			Lock: config.Lock{
+				Backend: "file",
+				Path:    dir,
+				TTL:     "60ms",
+			},
+		},
+	}
+
+	release, err := meta.acquireLocks("staging", []string{"web"}, "deploy", 0)
+
+	assert.Nil(t, err)
+
+	time.Sleep(time.Millisecond * 150)
+
+	held, err := meta.makeLockBackend().Read("staging/app-web")
+
+	assert.Nil(t, err)
+	assert.False(t, held.Expired(time.Now()))
+
+	release()
+}
+
+func TestAcquireLocksWithoutBackend(t *testing.T) {
+	meta := Meta{
+		Config: config.Config{
+			Name: "app",
+		},
+	}
+
+	release, err := meta.acquireLocks("staging", []string{"web"}, "deploy", 0)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, release)
+}
+
+func TestLockCommandsUseTheEnvironmentOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tent-lock")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	serviceName := "web-staging"
+
+	meta := Meta{
+		UI: cli.NewMockUi(),
+		Config: config.Config{
+			Name: "app",
+			Environments: map[string]config.Environment{
+				"staging": {
+					NomadURL: "http://localhost:4646",
+					Overrides: map[string]config.DeploymentOverride{
+						"web": {ServiceName: &serviceName},
+					},
+				},
+			},
+			Deployments: map[string]config.Deployment{
+				"web": {},
+			},
+			Lock: config.Lock{
+				Backend: "file",
+				Path:    dir,
+			},
+		},
+	}
+
+	backend := meta.makeLockBackend()
+
+	_, err = backend.Acquire("staging/web-staging", lock.Holder{User: "bob"}, time.Minute)
+	assert.Nil(t, err)
+
+	ui := cli.NewMockUi()
+	meta.UI = ui
+
+	status := &LockStatusCommand{Meta: meta}
+
+	assert.Equal(t, 0, status.Run([]string{"-env=staging"}))
+	assert.Contains(t, ui.ErrorWriter.String(), "===> [staging/web-staging] Locked by")
+
+	release := &LockReleaseCommand{Meta: meta}
+
+	assert.Equal(t, 0, release.Run([]string{"-env=staging"}))
+
+	held, err := backend.Read("staging/web-staging")
+
+	assert.Nil(t, err)
+	assert.Nil(t, held)
+}
+
+func TestLockCommandsRequireTheEnvironment(t *testing.T) {
+	ui := cli.NewMockUi()
+
+	meta := Meta{
+		UI: ui,
+		Config: config.Config{
+			Name: "app",
+			Lock: config.Lock{
+				Backend: "file",
+			},
+		},
+	}
+
+	status := &LockStatusCommand{Meta: meta}
+
+	assert.Equal(t, 1, status.Run([]string{"-env=staging"}))
+	assert.Contains(t, ui.ErrorWriter.String(), "Unable to find any environment config for environment: staging")
+}
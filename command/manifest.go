@@ -0,0 +1,66 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// deployManifest records the jobs that a deploy submitted to nomad.
+type deployManifest struct {
+	Name        string            `json:"name"`
+	Environment string            `json:"environment"`
+	Jobs        map[string]string `json:"jobs"`
+
+	lock sync.Mutex
+}
+
+func newDeployManifest(name string, environment string) *deployManifest {
+	return &deployManifest{
+		Name:        name,
+		Environment: environment,
+		Jobs:        map[string]string{},
+	}
+}
+
+// add records the job id submitted for a deployment.
+func (m *deployManifest) add(deployment string, jobID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Jobs[deployment] = jobID
+}
+
+// save writes the manifest to the given path.
+func (m *deployManifest) save(path string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// loadDeployManifest reads a manifest previously written by a deploy.
+func loadDeployManifest(path string) (*deployManifest, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to load deploy manifest: %s err: %s", path, err)
+	}
+
+	manifest := &deployManifest{}
+
+	err = json.Unmarshal(data, manifest)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse deploy manifest: %s err: %s", path, err)
+	}
+
+	return manifest, nil
+}
@@ -3,10 +3,13 @@ package command
 import (
 	"github.com/mitchellh/cli"
 	"github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/secret"
 )
 
 // Meta contains the meta options for functionally for nearly every command.
 type Meta struct {
-	Config config.Config
-	UI     cli.Ui
+	Config   config.Config
+	UI       cli.Ui
+	Secrets  *secret.Resolver
+	Redactor *secret.Redactor
 }
@@ -0,0 +1,102 @@
+package command
+
+import (
+	"fmt"
+	"time"
+
+	nomad "github.com/pm-connect/tent/nomad"
+)
+
+var allocationsRunningSleep = time.Second * 1
+var defaultStopTimeout = time.Minute * 10
+
+// maxMonitorFailures is how many times in a row reading from nomad may fail while monitoring before giving up.
+var maxMonitorFailures = 5
+
+// monitorEvaluation waits for the given evaluation to complete.
+func (m *Meta) monitorEvaluation(name string, evalID string, verbose bool, nomadClient nomad.Client) error {
+	eval, err := nomadClient.ReadEvaluation(evalID)
+
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+
+	for eval.Status != "complete" {
+		if eval.Status == "failed" || eval.Status == "canceled" {
+			return fmt.Errorf("evaluation %s %s: %s", evalID, eval.Status, eval.StatusDescription)
+		}
+
+		time.Sleep(evaluationNotCompleteSleep)
+
+		evalStatus, err := nomadClient.ReadEvaluation(evalID)
+
+		if err != nil {
+			failures++
+
+			if failures > maxMonitorFailures {
+				return fmt.Errorf("unable to read evaluation %s after %d attempts: %s", evalID, failures, err)
+			}
+
+			m.UI.Warn(fmt.Sprintf("===> [%s] Error monitoring evaluation: %s", name, err))
+			continue
+		}
+
+		failures = 0
+		eval = evalStatus
+
+		if verbose {
+			m.UI.Warn(fmt.Sprintf("===> [%s] Evaluation Status: %s", name, eval.Status))
+		}
+	}
+
+	return nil
+}
+
+// waitForAllocationsToStop waits until none of the allocations for a job are pending or running, giving up once
+// the timeout has passed.
+func (m *Meta) waitForAllocationsToStop(name string, jobID string, timeout time.Duration, verbose bool, nomadClient nomad.Client) error {
+	failures := 0
+	deadline := time.Now().Add(timeout)
+
+	for {
+		allocations, err := nomadClient.ReadJobAllocations(jobID)
+
+		if err != nil {
+			failures++
+
+			if failures > maxMonitorFailures {
+				return fmt.Errorf("unable to read the allocations after %d attempts: %s", failures, err)
+			}
+
+			m.UI.Warn(fmt.Sprintf("===> [%s] Error monitoring allocations: %s", name, err))
+			time.Sleep(allocationsRunningSleep)
+			continue
+		}
+
+		failures = 0
+
+		running := 0
+
+		for _, allocation := range allocations {
+			if allocation.ClientStatus == "pending" || allocation.ClientStatus == "running" {
+				running++
+			}
+		}
+
+		if running == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d allocations are still running after %s, check them with nomad job status %s", running, timeout, jobID)
+		}
+
+		if verbose {
+			m.UI.Output(fmt.Sprintf("===> [%s] Waiting for %d allocations to stop.", name, running))
+		}
+
+		time.Sleep(allocationsRunningSleep)
+	}
+}
@@ -0,0 +1,151 @@
+package command
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	config "github.com/pm-connect/tent/config"
+)
+
+// buildOutputs records the outputs reported by build scripts, keyed by deployment and then build.
+type buildOutputs struct {
+	Name        string                                  `json:"name"`
+	Deployments map[string]map[string]map[string]string `json:"deployments"`
+
+	lock sync.Mutex
+}
+
+func newBuildOutputs(name string) *buildOutputs {
+	return &buildOutputs{
+		Name:        name,
+		Deployments: map[string]map[string]map[string]string{},
+	}
+}
+
+// add records the outputs of a build.
+func (o *buildOutputs) add(deployment string, build string, outputs map[string]string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.Deployments[deployment] == nil {
+		o.Deployments[deployment] = map[string]map[string]string{}
+	}
+
+	o.Deployments[deployment][build] = outputs
+}
+
+// apply sets the recorded outputs on the builds of the config.
+func (o *buildOutputs) apply(conf *config.Config) {
+	for name, deployment := range conf.Deployments {
+		conf.Deployments[name] = o.applyDeployment(name, deployment)
+	}
+}
+
+// applyDeployment returns the deployment with the recorded outputs set on its builds.
+func (o *buildOutputs) applyDeployment(name string, deployment config.Deployment) config.Deployment {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	builds := map[string]config.Build{}
+
+	for buildName, build := range deployment.Builds {
+		if outputs, ok := o.Deployments[name][buildName]; ok {
+			build.Outputs = outputs
+		}
+
+		builds[buildName] = build
+	}
+
+	deployment.Builds = builds
+
+	return deployment
+}
+
+// save writes the outputs to the given path.
+func (o *buildOutputs) save(path string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	data, err := json.MarshalIndent(o, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// loadBuildOutputs reads the outputs previously written by a build.
+func loadBuildOutputs(path string) (*buildOutputs, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to load build outputs: %s err: %s", path, err)
+	}
+
+	outputs := newBuildOutputs("")
+
+	err = json.Unmarshal(data, outputs)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse build outputs: %s err: %s", path, err)
+	}
+
+	return outputs, nil
+}
+
+// readScriptOutputs reads the key=value lines a build script wrote to $TENT_OUTPUT.
+//
+// Blank lines and lines starting with # are ignored.
+func readScriptOutputs(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	outputs := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+			return nil, fmt.Errorf("invalid output on line %d, expected key=value: %s", line, text)
+		}
+
+		outputs[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return outputs, scanner.Err()
+}
+
+// deployImage returns the image to deploy for a build, preferring the image and digest reported by its script.
+func deployImage(build config.Build) string {
+	image := build.Outputs["image"]
+
+	if len(image) == 0 {
+		image = BuildTag(build.RegistryURL, build.Name, build.DeployTag)
+	}
+
+	if digest := build.Outputs["digest"]; len(digest) > 0 && !strings.Contains(image, "@") {
+		image = image + "@" + digest
+	}
+
+	return image
+}
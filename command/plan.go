@@ -0,0 +1,98 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+
+	nomadAPI "github.com/hashicorp/nomad/api"
+	config "github.com/pm-connect/tent/config"
+)
+
+// deployPlan describes the job that a deployment will submit to nomad.
+type deployPlan struct {
+	Name   string
+	JobID  string
+	Job    *nomadAPI.Job
+	Images map[string]string
+	Groups []groupChange
+}
+
+// groupChange describes how the count of a task group will change.
+type groupChange struct {
+	Name     string
+	Current  int
+	Desired  int
+	Existing bool
+}
+
+func newDeployPlan(name string, deployment config.Deployment, job *nomadAPI.Job, groupSizes map[string]int) *deployPlan {
+	plan := &deployPlan{
+		Name:   name,
+		JobID:  *job.ID,
+		Job:    job,
+		Images: map[string]string{},
+	}
+
+	for key, build := range deployment.Builds {
+		plan.Images[key] = deployImage(build)
+	}
+
+	for _, group := range job.TaskGroups {
+		if group.Name == nil {
+			continue
+		}
+
+		// Nomad defaults a task group without a count to a single instance.
+		desired := 1
+
+		if group.Count != nil {
+			desired = *group.Count
+		}
+
+		current, existing := groupSizes[*group.Name]
+
+		plan.Groups = append(plan.Groups, groupChange{
+			Name:     *group.Name,
+			Current:  current,
+			Desired:  desired,
+			Existing: existing,
+		})
+	}
+
+	sort.Slice(plan.Groups, func(i, j int) bool {
+		return plan.Groups[i].Name < plan.Groups[j].Name
+	})
+
+	return plan
+}
+
+// describe returns a human readable summary of the plan, one line per entry.
+func (p *deployPlan) describe() []string {
+	lines := []string{fmt.Sprintf("%s: %s", p.Name, p.JobID)}
+
+	var builds []string
+
+	for key := range p.Images {
+		builds = append(builds, key)
+	}
+
+	sort.Strings(builds)
+
+	for _, key := range builds {
+		lines = append(lines, fmt.Sprintf("    image %s: %s", key, p.Images[key]))
+	}
+
+	for _, group := range p.Groups {
+		lines = append(lines, "    "+group.describe())
+	}
+
+	return lines
+}
+
+func (g groupChange) describe() string {
+	if !g.Existing {
+		return fmt.Sprintf("group %s: new -> %d", g.Name, g.Desired)
+	}
+
+	return fmt.Sprintf("group %s: %d -> %d", g.Name, g.Current, g.Desired)
+}
@@ -0,0 +1,225 @@
+package command
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	config "github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/secret"
+)
+
+var fromRegex = regexp.MustCompile(`(?i)^\s*FROM\s+(?:--\S+\s+)*(\S+)(?:\s+AS\s+(\S+))?`)
+var argRegex = regexp.MustCompile(`(?i)^\s*ARG\s+([A-Za-z_][A-Za-z0-9_]*)(?:=(\S*))?`)
+
+// buildProvenance describes how an image was built, so that it can be traced back to its config and commit.
+type buildProvenance struct {
+	Name        string            `json:"name"`
+	Environment string            `json:"environment,omitempty"`
+	Deployment  string            `json:"deployment"`
+	Build       string            `json:"build"`
+	Builder     string            `json:"builder,omitempty"`
+	Created     string            `json:"created,omitempty"`
+	Revision    string            `json:"revision,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Dirty       bool              `json:"dirty"`
+	Inputs      provenanceInputs  `json:"inputs"`
+	BuildArgs   map[string]string `json:"build_args,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	BaseImages  []string          `json:"base_images,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Image       string            `json:"image,omitempty"`
+	Digest      string            `json:"digest,omitempty"`
+}
+
+type provenanceInputs struct {
+	Context string   `json:"context,omitempty"`
+	File    string   `json:"file,omitempty"`
+	Target  string   `json:"target,omitempty"`
+	Script  string   `json:"script,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	Workdir string   `json:"workdir,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+	// Secrets are the ids of the secrets mounted into the build, never their values.
+	Secrets []string `json:"secrets,omitempty"`
+	SSH     bool     `json:"ssh,omitempty"`
+}
+
+// imageLabels returns the labels of an image: the OCI labels describing where it was built from, and the
+// deployment and build it belongs to, followed by the labels of the build, which take precedence.
+func imageLabels(deploymentName string, name string, build config.Build, templates map[string]string) map[string]string {
+	labels := map[string]string{
+		"tent.deployment": deploymentName,
+		"tent.build":      name,
+	}
+
+	version := templates["git.tag"]
+
+	if len(version) == 0 {
+		version = build.DeployTag
+	}
+
+	for label, value := range map[string]string{
+		"org.opencontainers.image.revision": templates["git.sha"],
+		"org.opencontainers.image.source":   templates["git.remote"],
+		"org.opencontainers.image.created":  createdTime(templates["timestamp"]),
+		"org.opencontainers.image.version":  version,
+	} {
+		if len(value) > 0 {
+			labels[label] = value
+		}
+	}
+
+	for label, value := range build.Labels {
+		labels[label] = value
+	}
+
+	return labels
+}
+
+// createdTime converts the {timestamp} template into the RFC 3339 format of the OCI created label.
+func createdTime(timestamp string) string {
+	created, err := time.Parse("20060102150405", timestamp)
+
+	if err != nil {
+		return ""
+	}
+
+	return created.UTC().Format(time.RFC3339)
+}
+
+// newProvenance describes the inputs of a build, leaving the image and digest to be filled in once it is pushed.
+func newProvenance(serviceName string, environment string, deploymentName string, name string, build config.Build, builder string, templates map[string]string) buildProvenance {
+	provenance := buildProvenance{
+		Name:        serviceName,
+		Environment: environment,
+		Deployment:  deploymentName,
+		Build:       name,
+		Created:     createdTime(templates["timestamp"]),
+		Revision:    templates["git.sha"],
+		Source:      templates["git.remote"],
+		Dirty:       len(templates["git.dirty"]) > 0,
+		Inputs:      provenanceInputs{Paths: build.Paths},
+	}
+
+	if len(build.Script) > 0 {
+		provenance.Inputs.Script = build.Script
+		provenance.Inputs.Args = build.Args
+		provenance.Inputs.Workdir = build.Workdir
+
+		return provenance
+	}
+
+	provenance.Builder = builder
+	provenance.Inputs.Context = build.Context
+	provenance.Inputs.File = dockerfilePath(build)
+	provenance.Inputs.Target = build.Target
+	provenance.Inputs.SSH = len(build.SSH) > 0
+
+	for _, secret := range buildSecrets(build) {
+		provenance.Inputs.Secrets = append(provenance.Inputs.Secrets, secret.ID)
+	}
+
+	provenance.BuildArgs = build.BuildArgValues()
+	provenance.Labels = imageLabels(deploymentName, name, build, templates)
+	provenance.BaseImages, _ = baseImages(provenance.Inputs.File, provenance.BuildArgs)
+
+	// Secret build args are masked, as the provenance is meant to be published alongside the image. Args that
+	// refer to a secret are recorded as their reference, never as the resolved secret.
+	for key, arg := range build.BuildArgs {
+		if arg.Secret {
+			provenance.BuildArgs[key] = secret.Mask
+		}
+	}
+
+	return provenance
+}
+
+// save writes the provenance to {deployment}-{build}.json within dir, hiding any sensitive values.
+func (p buildProvenance) save(dir string, redactor *secret.Redactor) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.json", p.Deployment, p.Build))
+
+	return ioutil.WriteFile(path, []byte(redactor.Redact(string(data))+"\n"), 0644)
+}
+
+// dockerfilePath returns the dockerfile of a build, which defaults to the Dockerfile within the context.
+func dockerfilePath(build config.Build) string {
+	if len(build.File) > 0 {
+		return build.File
+	}
+
+	context := build.Context
+
+	if len(context) == 0 {
+		context = "."
+	}
+
+	return filepath.Join(context, "Dockerfile")
+}
+
+// baseImages returns the images a dockerfile is built from, resolving any args used within FROM.
+//
+// Earlier stages referred to by a later FROM, and scratch, are left out.
+func baseImages(file string, buildArgs map[string]string) ([]string, error) {
+	f, err := os.Open(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	args := map[string]string{}
+	stages := map[string]bool{"scratch": true}
+	images := []string{}
+	seen := map[string]bool{}
+	from := false
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Only the args declared before the first FROM may be used within a FROM.
+		if match := argRegex.FindStringSubmatch(line); match != nil && !from {
+			if value, ok := buildArgs[match[1]]; ok {
+				args[match[1]] = value
+			} else {
+				args[match[1]] = match[2]
+			}
+
+			continue
+		}
+
+		match := fromRegex.FindStringSubmatch(line)
+
+		if match == nil {
+			continue
+		}
+
+		from = true
+		image := os.Expand(match[1], func(key string) string { return args[key] })
+
+		if !stages[image] && !seen[image] {
+			images = append(images, image)
+			seen[image] = true
+		}
+
+		if len(match[2]) > 0 {
+			stages[match[2]] = true
+		}
+	}
+
+	return images, scanner.Err()
+}
@@ -0,0 +1,122 @@
+package command
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Flaque/filet"
+	"github.com/mitchellh/cli"
+	config "github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTemplates = map[string]string{
+	"git.sha":    "0123456789abcdef0123456789abcdef01234567",
+	"git.tag":    "v1.2.0",
+	"git.remote": "https://github.com/pm-connect/tent",
+	"git.dirty":  "",
+	"timestamp":  "20200102030405",
+}
+
+func TestImageLabels(t *testing.T) {
+	labels := imageLabels("web", "app", config.Build{DeployTag: "latest", Labels: map[string]string{"team": "payments", "tent.build": "custom"}}, testTemplates)
+
+	assert.Equal(t, map[string]string{
+		"org.opencontainers.image.revision": "0123456789abcdef0123456789abcdef01234567",
+		"org.opencontainers.image.source":   "https://github.com/pm-connect/tent",
+		"org.opencontainers.image.created":  "2020-01-02T03:04:05Z",
+		"org.opencontainers.image.version":  "v1.2.0",
+		"tent.deployment":                   "web",
+		"tent.build":                        "custom",
+		"team":                              "payments",
+	}, labels)
+
+	labels = imageLabels("web", "app", config.Build{DeployTag: "latest"}, nil)
+
+	assert.Equal(t, map[string]string{
+		"org.opencontainers.image.version": "latest",
+		"tent.deployment":                  "web",
+		"tent.build":                       "app",
+	}, labels)
+}
+
+func TestBaseImages(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	file := filet.TmpFile(t, "", `ARG GO_VERSION=1.12
+ARG BASE
+FROM golang:${GO_VERSION} AS build
+ARG GO_VERSION=ignored
+FROM build AS test
+FROM --platform=linux/amd64 $BASE
+FROM scratch
+FROM golang:${GO_VERSION}
+`)
+
+	images, err := baseImages(file.Name(), map[string]string{"BASE": "alpine:3.10"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"golang:1.12", "alpine:3.10"}, images)
+}
+
+func TestBuildWritesProvenance(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM alpine:3.10\n"), 0644)
+
+	build := config.Build{
+		Context:   dir,
+		Name:      "my-image",
+		DeployTag: "v1",
+		Tags:      []string{"v1"},
+		BuildArgs: map[string]config.BuildArg{
+			"VERSION":   {Value: "1.2.0"},
+			"NPM_TOKEN": {Value: "abc123", Secret: true},
+		},
+	}
+
+	buildCommand := BuildCommand{
+		Meta: Meta{
+			UI: cli.NewMockUi(),
+			Config: config.Config{
+				Name: "test",
+				Deployments: map[string]config.Deployment{
+					"web": {Builds: map[string]config.Build{"app": build}, Templates: config.NewTemplates(testTemplates)},
+				},
+			},
+		},
+		environment:   "staging",
+		provenanceDir: dir,
+	}
+
+	testDocker := TestDocker{}
+	errorCount := 0
+
+	buildCommand.build("web", "app", build, false, &testDocker, docker.Runner{}, &errorCount)
+
+	assert.Equal(t, 0, errorCount)
+	assert.Equal(t, "web", testDocker.Labels["tent.deployment"])
+	assert.Equal(t, "0123456789abcdef0123456789abcdef01234567", testDocker.Labels["org.opencontainers.image.revision"])
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "web-app.json"))
+	assert.Nil(t, err)
+
+	provenance := buildProvenance{}
+	assert.Nil(t, json.Unmarshal(data, &provenance))
+
+	assert.Equal(t, "test", provenance.Name)
+	assert.Equal(t, "staging", provenance.Environment)
+	assert.Equal(t, "test", provenance.Builder)
+	assert.Equal(t, "2020-01-02T03:04:05Z", provenance.Created)
+	assert.Equal(t, filepath.Join(dir, "Dockerfile"), provenance.Inputs.File)
+	assert.Equal(t, map[string]string{"VERSION": "1.2.0", "NPM_TOKEN": "****"}, provenance.BuildArgs)
+	assert.Equal(t, []string{"alpine:3.10"}, provenance.BaseImages)
+	assert.Equal(t, []string{"my-image:v1"}, provenance.Tags)
+	assert.Equal(t, "my-image:v1", provenance.Image)
+	assert.Equal(t, "", provenance.Digest)
+	assert.Equal(t, testDocker.Labels, provenance.Labels)
+}
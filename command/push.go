@@ -0,0 +1,108 @@
+package command
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pm-connect/tent/docker"
+)
+
+var defaultPushAttempts = 3
+var defaultPushBackoff = time.Second * 2
+
+// pushTags pushes every tag of an image at once, as the tags share their layers, retrying pushes that fail with a
+// transient registry error. It returns whether every tag was pushed.
+func (c *BuildCommand) pushTags(name string, tags []string, builder docker.Docker, verbose bool, errorCount *int) bool {
+	attempts, backoff := c.pushRetry()
+
+	var wg sync.WaitGroup
+
+	results := make([]error, len(tags))
+
+	for i, tag := range tags {
+		wg.Add(1)
+		go func(i int, tag string) {
+			defer wg.Done()
+
+			results[i] = c.pushTag(name, tag, builder, verbose, attempts, backoff)
+		}(i, tag)
+	}
+
+	wg.Wait()
+
+	pushed := true
+
+	for i, err := range results {
+		if err == nil {
+			continue
+		}
+
+		if docker.IsTransient(err) {
+			c.UI.Error(fmt.Sprintf("===> [%s] Failed pushing the tag %s after %d attempts: %s", name, tags[i], attempts, failureReason(err)))
+		} else {
+			c.UI.Error(fmt.Sprintf("===> [%s] Failed pushing the tag %s, did you log in? (%s login)", name, tags[i], builder.Capabilities().Name))
+		}
+
+		c.showFailedOutput(name, err, verbose)
+		*errorCount++
+		pushed = false
+	}
+
+	return pushed
+}
+
+// pushTag pushes a tag, retrying with an increasing backoff while it fails with a transient error.
+func (c *BuildCommand) pushTag(name string, tag string, builder docker.Docker, verbose bool, attempts int, backoff time.Duration) error {
+	c.UI.Output(fmt.Sprintf("===> [%s] Pushing tag: %s", name, tag))
+
+	for attempt := 1; ; attempt++ {
+		progress, err := builder.PushImage(name, tag, verbose)
+
+		if err == nil {
+			// Builders that push while building, such as kaniko, have no progress to report.
+			if progress == (docker.PushProgress{}) {
+				c.UI.Output(fmt.Sprintf("===> [%s] Pushed tag %s.", name, tag))
+			} else {
+				c.UI.Output(fmt.Sprintf("===> [%s] Pushed tag %s: %s", name, tag, progress))
+			}
+
+			return nil
+		}
+
+		if attempt >= attempts || !docker.IsTransient(err) {
+			return err
+		}
+
+		c.UI.Warn(fmt.Sprintf("===> [%s] Pushing the tag %s failed (%s), retrying in %s. (attempt %d of %d)", name, tag, failureReason(err), backoff, attempt+1, attempts))
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// pushRetry returns how many times to push each tag, and how long to wait before the first retry.
+func (c *BuildCommand) pushRetry() (int, time.Duration) {
+	attempts := defaultPushAttempts
+	backoff := defaultPushBackoff
+
+	if c.Config.PushRetry.Attempts > 0 {
+		attempts = c.Config.PushRetry.Attempts
+	}
+
+	if len(c.Config.PushRetry.Backoff) > 0 {
+		backoff, _ = time.ParseDuration(c.Config.PushRetry.Backoff)
+	}
+
+	return attempts, backoff
+}
+
+// failureReason returns the last line of output of a failed command, which usually holds the error, or the
+// error itself.
+func failureReason(err error) string {
+	if commandErr, ok := err.(*docker.CommandError); ok && len(commandErr.Tail) > 0 {
+		return commandErr.Tail[len(commandErr.Tail)-1]
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,98 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	config "github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/docker"
+	"github.com/pm-connect/tent/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+// runnerDocker pushes by running a command with its runner, as the real builders do.
+type runnerDocker struct {
+	TestDocker
+	runner docker.Runner
+}
+
+func (b *runnerDocker) PushImage(name string, image string, output bool) (docker.PushProgress, error) {
+	// The secret is written in two parts, so that it is only redacted if the line is kept whole.
+	cmd := exec.Command("sh", "-c", "printf 'pushing "+image+" with tok'; sleep 0.01; printf 'en-secret\\n'")
+
+	return docker.PushProgress{}, b.runner.Run(name, cmd, output)
+}
+
+func newPushCommand(ui cli.Ui) *BuildCommand {
+	return &BuildCommand{Meta: Meta{UI: ui, Config: config.Config{PushRetry: config.PushRetry{Attempts: 3, Backoff: "1ms"}}}}
+}
+
+func TestPushTagsRetriesTransientErrors(t *testing.T) {
+	ui := cli.NewMockUi()
+	builder := TestDocker{PushErrors: []error{
+		&docker.CommandError{Err: errors.New("exit status 1"), Tail: []string{"received unexpected HTTP status: 502 Bad Gateway"}},
+	}}
+	errorCount := 0
+
+	pushed := newPushCommand(ui).pushTags("app", []string{"app:v1", "app:latest"}, &builder, false, &errorCount)
+
+	assert.True(t, pushed)
+	assert.Equal(t, 0, errorCount)
+	assert.Equal(t, 3, builder.PushImageCallCount)
+	assert.Contains(t, ui.ErrorWriter.String(), "failed (received unexpected HTTP status: 502 Bad Gateway), retrying in 1ms. (attempt 2 of 3)")
+	assert.Contains(t, ui.OutputWriter.String(), "===> [app] Pushed tag app:v1: 2 layers pushed, 1 already existed in 1s")
+	assert.Contains(t, ui.OutputWriter.String(), "===> [app] Pushed tag app:latest: 2 layers pushed, 1 already existed in 1s")
+}
+
+func TestPushTagsGivesUpAfterTheAttempts(t *testing.T) {
+	ui := cli.NewMockUi()
+	transient := errors.New("503 Service Unavailable")
+	builder := TestDocker{PushErrors: []error{transient, transient, transient}}
+	errorCount := 0
+
+	pushed := newPushCommand(ui).pushTags("app", []string{"app:v1"}, &builder, false, &errorCount)
+
+	assert.False(t, pushed)
+	assert.Equal(t, 1, errorCount)
+	assert.Equal(t, 3, builder.PushImageCallCount)
+	assert.Contains(t, ui.ErrorWriter.String(), "===> [app] Failed pushing the tag app:v1 after 3 attempts: 503 Service Unavailable")
+}
+
+func TestPushTagsDoesNotRetryOtherErrors(t *testing.T) {
+	ui := cli.NewMockUi()
+	builder := TestDocker{PushErrors: []error{errors.New("denied: requested access to the resource is denied")}}
+	errorCount := 0
+
+	pushed := newPushCommand(ui).pushTags("app", []string{"app:v1"}, &builder, false, &errorCount)
+
+	assert.False(t, pushed)
+	assert.Equal(t, 1, errorCount)
+	assert.Equal(t, 1, builder.PushImageCallCount)
+	assert.Contains(t, ui.ErrorWriter.String(), "===> [app] Failed pushing the tag app:v1, did you log in? (test login)")
+}
+
+func TestPushTagsSharesTheLogBetweenConcurrentPushes(t *testing.T) {
+	redactor := secret.NewRedactor(nil, nil)
+	redactor.AddValue("token-secret")
+
+	var out bytes.Buffer
+	log := redactor.Writer(&out)
+
+	tags := []string{"app:v1", "app:v2", "app:v3", "app:v4", "app:latest"}
+	builder := runnerDocker{runner: docker.Runner{Log: log}}
+	errorCount := 0
+
+	pushed := newPushCommand(cli.NewMockUi()).pushTags("app", tags, &builder, false, &errorCount)
+
+	assert.True(t, pushed)
+	assert.Nil(t, log.Flush())
+	assert.NotContains(t, out.String(), "token-secret")
+
+	for _, tag := range tags {
+		assert.Contains(t, strings.Split(out.String(), "\n"), "pushing "+tag+" with ****")
+	}
+}
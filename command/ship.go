@@ -0,0 +1,325 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/nomad"
+)
+
+// ShipCommand builds each deployment and then deploys it with the images that were just built.
+type ShipCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *ShipCommand) Help() string {
+	helpText := `
+Usage: tent ship [-env=] [-manifest=] [-log-dir=] [-provenance-dir=] [-yes] [-lock-timeout=] [-since=] [-explain]
+
+    Ship builds each deployment, then deploys it as soon as its own builds have finished.
+
+    The images deployed are the exact images that were pushed, pinned by digest where the builder
+    reports one. A deployment with a failed build is not deployed.
+
+    -env=
+        Specify the environment configuration to use.
+
+    -manifest=
+        Write the ids of the deployed jobs to the given file, for use with destroy.
+
+    -log-dir=
+        Write the full output of each build to {deployment}-{build}.log within the directory.
+
+    -provenance-dir=
+        Write how each image was built, and the digest it was pushed with, to {deployment}-{build}.json within the directory.
+
+    -yes
+        Ship to a protected environment without asking for confirmation.
+
+    -lock-timeout=
+        How long to wait for the deploy lock when another run holds it. (eg, 5m)
+
+    -since=
+        Only select the deployments affected by the files changed since the given git ref. (eg, origin/main)
+
+    -explain
+        Show why each deployment was or was not selected by -since, then exit without running.
+
+General Options:
+
+    ` + generalOptionsUsage() + `
+    `
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *ShipCommand) Synopsis() string { return "Build and then deploy the project." }
+
+// Name returns the name of the command.
+func (c *ShipCommand) Name() string { return "ship" }
+
+// Run builds and deploys each deployment.
+func (c *ShipCommand) Run(args []string) int {
+	var verbose bool
+	var environment string
+	var manifestFile string
+	var logDir string
+	var provenanceDir string
+	var yes bool
+	var lockTimeout time.Duration
+	var since string
+	var explain bool
+
+	flags := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	flags.BoolVar(&verbose, "verbose", false, "Turn on verbose output.")
+	flags.StringVar(&environment, "env", "production", "Specify the environment to use.")
+	flags.StringVar(&manifestFile, "manifest", "", "Write the deployed job ids to the given file.")
+	flags.StringVar(&logDir, "log-dir", "", "Write the output of each build to a file within the directory.")
+	flags.StringVar(&provenanceDir, "provenance-dir", "", "Write the provenance of each build to a file within the directory.")
+	flags.BoolVar(&yes, "yes", false, "Confirm shipping to a protected environment.")
+	flags.DurationVar(&lockTimeout, "lock-timeout", 0, "How long to wait for the deploy lock.")
+	flags.StringVar(&since, "since", "", "Only select the deployments changed since the given git ref.")
+	flags.BoolVar(&explain, "explain", false, "Show why each deployment was selected by -since, without running.")
+	err := flags.Parse(args)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	envConfig := c.Config.Environments[environment]
+
+	if envConfig.NomadURL == "" {
+		c.UI.Error(fmt.Sprintf("Unable to find any environment config for environment: %s", environment))
+		return 1
+	}
+
+	c.Config, _ = c.Config.ForEnvironment(environment)
+
+	run, err := c.selectChanged(since, explain)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	if !run {
+		return 0
+	}
+
+	if environment == "production" {
+		c.UI.Warn("You are running using the Production environment!")
+	}
+
+	nomadClient, err := nomad.NewDefaultClient(generateNomadURL(envConfig.NomadURL), 5)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	names := sortedDeploymentNames(c.Config.Deployments)
+
+	// The plan of a protected environment is confirmed once the images are built, so the builds are not run
+	// when the confirmation can not be asked for.
+	if envConfig.Protected && !yes && !stdinIsTerminal() {
+		c.UI.Error(fmt.Sprint(errNotTerminal))
+		return 1
+	}
+
+	releaseLocks, err := c.acquireLocks(environment, names, c.Name(), lockTimeout)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Unable to acquire deploy lock: %s", err))
+		return 1
+	}
+
+	defer releaseLocks()
+
+	if len(logDir) > 0 {
+		if err := os.MkdirAll(logDir, 0755); err != nil {
+			c.UI.Error(fmt.Sprintf("Unable to create the log directory: %s", err))
+			return 1
+		}
+	}
+
+	if len(provenanceDir) > 0 {
+		if err := os.MkdirAll(provenanceDir, 0755); err != nil {
+			c.UI.Error(fmt.Sprintf("Unable to create the provenance directory: %s", err))
+			return 1
+		}
+	}
+
+	builder := &BuildCommand{Meta: c.Meta, environment: environment, outputs: newBuildOutputs(c.Config.Name), provenanceDir: provenanceDir, deploying: true}
+	deployer := &DeployCommand{Meta: c.Meta}
+
+	if len(manifestFile) > 0 {
+		deployer.manifest = newDeployManifest(c.Config.Name, environment)
+	}
+
+	var concurrency int
+
+	if c.Config.Concurrent {
+		concurrency = 5
+	} else {
+		concurrency = 1
+	}
+
+	errorCount := 0
+
+	if envConfig.Protected {
+		count, confirmed := c.shipConfirmed(environment, names, concurrency, verbose, yes, logDir, builder, deployer, nomadClient, envConfig)
+
+		if !confirmed {
+			return 1
+		}
+
+		errorCount += count
+	} else {
+		errorCount += runConcurrently(names, concurrency, func(name string, errorCount *int) {
+			c.ship(name, c.Config.Deployments[name], verbose, logDir, builder, deployer, nomadClient, envConfig, errorCount)
+		})
+	}
+
+	if deployer.manifest != nil {
+		if err := deployer.manifest.save(manifestFile); err != nil {
+			c.UI.Error(fmt.Sprintf("Unable to write deploy manifest: %s", err))
+			errorCount++
+		}
+	}
+
+	if errorCount != 0 {
+		c.UI.Error("Exiting with errors.")
+		return 1
+	}
+
+	return 0
+}
+
+// shipConfirmed runs every build, then lists the jobs, images and task group count changes to be deployed and asks
+// for confirmation unless yes is given, as deploy does, before deploying them. Deployments with failed builds are
+// skipped.
+//
+// It returns the number of errors, and whether the deployments were confirmed.
+func (c *ShipCommand) shipConfirmed(environment string, names []string, concurrency int, verbose bool, yes bool, logDir string, builder *BuildCommand, deployer *DeployCommand, nomadClient nomad.Client, envConfig config.Environment) (int, bool) {
+	built := map[string]bool{}
+	var builtLock sync.Mutex
+
+	errorCount := runConcurrently(names, concurrency, func(name string, errorCount *int) {
+		if c.buildDeployment(name, c.Config.Deployments[name], verbose, logDir, builder, errorCount) {
+			builtLock.Lock()
+			built[name] = true
+			builtLock.Unlock()
+		}
+	})
+
+	plans := map[string]*deployPlan{}
+	planned := []string{}
+
+	for _, name := range names {
+		if !built[name] {
+			continue
+		}
+
+		plan := deployer.plan(name, builder.outputs.applyDeployment(name, c.Config.Deployments[name]), verbose, &errorCount, nomadClient, envConfig)
+
+		if plan != nil {
+			plans[name] = plan
+			planned = append(planned, name)
+		}
+	}
+
+	if len(planned) < len(built) {
+		c.UI.Error("Unable to plan the deployment.")
+		return errorCount, false
+	}
+
+	if len(planned) == 0 {
+		return errorCount, true
+	}
+
+	deployer.showPlans(environment, planned, plans)
+
+	if !yes {
+		confirmed, err := c.confirmEnvironment(environment)
+
+		if err != nil {
+			c.UI.Error(fmt.Sprint(err))
+			return 1, false
+		}
+
+		if !confirmed {
+			c.UI.Error("Ship cancelled.")
+			return 1, false
+		}
+	}
+
+	errorCount += runConcurrently(planned, concurrency, func(name string, errorCount *int) {
+		c.UI.Output(fmt.Sprintf("===> [%s] Starting deployment.", name))
+		deployer.submit(plans[name], verbose, errorCount, nomadClient)
+	})
+
+	return errorCount, true
+}
+
+// ship runs the builds of a deployment, then deploys it with their outputs unless any of them failed.
+func (c *ShipCommand) ship(name string, deployment config.Deployment, verbose bool, logDir string, builder *BuildCommand, deployer *DeployCommand, nomadClient nomad.Client, envConfig config.Environment, errorCount *int) {
+	if !c.buildDeployment(name, deployment, verbose, logDir, builder, errorCount) {
+		return
+	}
+
+	deployer.deploy(name, builder.outputs.applyDeployment(name, deployment), verbose, errorCount, nomadClient, envConfig)
+}
+
+// buildDeployment runs the builds of a deployment, reporting whether all of them succeeded.
+func (c *ShipCommand) buildDeployment(name string, deployment config.Deployment, verbose bool, logDir string, builder *BuildCommand, errorCount *int) bool {
+	buildErrors := 0
+
+	for _, key := range sortedBuildNames(deployment.Builds) {
+		builder.runBuild(name, key, deployment.Builds[key], verbose, logDir, &buildErrors)
+	}
+
+	if buildErrors > 0 {
+		c.UI.Error(fmt.Sprintf("===> [%s] Skipping deployment as its builds failed.", name))
+		*errorCount += buildErrors
+		return false
+	}
+
+	return true
+}
+
+// runConcurrently calls fn for each name, at most concurrency at once, and returns the total of the errors each
+// call counted. Each call counts its own errors, so that the calls never share a counter.
+func runConcurrently(names []string, concurrency int, fn func(name string, errorCount *int)) int {
+	sem := make(chan bool, concurrency)
+
+	var lock sync.Mutex
+	total := 0
+
+	for _, name := range names {
+		sem <- true
+		go func(name string) {
+			defer func() { <-sem }()
+
+			errorCount := 0
+			fn(name, &errorCount)
+
+			lock.Lock()
+			total += errorCount
+			lock.Unlock()
+		}(name)
+	}
+
+	for i := 0; i < cap(sem); i++ {
+		sem <- true
+	}
+
+	return total
+}
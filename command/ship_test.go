@@ -0,0 +1,150 @@
+package command
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Flaque/filet"
+	nomadAPI "github.com/hashicorp/nomad/api"
+	"github.com/mitchellh/cli"
+	"github.com/pm-connect/tent/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShipDeploysTheImagesThatWereBuilt(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	filet.File(t, "ship.nomad", `job "app" { task "web" { image = "[!image_app!]" } }`)
+	filet.File(t, "ship.sh", `echo "image=registry.example.com/app:v1" >> "$TENT_OUTPUT"; echo "digest=sha256:abc" >> "$TENT_OUTPUT"`)
+
+	deployment := config.Deployment{
+		NomadFile: "ship.nomad",
+		Builds: map[string]config.Build{
+			"app": {Script: "ship.sh", Shell: "sh"},
+		},
+	}
+
+	ui := cli.NewMockUi()
+	meta := Meta{UI: ui, Config: config.Config{Name: "app", Deployments: map[string]config.Deployment{"web": deployment}}}
+	shipCommand := ShipCommand{Meta: meta}
+
+	rendered := `job "app" { task "web" { image = "registry.example.com/app:v1@sha256:abc" } }`
+	jobID := "app"
+	jobType := "batch"
+
+	nomadClient := new(mockNomadClient)
+	nomadClient.On("ParseJob", rendered).Return(&nomadAPI.Job{ID: &jobID}, nil).Once()
+	nomadClient.On("ReadJob", jobID).Return(&nomadAPI.Job{ID: &jobID}, nil).Once()
+	nomadClient.On("ParseJob", rendered).Return(&nomadAPI.Job{ID: &jobID}, nil).Once()
+	nomadClient.On("UpdateJob", &nomadAPI.Job{ID: &jobID}).Return(&nomadAPI.JobRegisterResponse{}, nil).Once()
+	nomadClient.On("ReadJob", jobID).Return(&nomadAPI.Job{Type: &jobType}, nil).Once()
+
+	errorCount := 0
+
+	shipCommand.ship(
+		"web",
+		deployment,
+		false,
+		"",
+		&BuildCommand{Meta: meta, outputs: newBuildOutputs("app")},
+		&DeployCommand{Meta: meta},
+		nomadClient,
+		config.Environment{},
+		&errorCount,
+	)
+
+	nomadClient.AssertExpectations(t)
+	assert.Equal(t, 0, errorCount, ui.ErrorWriter.String())
+}
+
+func TestShipSkipsDeploymentsWithFailedBuilds(t *testing.T) {
+	deployment := config.Deployment{
+		Builds: map[string]config.Build{
+			"app": {Script: "exit 1", Shell: "sh -c"},
+		},
+	}
+
+	ui := cli.NewMockUi()
+	meta := Meta{UI: ui, Config: config.Config{Name: "app"}}
+	shipCommand := ShipCommand{Meta: meta}
+
+	nomadClient := new(mockNomadClient)
+	errorCount := 0
+
+	shipCommand.ship(
+		"web",
+		deployment,
+		false,
+		"",
+		&BuildCommand{Meta: meta, outputs: newBuildOutputs("app")},
+		&DeployCommand{Meta: meta},
+		nomadClient,
+		config.Environment{},
+		&errorCount,
+	)
+
+	nomadClient.AssertExpectations(t)
+	assert.Equal(t, 1, errorCount)
+	assert.Contains(t, ui.ErrorWriter.String(), "===> [web] Skipping deployment as its builds failed.")
+}
+
+func TestShipConfirmedShowsThePlanOfTheBuiltImages(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	filet.File(t, "ship.nomad", `job "app" { task "web" { image = "[!image_app!]" } }`)
+	filet.File(t, "ship.sh", `echo "image=registry.example.com/app:v1" >> "$TENT_OUTPUT"; echo "digest=sha256:abc" >> "$TENT_OUTPUT"`)
+
+	deployment := config.Deployment{
+		NomadFile: "ship.nomad",
+		Builds: map[string]config.Build{
+			"app": {Script: "ship.sh", Shell: "sh"},
+		},
+	}
+
+	ui := cli.NewMockUi()
+	meta := Meta{UI: ui, Config: config.Config{Name: "app", Deployments: map[string]config.Deployment{"web": deployment}}}
+	shipCommand := ShipCommand{Meta: meta}
+
+	rendered := `job "app" { task "web" { image = "registry.example.com/app:v1@sha256:abc" } }`
+	jobID := "app"
+	jobType := "batch"
+
+	nomadClient := new(mockNomadClient)
+	nomadClient.On("ParseJob", rendered).Return(&nomadAPI.Job{ID: &jobID}, nil).Once()
+	nomadClient.On("ReadJob", jobID).Return(&nomadAPI.Job{ID: &jobID}, nil).Once()
+	nomadClient.On("ParseJob", rendered).Return(&nomadAPI.Job{ID: &jobID}, nil).Once()
+	nomadClient.On("UpdateJob", &nomadAPI.Job{ID: &jobID}).Return(&nomadAPI.JobRegisterResponse{}, nil).Once()
+	nomadClient.On("ReadJob", jobID).Return(&nomadAPI.Job{Type: &jobType}, nil).Once()
+
+	errorCount, confirmed := shipCommand.shipConfirmed(
+		"production",
+		[]string{"web"},
+		1,
+		false,
+		true,
+		"",
+		&BuildCommand{Meta: meta, outputs: newBuildOutputs("app"), deploying: true},
+		&DeployCommand{Meta: meta},
+		nomadClient,
+		config.Environment{Protected: true},
+	)
+
+	nomadClient.AssertExpectations(t)
+	assert.True(t, confirmed)
+	assert.Equal(t, 0, errorCount, ui.ErrorWriter.String())
+	assert.Contains(t, ui.OutputWriter.String(), "The following jobs will be deployed to the production environment:\n    web: app\n        image app: registry.example.com/app:v1@sha256:abc\n")
+}
+
+func TestRunConcurrentlyAddsUpTheErrors(t *testing.T) {
+	names := []string{}
+
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("deployment-%d", i))
+	}
+
+	total := runConcurrently(names, 5, func(name string, errorCount *int) {
+		*errorCount += 2
+	})
+
+	assert.Equal(t, 40, total)
+}
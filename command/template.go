@@ -0,0 +1,211 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+
+	config "github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/secret"
+)
+
+// The go template engine uses [[ ]] so that consul-template's {{ }} in nomad template stanzas is left alone.
+const goTemplateLeftDelim = "[["
+const goTemplateRightDelim = "]]"
+
+var nomadGroupRegex = regexp.MustCompile(`group\s+"([^"]+)"`)
+
+// templateContext is the data available to nomad files rendered with the go template engine.
+type templateContext struct {
+	Name       string
+	Deployment string
+	JobName    string
+	Vars       map[string]string
+	Env        templateEnvironment
+	Images     map[string]string
+	Groups     map[string]templateGroup
+	Git        templateGit
+	Timestamp  string
+}
+
+type templateEnvironment struct {
+	Vars map[string]string
+}
+
+type templateGroup struct {
+	Count int
+}
+
+// templateGit reads the repository only once a [[ .Git ]] value is used, failing the render if it can not be read.
+type templateGit struct {
+	templates *config.Templates
+}
+
+func (g templateGit) SHA() (string, error)      { return g.templates.Value("git.sha") }
+func (g templateGit) ShortSHA() (string, error) { return g.templates.Value("git.short_sha") }
+func (g templateGit) Branch() (string, error)   { return g.templates.Value("git.branch") }
+func (g templateGit) Tag() (string, error)      { return g.templates.Value("git.tag") }
+func (g templateGit) Dirty() (string, error)    { return g.templates.Value("git.dirty") }
+func (g templateGit) Remote() (string, error)   { return g.templates.Value("git.remote") }
+
+func newTemplateContext(file string, serviceName string, deploymentName string, deployment config.Deployment, groupSizes map[string]int, environment config.Environment) templateContext {
+	context := templateContext{
+		Name:       serviceName,
+		Deployment: deploymentName,
+		JobName:    generateJobName(deployment.ServiceName, serviceName, deploymentName),
+		Vars:       map[string]string{},
+		Env:        templateEnvironment{Vars: map[string]string{}},
+		Images:     map[string]string{},
+		Groups:     map[string]templateGroup{},
+		Git:        templateGit{templates: deployment.Templates},
+	}
+
+	// {timestamp} does not read the repository, so it never fails.
+	context.Timestamp, _ = deployment.Templates.Value("timestamp")
+
+	for key, build := range deployment.Builds {
+		context.Images[key] = deployImage(build)
+	}
+
+	for variable, value := range deployment.Variables {
+		context.Vars[variable] = value
+	}
+
+	for variable, value := range environment.Variables {
+		context.Env.Vars[variable] = value
+	}
+
+	// Groups declared in the file are included so that new groups get the default size.
+	for _, match := range nomadGroupRegex.FindAllStringSubmatch(file, -1) {
+		context.Groups[match[1]] = templateGroup{Count: groupSize(match[1], deploymentName, deployment, groupSizes)}
+	}
+
+	for group := range groupSizes {
+		context.Groups[group] = templateGroup{Count: groupSize(group, deploymentName, deployment, groupSizes)}
+	}
+
+	return context
+}
+
+// resolveTemplateContext resolves the variables of the context that refer to a secret.
+func resolveTemplateContext(context templateContext, secrets *secret.Resolver) (templateContext, error) {
+	vars, err := secrets.ResolveMap(context.Vars)
+
+	if err != nil {
+		return context, err
+	}
+
+	envVars, err := secrets.ResolveMap(context.Env.Vars)
+
+	if err != nil {
+		return context, err
+	}
+
+	context.Vars = vars
+	context.Env.Vars = envVars
+
+	return context, nil
+}
+
+// renderGoTemplate renders a nomad file using go's text/template.
+//
+// A key that is missing from a map, such as an undeclared variable, renders as empty rather than as "<no value>",
+// so that it can be caught with default or required. In strict mode it is an error.
+func renderGoTemplate(name string, file string, context templateContext, strict bool) (string, error) {
+	t := template.New(name).
+		Delims(goTemplateLeftDelim, goTemplateRightDelim).
+		Funcs(templateFuncs()).
+		Option("missingkey=zero")
+
+	if strict {
+		t = t.Option("missingkey=error")
+	}
+
+	t, err := t.Parse(file)
+
+	if err != nil {
+		return "", fmt.Errorf("unable to parse nomad file template: %s", err)
+	}
+
+	var out bytes.Buffer
+
+	err = t.Execute(&out, context)
+
+	if err != nil {
+		return "", fmt.Errorf("unable to render nomad file template: %s", err)
+	}
+
+	return out.String(), nil
+}
+
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"default":  templateDefault,
+		"required": templateRequired,
+		"toJson":   templateToJSON,
+		"env":      os.Getenv,
+		"indent":   templateIndent,
+	}
+}
+
+// templateDefault returns value, or def if value is empty.
+func templateDefault(def interface{}, value ...interface{}) interface{} {
+	if len(value) == 0 || isEmptyValue(value[0]) {
+		return def
+	}
+
+	return value[0]
+}
+
+// templateRequired fails rendering with message if value is empty.
+func templateRequired(message string, value interface{}) (interface{}, error) {
+	if isEmptyValue(value) {
+		return nil, errors.New(message)
+	}
+
+	return value, nil
+}
+
+func templateToJSON(value interface{}) (string, error) {
+	out, err := json.Marshal(value)
+
+	return string(out), err
+}
+
+// templateIndent prefixes every line of value with the given number of spaces.
+func templateIndent(spaces int, value string) string {
+	pad := strings.Repeat(" ", spaces)
+
+	return pad + strings.Replace(value, "\n", "\n"+pad, -1)
+}
+
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.String, reflect.Array, reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package command
+
+import (
+	"github.com/mitchellh/cli"
+	"github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/secret"
+)
+
+// redactedUI hides sensitive values, such as resolved secrets, before they are output.
+type redactedUI struct {
+	cli.Ui
+	redactor *secret.Redactor
+}
+
+func (u *redactedUI) Output(message string) { u.Ui.Output(u.redactor.Redact(message)) }
+
+func (u *redactedUI) Info(message string) { u.Ui.Info(u.redactor.Redact(message)) }
+
+func (u *redactedUI) Warn(message string) { u.Ui.Warn(u.redactor.Redact(message)) }
+
+func (u *redactedUI) Error(message string) { u.Ui.Error(u.redactor.Redact(message)) }
+
+// newRedactor creates a redactor for the config, hiding the values of sensitive variables, build args, script env and task env
+// across every environment, along with every resolved secret.
+func newRedactor(conf config.Config, secrets *secret.Resolver) *secret.Redactor {
+	redactor := secret.NewRedactor(conf.Redact.Keys, secrets)
+
+	addBuilds := func(builds map[string]config.Build) {
+		for _, build := range builds {
+			redactor.AddValues(build.BuildArgValues())
+			redactor.AddValues(build.Env)
+
+			for _, arg := range build.BuildArgs {
+				if arg.Secret {
+					redactor.AddValue(arg.Value)
+				}
+			}
+		}
+	}
+
+	for _, deployment := range conf.Deployments {
+		redactor.AddValues(deployment.Variables)
+		addBuilds(deployment.Builds)
+
+		if deployment.Job == nil {
+			continue
+		}
+
+		for _, group := range deployment.Job.Groups {
+			for _, task := range group.Tasks {
+				redactor.AddValues(task.Env)
+			}
+		}
+	}
+
+	for _, environment := range conf.Environments {
+		redactor.AddValues(environment.Variables)
+
+		for _, override := range environment.Overrides {
+			redactor.AddValues(override.Variables)
+
+			builds := map[string]config.Build{}
+
+			for name, build := range override.Builds {
+				builds[name] = config.Build{BuildArgs: build.BuildArgs, Env: build.Env}
+			}
+
+			addBuilds(builds)
+		}
+	}
+
+	return redactor
+}
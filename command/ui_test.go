@@ -0,0 +1,59 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactedUI(t *testing.T) {
+	secrets := secret.NewResolver(map[string]secret.Provider{"vault": secret.NewMemoryProvider(map[string]string{"app#password": "hunter2"})})
+	ui := cli.NewMockUi()
+	redacted := &redactedUI{Ui: ui, redactor: secret.NewRedactor(nil, secrets)}
+
+	redacted.Output("password: hunter2")
+
+	secrets.Resolve("vault:app#password")
+
+	redacted.Output("password: hunter2")
+	redacted.Error("failed with hunter2")
+
+	assert.Equal(t, "password: hunter2\npassword: ****\n", ui.OutputWriter.String())
+	assert.Equal(t, "failed with ****\n", ui.ErrorWriter.String())
+}
+
+func TestNewRedactor(t *testing.T) {
+	conf := config.Config{
+		Redact: config.Redact{Keys: []string{"*_dsn"}},
+		Deployments: map[string]config.Deployment{
+			"web": {
+				Variables: map[string]string{"sentry_dsn": "https://key@sentry.io/1", "log_level": "debug"},
+				Builds: map[string]config.Build{
+					"app": {BuildArgs: map[string]config.BuildArg{
+						"NPM_AUTH": {Value: "npm-secret", Secret: true},
+						"VERSION":  {Value: "1.2.0"},
+					}},
+				},
+			},
+		},
+		Environments: map[string]config.Environment{
+			"production": {
+				Variables: map[string]string{"api_token": "production-token"},
+				Overrides: map[string]config.DeploymentOverride{
+					"web": {Variables: map[string]string{"db_password": "correct-horse"}},
+				},
+			},
+		},
+	}
+
+	redactor := newRedactor(conf, nil)
+
+	assert.Equal(
+		t,
+		"**** debug **** 1.2.0 **** ****",
+		redactor.Redact("https://key@sentry.io/1 debug npm-secret 1.2.0 production-token correct-horse"),
+	)
+}
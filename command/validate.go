@@ -0,0 +1,348 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	nomadAPI "github.com/hashicorp/nomad/api"
+	config "github.com/pm-connect/tent/config"
+	"github.com/pm-connect/tent/nomad"
+	"github.com/pm-connect/tent/secret"
+	"github.com/valyala/fasttemplate"
+)
+
+// ValidateCommand checks the config, nomad files and builds without deploying anything.
+type ValidateCommand struct {
+	Meta
+}
+
+// Help displays help output for the command.
+func (c *ValidateCommand) Help() string {
+	helpText := `
+Usage: tent validate [-env=]
+
+	Validate checks the config, nomad files and builds without deploying anything.
+
+	Without -env, every environment is checked offline. Every nomad file must exist and render,
+	every variable used must be declared, and every dockerfile, file and script must exist.
+
+	-env=
+		Only check the given environment, and also have nomad validate the jobs. Secrets used by
+		the environment are resolved, so the secret providers must be reachable.
+
+General Options:
+
+    ` + generalOptionsUsage() + `
+    `
+
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis displays the command synopsis.
+func (c *ValidateCommand) Synopsis() string { return "Check the config and nomad files." }
+
+// Name returns the name of the command.
+func (c *ValidateCommand) Name() string { return "validate" }
+
+// validationProblems collects problems per deployment, along with the environments they were found in.
+type validationProblems map[string]map[string][]string
+
+func (p validationProblems) add(deployment string, environment string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+
+	if p[deployment] == nil {
+		p[deployment] = map[string][]string{}
+	}
+
+	p[deployment][message] = append(p[deployment][message], environment)
+}
+
+// Run validates the project.
+func (c *ValidateCommand) Run(args []string) int {
+	var verbose bool
+	var environment string
+
+	flags := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	flags.BoolVar(&verbose, "verbose", false, "Turn on verbose output.")
+	flags.StringVar(&environment, "env", "", "Specify the environment to validate against nomad.")
+	err := flags.Parse(args)
+
+	if err != nil {
+		c.UI.Error(fmt.Sprint(err))
+		return 1
+	}
+
+	environments := []string{}
+
+	if len(environment) > 0 {
+		if _, ok := c.Config.Environments[environment]; !ok {
+			c.UI.Error(fmt.Sprintf("Unable to find any environment config for environment: %s", environment))
+			return 1
+		}
+
+		environments = append(environments, environment)
+	} else {
+		for name := range c.Config.Environments {
+			environments = append(environments, name)
+		}
+
+		sort.Strings(environments)
+	}
+
+	problems := validationProblems{}
+
+	for _, env := range environments {
+		if verbose {
+			c.UI.Output(fmt.Sprintf("===> Validating environment: %s", env))
+		}
+
+		merged, _ := c.Config.ForEnvironment(env)
+
+		var nomadClient nomad.Client
+		var secrets *secret.Resolver
+
+		if len(environment) > 0 {
+			nomadClient, err = nomad.NewDefaultClient(generateNomadURL(merged.Environments[env].NomadURL), 5)
+
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Unable to create nomad client: %s", err))
+				return 1
+			}
+
+			secrets = c.Secrets
+		}
+
+		for _, name := range sortedDeploymentNames(merged.Deployments) {
+			validateDeployment(merged.Name, name, merged.Deployments[name], env, merged.Environments[env], nomadClient, secrets, problems)
+		}
+	}
+
+	if len(problems) == 0 {
+		c.UI.Info("===> Configuration is valid.")
+		return 0
+	}
+
+	for _, name := range sortedProblemKeys(problems) {
+		messages := []string{}
+
+		for message := range problems[name] {
+			messages = append(messages, message)
+		}
+
+		sort.Strings(messages)
+
+		for _, message := range messages {
+			envs := problems[name][message]
+
+			if len(environments) > 1 && len(envs) < len(environments) {
+				message = fmt.Sprintf("%s (%s)", message, strings.Join(envs, ", "))
+			}
+
+			c.UI.Error(fmt.Sprintf("===> [%s] %s", name, message))
+		}
+	}
+
+	c.UI.Error("Exiting with errors.")
+
+	return 1
+}
+
+// validateDeployment checks a single deployment, having nomad validate the job if a client is given.
+//
+// Secrets are only resolved when a resolver is given.
+func validateDeployment(serviceName string, name string, deployment config.Deployment, environment string, envConfig config.Environment, nomadClient nomad.Client, secrets *secret.Resolver, problems validationProblems) {
+	for _, buildName := range sortedBuildNames(deployment.Builds) {
+		for _, problem := range validateBuild(deployment.Builds[buildName]) {
+			problems.add(name, environment, "build %s: %s", buildName, problem)
+		}
+	}
+
+	var job *nomadAPI.Job
+
+	if deployment.Job != nil {
+		generated, err := generateJob(serviceName, name, deployment, map[string]int{}, envConfig, secrets, false)
+
+		if err != nil {
+			problems.add(name, environment, "unable to generate job: %s", err)
+			return
+		}
+
+		job = generated
+	} else {
+		jobName := generateJobName(deployment.ServiceName, serviceName, name)
+		nomadFile := generateNomadFileName(deployment.NomadFile, jobName)
+
+		contents, sources, err := loadNomadFile(nomadFile)
+
+		if err != nil {
+			problems.add(name, environment, "%s", err)
+			return
+		}
+
+		rendered, ok := renderNomadFileStrict(contents, sources, serviceName, name, deployment, environment, envConfig, secrets, problems)
+
+		if !ok || nomadClient == nil {
+			return
+		}
+
+		job, err = nomadClient.ParseJob(rendered)
+
+		if err != nil {
+			problems.add(name, environment, "nomad was unable to parse the job: %s", jobSources(deployment, sources).translate(err))
+			return
+		}
+	}
+
+	if nomadClient == nil {
+		return
+	}
+
+	validation, err := nomadClient.ValidateJob(job)
+
+	if err != nil {
+		problems.add(name, environment, "unable to validate the job with nomad: %s", err)
+		return
+	}
+
+	for _, validationError := range validation.ValidationErrors {
+		problems.add(name, environment, "nomad: %s", validationError)
+	}
+}
+
+// renderNomadFileStrict renders a nomad file, recording every variable that is not declared.
+func renderNomadFileStrict(contents string, sources sourceLines, serviceName string, name string, deployment config.Deployment, environment string, envConfig config.Environment, secrets *secret.Resolver, problems validationProblems) (string, bool) {
+	if deployment.TemplateEngine == "go" {
+		context, err := resolveTemplateContext(newTemplateContext(contents, serviceName, name, deployment, map[string]int{}, envConfig), secrets)
+
+		if err != nil {
+			problems.add(name, environment, "%s", err)
+			return "", false
+		}
+
+		rendered, err := renderGoTemplate(name, contents, context, true)
+
+		if err != nil {
+			problems.add(name, environment, "%s", sources.translate(err))
+			return "", false
+		}
+
+		contents = rendered
+	}
+
+	t, err := fasttemplate.NewTemplate(contents, "[!", "!]")
+
+	if err != nil {
+		problems.add(name, environment, "unable to parse nomad file variables: %s", err)
+		return "", false
+	}
+
+	variables := nomadFileVariables(serviceName, name, deployment, map[string]int{}, envConfig)
+	ok := true
+
+	t.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+		if _, template := templateVariable(tag); template {
+			return 0, nil
+		}
+
+		if _, declared := variables[tag]; declared || (strings.HasPrefix(tag, "group_") && strings.HasSuffix(tag, "_size")) {
+			return 0, nil
+		}
+
+		ok = false
+
+		if strings.HasPrefix(tag, "image_") {
+			problems.add(name, environment, "nomad file uses [!%s!] but there is no build named %s", tag, strings.TrimPrefix(tag, "image_"))
+		} else {
+			problems.add(name, environment, "nomad file uses [!%s!] which is not declared", tag)
+		}
+
+		return 0, nil
+	})
+
+	if !ok {
+		return "", false
+	}
+
+	rendered, err := parseNomadFile(contents, serviceName, name, deployment, map[string]int{}, envConfig, secrets)
+
+	if err != nil {
+		problems.add(name, environment, "%s", sources.translate(err))
+		return "", false
+	}
+
+	return rendered, true
+}
+
+// validateBuild checks that the files used by a build exist.
+func validateBuild(build config.Build) []string {
+	problems := []string{}
+
+	if len(build.Script) > 0 {
+		if !fileExists(build.Script) {
+			problems = append(problems, fmt.Sprintf("script %s does not exist", build.Script))
+		}
+
+		if info, err := os.Stat(build.Workdir); len(build.Workdir) > 0 && (err != nil || !info.IsDir()) {
+			problems = append(problems, fmt.Sprintf("workdir %s is not a directory", build.Workdir))
+		}
+
+		return problems
+	}
+
+	context := build.Context
+
+	if len(context) == 0 {
+		context = "."
+	}
+
+	if info, err := os.Stat(context); err != nil || !info.IsDir() {
+		problems = append(problems, fmt.Sprintf("context %s is not a directory", context))
+	}
+
+	file := build.File
+
+	if len(file) == 0 {
+		file = filepath.Join(context, "Dockerfile")
+	}
+
+	if !fileExists(file) {
+		problems = append(problems, fmt.Sprintf("dockerfile %s does not exist", file))
+	}
+
+	return problems
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+
+	return err == nil && !info.IsDir()
+}
+
+func sortedBuildNames(builds map[string]config.Build) []string {
+	names := []string{}
+
+	for name := range builds {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func sortedProblemKeys(problems validationProblems) []string {
+	names := []string{}
+
+	for name := range problems {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,90 @@
+package command
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Flaque/filet"
+	nomadAPI "github.com/hashicorp/nomad/api"
+	"github.com/pm-connect/tent/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestValidateDeployment(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+	nomadFile := filepath.Join(dir, "app-web.nomad")
+
+	filet.File(t, nomadFile, `job "[!job_name!]" {
+    image = "[!image_app!]"
+    other = "[!image_missing!]"
+    level = "[!var_level!]"
+    region = "[!env_region!]"
+}`)
+
+	problems := validationProblems{}
+
+	validateDeployment("app", "web", config.Deployment{
+		NomadFile: nomadFile,
+		Variables: map[string]string{"level": "info"},
+		Builds: map[string]config.Build{
+			"app": {Name: "test", DeployTag: "latest", Context: dir},
+		},
+	}, "production", config.Environment{}, nil, nil, problems)
+
+	assert.Equal(t, map[string][]string{
+		"build app: dockerfile " + filepath.Join(dir, "Dockerfile") + " does not exist": {"production"},
+		"nomad file uses [!image_missing!] but there is no build named missing":         {"production"},
+		"nomad file uses [!env_region!] which is not declared":                          {"production"},
+	}, problems["web"])
+}
+
+func TestValidateDeploymentWithMissingNomadFile(t *testing.T) {
+	problems := validationProblems{}
+
+	validateDeployment("app", "web", config.Deployment{NomadFile: "/does/not/exist.nomad"}, "production", config.Environment{}, nil, nil, problems)
+
+	assert.Len(t, problems["web"], 1)
+}
+
+func TestValidateDeploymentWithStrictGoTemplate(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+	nomadFile := filepath.Join(dir, "app-web.nomad")
+
+	filet.File(t, nomadFile, `job "[[ .JobName ]]" { level = "[[ .Vars.level ]]" }`)
+
+	problems := validationProblems{}
+
+	validateDeployment("app", "web", config.Deployment{NomadFile: nomadFile, TemplateEngine: "go"}, "production", config.Environment{}, nil, nil, problems)
+
+	assert.Len(t, problems["web"], 1)
+}
+
+func TestValidateDeploymentAgainstNomad(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+	nomadFile := filepath.Join(dir, "app-web.nomad")
+
+	filet.File(t, nomadFile, `job "[!job_name!]" {}`)
+
+	jobID := "app-web"
+	job := &nomadAPI.Job{ID: &jobID}
+
+	nomadClient := new(mockNomadClient)
+	nomadClient.On("ParseJob", `job "app-web" {}`).Return(job, nil)
+	nomadClient.On("ValidateJob", mock.Anything).Return(&nomadAPI.JobValidateResponse{
+		ValidationErrors: []string{"missing datacenters"},
+	}, nil)
+
+	problems := validationProblems{}
+
+	validateDeployment("app", "web", config.Deployment{NomadFile: nomadFile}, "production", config.Environment{}, nomadClient, nil, problems)
+
+	nomadClient.AssertExpectations(t)
+	assert.Equal(t, map[string][]string{"nomad: missing datacenters": {"production"}}, problems["web"])
+}
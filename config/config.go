@@ -3,32 +3,198 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/a8m/envsubst"
 	validator "gopkg.in/go-playground/validator.v9"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 // Environment configuration.
 type Environment struct {
-	NomadURL  string            `yaml:"nomad_url" validate:"required,url"`
-	Variables map[string]string `yaml:"variables"`
+	NomadURL  string                        `yaml:"nomad_url" validate:"required,url"`
+	Variables map[string]string             `yaml:"variables"`
+	Protected bool                          `yaml:"protected"`
+	Overrides map[string]DeploymentOverride `yaml:"overrides" validate:"dive"`
 }
 
 // Build configuration.
 type Build struct {
-	Context     string   `yaml:"context"`
-	RegistryURL string   `yaml:"registry_url"`
-	Name        string   `yaml:"name" validate:"omitempty,min=3"`
-	Tags        []string `yaml:"tags"`
-	Push        bool     `yaml:"push"`
-	Target      string   `yaml:"target" validate:"omitempty,alphanum"`
-	File        string   `yaml:"file" validate:"omitempty,file"`
-	DeployTag   string   `yaml:"deploy_tag"`
-	Script      string   `yaml:"script"`
-	BuildArgs   map[string]string `yaml:"build_args"`
+	Context     string                 `yaml:"context"`
+	RegistryURL string                 `yaml:"registry_url"`
+	Name        string                 `yaml:"name" validate:"omitempty,min=3"`
+	Tags        []string               `yaml:"tags"`
+	Push        bool                   `yaml:"push"`
+	Target      string                 `yaml:"target" validate:"omitempty,alphanum"`
+	File        string                 `yaml:"file" validate:"omitempty,file"`
+	DeployTag   string                 `yaml:"deploy_tag"`
+	Script      string                 `yaml:"script"`
+	Shell       string                 `yaml:"shell"`
+	Args        []string               `yaml:"args"`
+	Workdir     string                 `yaml:"workdir"`
+	Env         map[string]string      `yaml:"env"`
+	Paths       []string               `yaml:"paths"`
+	BuildArgs   map[string]BuildArg    `yaml:"build_args"`
+	Labels      map[string]string      `yaml:"labels"`
+	Secrets     map[string]BuildSecret `yaml:"secrets"`
+	SSH         string                 `yaml:"ssh"`
+	Builder     string                 `yaml:"builder" validate:"omitempty,oneof=docker podman buildah kaniko"`
+
+	// Outputs are reported by the build script, eg, image and digest, rather than read from the config.
+	Outputs map[string]string `yaml:"-"`
+}
+
+// BuildSecret is mounted into a build with RUN --mount=type=secret,id={id}, keeping it out of the image and its
+// history, eg:
+//
+//	secrets:
+//	  npmrc:
+//	    file: ~/.npmrc
+//	  github_token:
+//	    env: GITHUB_TOKEN
+type BuildSecret struct {
+	File string `yaml:"file"`
+	Env  string `yaml:"env"`
+}
+
+// BuildArg is a docker build argument, given either as a value or as a mapping marking it as secret, eg:
+//
+//	build_args:
+//	  VERSION: 1.2.0
+//	  NPM_TOKEN:
+//	    value: ${NPM_TOKEN}
+//	    secret: true
+type BuildArg struct {
+	Value  string `yaml:"value"`
+	Secret bool   `yaml:"secret"`
+}
+
+// UnmarshalYAML decodes a build argument from either of its forms.
+//
+// The unmarshal function is used rather than a node so that the decoder carries on past any errors.
+func (a *BuildArg) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	scalarErr := unmarshal(&a.Value)
+
+	if scalarErr == nil {
+		return nil
+	}
+
+	fields := map[string]yaml.Node{}
+
+	if err := unmarshal(&fields); err != nil {
+		line := ""
+
+		if typeErr, ok := scalarErr.(*yaml.TypeError); ok && len(typeErr.Errors) > 0 {
+			line = strings.SplitN(typeErr.Errors[0], ": ", 2)[0] + ": "
+		}
+
+		return &yaml.TypeError{Errors: []string{line + "build args must be a value or a mapping of value and secret"}}
+	}
+
+	errors := []string{}
+
+	for _, key := range sortedNodeKeys(fields) {
+		node := fields[key]
+		var err error
+
+		switch key {
+		case "value":
+			err = node.Decode(&a.Value)
+		case "secret":
+			err = node.Decode(&a.Secret)
+		default:
+			errors = append(errors, fmt.Sprintf("line %d: field %s not found in type config.BuildArg", node.Line, key))
+		}
+
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			errors = append(errors, typeErr.Errors...)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(errors) > 0 {
+		return &yaml.TypeError{Errors: errors}
+	}
+
+	return nil
+}
+
+func sortedNodeKeys(nodes map[string]yaml.Node) []string {
+	keys := []string{}
+
+	for key := range nodes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// BuildArgValues returns the value of each build argument.
+func (b Build) BuildArgValues() map[string]string {
+	if b.BuildArgs == nil {
+		return nil
+	}
+
+	values := map[string]string{}
+
+	for name, arg := range b.BuildArgs {
+		values[name] = arg.Value
+	}
+
+	return values
+}
+
+// Job configuration, used to generate a nomad job instead of loading a nomad file.
+type Job struct {
+	Type        string           `yaml:"type" validate:"omitempty,oneof=service batch system"`
+	Datacenters []string         `yaml:"datacenters" validate:"required,min=1"`
+	Groups      map[string]Group `yaml:"groups" validate:"required,min=1,dive"`
+}
+
+// Group configuration for a generated nomad job.
+type Group struct {
+	Count *int            `yaml:"count" validate:"omitempty,min=0"`
+	Tasks map[string]Task `yaml:"tasks" validate:"required,min=1,dive"`
+}
+
+// Task configuration for a generated nomad job.
+type Task struct {
+	Build     string            `yaml:"build"`
+	Image     string            `yaml:"image"`
+	Env       map[string]string `yaml:"env"`
+	Resources Resources         `yaml:"resources"`
+	Ports     map[string]int    `yaml:"ports"`
+	Services  []Service         `yaml:"services" validate:"dive"`
+}
+
+// Resources configuration for a generated nomad task.
+type Resources struct {
+	CPU    int `yaml:"cpu" validate:"omitempty,min=1"`
+	Memory int `yaml:"memory" validate:"omitempty,min=1"`
+}
+
+// Service configuration for a generated nomad task.
+type Service struct {
+	Name   string   `yaml:"name"`
+	Port   string   `yaml:"port"`
+	Tags   []string `yaml:"tags"`
+	Checks []Check  `yaml:"checks" validate:"dive"`
+}
+
+// Check configuration for a generated nomad service.
+type Check struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type" validate:"required,oneof=http tcp script grpc"`
+	Path     string `yaml:"path"`
+	Interval string `yaml:"interval"`
+	Timeout  string `yaml:"timeout"`
 }
 
 // Deployment Configuration.
@@ -38,17 +204,60 @@ type Deployment struct {
 	StartInstances int               `yaml:"start_instances" validate:"omitempty,min=1,max=10"`
 	Variables      map[string]string `yaml:"variables"`
 	ServiceName    string            `yaml:"service_name" validate:"omitempty,min=3"`
+	TemplateEngine string            `yaml:"template_engine" validate:"omitempty,oneof=tent go"`
+	Job            *Job              `yaml:"job"`
+	Paths          []string          `yaml:"paths"`
+	DependsOn      []string          `yaml:"depends_on"`
+
+	// Templates are the values of the {git.sha} style templates for the repository of the file declaring the
+	// deployment.
+	Templates *Templates `yaml:"-"`
+
+	// File is the config file declaring the deployment, if it was loaded from a file.
+	File string `yaml:"-"`
+}
+
+// Lock configuration.
+type Lock struct {
+	Backend string `yaml:"backend" validate:"omitempty,oneof=file"`
+	Path    string `yaml:"path"`
+	Scope   string `yaml:"scope" validate:"omitempty,oneof=job environment"`
+	TTL     string `yaml:"ttl"`
+}
+
+// PushRetry configures how pushes that fail with a transient registry error, such as a 502, are retried.
+type PushRetry struct {
+	// Attempts is how many times each tag is pushed before giving up, including the first.
+	Attempts int `yaml:"attempts" validate:"omitempty,min=1"`
+	// Backoff is how long to wait before the first retry, doubling after each one.
+	Backoff string `yaml:"backoff"`
 }
 
 // Config for the overall setup.
 type Config struct {
 	Name         string                 `yaml:"name" validate:"required,min=3"`
 	Concurrent   bool                   `yaml:"concurrent"`
+	Builder      string                 `yaml:"builder" validate:"omitempty,oneof=docker podman buildah kaniko"`
 	Environments map[string]Environment `yaml:"environments" validate:"required,dive"`
 	Deployments  map[string]Deployment  `yaml:"deployments" validate:"required,dive"`
+	Lock         Lock                   `yaml:"lock"`
+	PushRetry    PushRetry              `yaml:"push_retry"`
+	Include      []string               `yaml:"include"`
+	Redact       Redact                 `yaml:"redact"`
+
+	// File is the config file that was loaded, if any.
+	File string `yaml:"-"`
+}
+
+// Redact configuration, for values to hide from output.
+type Redact struct {
+	// Keys are patterns, such as *_token, matched against the names of variables, build args and task env.
+	Keys []string `yaml:"keys"`
 }
 
 // LoadFromFile generates the config from a given yaml file.
+//
+// Every problem found within the config is returned together as ValidationErrors.
 func LoadFromFile(file string) (Config, error) {
 	data, err := ioutil.ReadFile(file)
 
@@ -56,135 +265,451 @@ func LoadFromFile(file string) (Config, error) {
 		return Config{}, err
 	}
 
-	config, err := parseConfig(data)
+	config, err := parseConfigFile(data, file)
 
 	return config, err
 }
 
 func parseConfig(data []byte) (Config, error) {
-	config := Config{}
+	return parseConfigFile(data, "")
+}
 
-	err := yaml.Unmarshal(data, &config)
+// parseConfigFile parses the config, resolving relative paths and includes against the directory of the file.
+func parseConfigFile(data []byte, file string) (Config, error) {
+	config := Config{}
+	errs := &errorCollector{}
+	dir := filepath.Dir(file)
 
-	if err != nil {
-		return config, err
+	if !decodeConfigFile(data, file, &config, errs) {
+		return config, errs.err()
 	}
 
-	tmpName, _ := envsubst.String(config.Name)
-	config.Name = tmpName
+	config.Name = errs.envsubst("name", config.Name)
 
 	for k, env := range config.Environments {
 		var x = env
-		tmpNomadURL, _ := envsubst.String(env.NomadURL)
-		x.NomadURL = tmpNomadURL
-
-		newVariables := x.Variables
-
-		for key, value := range x.Variables {
-			newValue, _ := envsubst.String(value)
+		path := "environments." + k
 
-			newVariables[key] = newValue
+		x.NomadURL = errs.envsubst(path+".nomad_url", env.NomadURL)
+		if x.Variables != nil {
+			x.Variables = normalizeVariables(x.Variables, path+".variables", errs)
 		}
 
-		x.Variables = newVariables
+		for name, override := range x.Overrides {
+			x.Overrides[name] = normalizeDeploymentOverride(override, dir, path+".overrides."+name, errs)
+		}
 
 		config.Environments[k] = x
 	}
 
-	for k, dep := range config.Deployments {
-		var x = dep
+	config.File = resolvePath(".", file)
 
-		for key, build := range x.Builds {
-			var b = build
+	normalizeDeployments(config.Deployments, config.File, errs)
 
-			tmpRegistryURL, _ := envsubst.String(b.RegistryURL)
-			b.RegistryURL = tmpRegistryURL
+	loadIncludes(&config, dir, errs)
 
-			tmpBuildName, _ := envsubst.String(b.Name)
-			b.Name = strings.ToLower(tmpBuildName)
+	config.Lock.Path = resolvePath(dir, errs.envsubst("lock.path", config.Lock.Path))
 
-			tmpTarget, _ := envsubst.String(b.Target)
-			b.Target = tmpTarget
+	if len(config.Lock.TTL) > 0 {
+		if _, err := time.ParseDuration(config.Lock.TTL); err != nil {
+			errs.add("lock.ttl", "must be a duration (eg, 30m), got '%s'", config.Lock.TTL)
+		}
+	}
 
-			tmpDeployTag, _ := envsubst.String(b.DeployTag)
-			b.DeployTag = strings.ToLower(strings.Replace(tmpDeployTag, "/", "-", -1))
+	if len(config.PushRetry.Backoff) > 0 {
+		if _, err := time.ParseDuration(config.PushRetry.Backoff); err != nil {
+			errs.add("push_retry.backoff", "must be a duration (eg, 2s), got '%s'", config.PushRetry.Backoff)
+		}
+	}
 
-			var newTags []string
+	validate := *validator.New()
 
-			for _, tag := range b.Tags {
-				tmpTag, _ := envsubst.String(tag)
-				newTags = append(newTags, strings.ToLower(strings.Replace(tmpTag, "/", "-", -1)))
-			}
+	errs.addValidatorErrors(config, validate.Struct(config))
+
+	for envName, env := range config.Environments {
+		validateOverrides(envName, env, config.Deployments, errs)
+	}
+
+	validateDependencies(config.Deployments, errs)
+	validateOverrideBuilds(config, errs)
+
+	for name, dep := range config.Deployments {
+		if dep.Job != nil {
+			validateJob(name, dep, errs)
+		}
+
+		for buildName, build := range dep.Builds {
+			path := "deployments." + name + ".builds." + buildName
+
+			validateSecrets(path, build, errs)
 
-			if len(b.Script) > 0 {
-				b.Script, _ = filepath.Abs(b.Script)
+			if len(build.Script) > 0 {
+				validateBuilderOnlyOptions(path, build, errs)
+				continue
 			}
 
-			newBuildArgs := map[string]string{}
+			validateScriptOptions(path, build, errs)
+			validateBuilderSupport(path, build, config.Builder, errs)
 
-			for k, v := range b.BuildArgs {
-				nv, _ := envsubst.String(v)
-				newBuildArgs[k] = nv
+			if len(build.Name) == 0 {
+				errs.add(path+".name", "is required when no script is given")
 			}
 
-			b.Tags = newTags
-			b.BuildArgs = newBuildArgs
+			if len(build.DeployTag) == 0 {
+				errs.add(path+".deploy_tag", "is required when no script is given")
+			}
+		}
+	}
 
-			x.Builds[key] = b
+	return config, errs.err()
+}
+
+// validateDependencies checks that depends_on refers to configured deployments, without any cycles.
+func validateDependencies(deployments map[string]Deployment, errs *errorCollector) {
+	names := []string{}
+
+	for name := range deployments {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for i, dependency := range deployments[name].DependsOn {
+			if _, ok := deployments[dependency]; !ok {
+				errs.add(fmt.Sprintf("deployments.%s.depends_on.%d", name, i), "must be one of the deployments, got '%s'", dependency)
+			}
 		}
+	}
+
+	visited := map[string]bool{}
+
+	var visit func(name string, stack []string) bool
 
-		x.NomadFile, _ = envsubst.String(x.NomadFile)
+	visit = func(name string, stack []string) bool {
+		for i, parent := range stack {
+			if parent == name {
+				errs.add("deployments."+stack[0]+".depends_on", "has a dependency cycle: %s", strings.Join(append(stack[i:], name), " -> "))
+				return false
+			}
+		}
 
-		if len(x.NomadFile) > 0 {
-			x.NomadFile, _ = filepath.Abs(x.NomadFile)
+		if visited[name] {
+			return true
 		}
 
-		x.ServiceName, _ = envsubst.String(x.ServiceName)
+		visited[name] = true
 
-		newVariables := x.Variables
+		for _, dependency := range deployments[name].DependsOn {
+			if _, ok := deployments[dependency]; ok && !visit(dependency, append(stack, name)) {
+				return false
+			}
+		}
 
-		for key, value := range x.Variables {
-			newValue, _ := envsubst.String(value)
+		return true
+	}
 
-			newVariables[key] = newValue
+	for _, name := range names {
+		visit(name, nil)
+	}
+}
+
+// validateScriptOptions reports the script options set on a build without a script.
+func validateScriptOptions(path string, build Build, errs *errorCollector) {
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"shell", len(build.Shell) > 0},
+		{"args", len(build.Args) > 0},
+		{"workdir", len(build.Workdir) > 0},
+		{"env", len(build.Env) > 0},
+	} {
+		if option.set {
+			errs.add(path+"."+option.name, "is only used with a script")
 		}
+	}
+}
 
-		x.Variables = newVariables
+// validateBuilderOnlyOptions reports the options set on a script build that are only passed to a builder.
+func validateBuilderOnlyOptions(path string, build Build, errs *errorCollector) {
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"labels", len(build.Labels) > 0},
+		{"secrets", len(build.Secrets) > 0},
+		{"ssh", len(build.SSH) > 0},
+	} {
+		if option.set {
+			errs.add(path+"."+option.name, "is not used with a script")
+		}
+	}
+}
 
-		config.Deployments[k] = x
+// validateSecrets checks that each secret of a build is read from either a file or an environment variable.
+func validateSecrets(path string, build Build, errs *errorCollector) {
+	for id, secret := range build.Secrets {
+		if (len(secret.File) > 0) == (len(secret.Env) > 0) {
+			errs.add(path+".secrets."+id, "must have either a file or an env")
+		}
 	}
+}
 
-	validate := *validator.New()
+// validateBuilderSupport reports the options of a build that its builder is unable to pass to the build, as kaniko
+// can neither mount secrets nor forward ssh.
+func validateBuilderSupport(path string, build Build, defaultBuilder string, errs *errorCollector) {
+	builder := build.Builder
 
-	err = validate.Struct(config)
+	if len(builder) == 0 {
+		builder = defaultBuilder
+	}
 
-	if err != nil {
-		for _, err := range err.(validator.ValidationErrors) {
-			return config, fmt.Errorf("expected field '%s' validation to match '%s' but got value '%s'", err.StructField(), err.Tag(), err.Value())
+	if builder != "kaniko" {
+		return
+	}
+
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"secrets", len(build.Secrets) > 0},
+		{"ssh", len(build.SSH) > 0},
+	} {
+		if option.set {
+			errs.add(path+"."+option.name, "is not supported by the %s builder", builder)
 		}
 	}
+}
 
-	for _, dep := range config.Deployments {
-		for _, build := range dep.Builds {
-			if len(build.Script) == 0 {
-				err = validate.Var(build.Name, "required,min=3")
+// validateOverrideBuilds checks the secrets of build overrides, and the builder support of builds once the
+// overrides of each environment are merged in, as an override may change the builder, the secrets or ssh.
+func validateOverrideBuilds(config Config, errs *errorCollector) {
+	for envName, env := range config.Environments {
+		merged, _ := config.ForEnvironment(envName)
 
-				if err != nil {
-					for _, err := range err.(validator.ValidationErrors) {
-						return config, fmt.Errorf("expected field '%s' validation to match '%s' but got value '%s'", err.StructField(), err.Tag(), err.Value())
-					}
+		for name, override := range env.Overrides {
+			for buildName, buildOverride := range override.Builds {
+				path := fmt.Sprintf("environments.%s.overrides.%s.builds.%s", envName, name, buildName)
+
+				validateSecrets(path, Build{Secrets: buildOverride.Secrets}, errs)
+
+				build, ok := merged.Deployments[name].Builds[buildName]
+
+				if !ok || len(build.Script) > 0 {
+					continue
 				}
 
-				err = validate.Var(build.DeployTag, "required,min=1")
+				if buildOverride.Builder == nil && buildOverride.Secrets == nil && buildOverride.SSH == nil {
+					continue
+				}
 
-				if err != nil {
-					for _, err := range err.(validator.ValidationErrors) {
-						return config, fmt.Errorf("expected field '%s' validation to match '%s' but got value '%s'", err.StructField(), err.Tag(), err.Value())
+				validateBuilderSupport(path, build, config.Builder, errs)
+			}
+		}
+	}
+}
+
+func validateJob(name string, deployment Deployment, errs *errorCollector) {
+	for groupName, group := range deployment.Job.Groups {
+		for taskName, task := range group.Tasks {
+			path := fmt.Sprintf("deployments.%s.job.groups.%s.tasks.%s", name, groupName, taskName)
+
+			if len(task.Build) == 0 && len(task.Image) == 0 {
+				errs.add(path, "must have either a build or an image")
+			}
+
+			if _, ok := deployment.Builds[task.Build]; len(task.Build) > 0 && !ok {
+				errs.add(path+".build", "must be one of the builds of the deployment, got '%s'", task.Build)
+			}
+
+			for i, service := range task.Services {
+				for j, check := range service.Checks {
+					checkPath := fmt.Sprintf("%s.services.%d.checks.%d", path, i, j)
+
+					if _, err := time.ParseDuration(check.Interval); len(check.Interval) > 0 && err != nil {
+						errs.add(checkPath+".interval", "must be a duration (eg, 10s), got '%s'", check.Interval)
+					}
+
+					if _, err := time.ParseDuration(check.Timeout); len(check.Timeout) > 0 && err != nil {
+						errs.add(checkPath+".timeout", "must be a duration (eg, 2s), got '%s'", check.Timeout)
 					}
 				}
 			}
 		}
 	}
+}
 
-	return config, err
+// normalizeDeployments resolves the deployments declared within file, which is empty when the config was not
+// loaded from a file.
+func normalizeDeployments(deployments map[string]Deployment, file string, errs *errorCollector) {
+	dir := filepath.Dir(file)
+
+	for k, dep := range deployments {
+		var x = dep
+		path := "deployments." + k
+
+		x.File = file
+
+		for key, build := range x.Builds {
+			x.Builds[key] = normalizeBuild(build, dir, path+".builds."+key, errs)
+		}
+
+		x.NomadFile = normalizeNomadFile(x.NomadFile, dir, path+".nomad_file", errs)
+		x.ServiceName = errs.envsubst(path+".service_name", x.ServiceName)
+		x.Templates = &Templates{dir: dir}
+		x.Paths = normalizePaths(x.Paths, dir, path+".paths", errs)
+
+		if x.Variables != nil {
+			x.Variables = normalizeVariables(x.Variables, path+".variables", errs)
+		}
+
+		deployments[k] = x
+	}
+}
+
+func normalizeBuild(b Build, dir string, path string, errs *errorCollector) Build {
+	b.RegistryURL = errs.envsubst(path+".registry_url", b.RegistryURL)
+	b.Name = strings.ToLower(errs.envsubst(path+".name", b.Name))
+	b.Target = errs.envsubst(path+".target", b.Target)
+	b.DeployTag = normalizeTag(resolveTemplates(errs.envsubst(path+".deploy_tag", b.DeployTag), dir, path+".deploy_tag", errs))
+
+	var newTags []string
+
+	for i, tag := range b.Tags {
+		tagPath := fmt.Sprintf("%s.tags.%d", path, i)
+		newTags = append(newTags, normalizeTag(resolveTemplates(errs.envsubst(tagPath, tag), dir, tagPath, errs)))
+	}
+
+	b.Script = resolvePath(dir, b.Script)
+	b.Shell = errs.envsubst(path+".shell", b.Shell)
+	b.Workdir = resolvePath(dir, errs.envsubst(path+".workdir", b.Workdir))
+
+	var newArgs []string
+
+	for i, arg := range b.Args {
+		newArgs = append(newArgs, errs.envsubst(fmt.Sprintf("%s.args.%d", path, i), arg))
+	}
+
+	b.Args = newArgs
+
+	if b.Env != nil {
+		b.Env = normalizeVariables(b.Env, path+".env", errs)
+	}
+
+	if b.Labels != nil {
+		b.Labels = normalizeVariables(b.Labels, path+".labels", errs)
+	}
+
+	b.Secrets = normalizeSecrets(b.Secrets, dir, path+".secrets", errs)
+
+	if len(b.SSH) > 0 && b.SSH != "default" {
+		b.SSH = resolvePath(dir, errs.envsubst(path+".ssh", b.SSH))
+	}
+
+	b.Context = resolvePath(dir, b.Context)
+	b.File = resolvePath(dir, b.File)
+
+	b.Tags = newTags
+	b.Paths = normalizePaths(b.Paths, dir, path+".paths", errs)
+	b.BuildArgs = normalizeBuildArgs(b.BuildArgs, path+".build_args", errs)
+
+	return b
+}
+
+// normalizePaths resolves path globs against the directory of the config file.
+func normalizePaths(paths []string, dir string, path string, errs *errorCollector) []string {
+	var newPaths []string
+
+	for i, pattern := range paths {
+		newPaths = append(newPaths, resolvePath(dir, errs.envsubst(fmt.Sprintf("%s.%d", path, i), pattern)))
+	}
+
+	return newPaths
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Replace(tag, "/", "-", -1))
+}
+
+func normalizeNomadFile(file string, dir string, path string, errs *errorCollector) string {
+	return resolvePath(dir, errs.envsubst(path, file))
+}
+
+// resolvePath makes a path from the config absolute, relative to the directory of the file that declared it. A
+// leading ~/ is the home directory of the user.
+func resolvePath(dir string, path string) string {
+	if len(path) == 0 {
+		return path
+	}
+
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+		}
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	path, _ = filepath.Abs(path)
+
+	return path
+}
+
+func normalizeVariables(variables map[string]string, path string, errs *errorCollector) map[string]string {
+	newVariables := map[string]string{}
+
+	for k, v := range variables {
+		newVariables[k] = errs.envsubst(path+"."+k, v)
+	}
+
+	return newVariables
+}
+
+// normalizeSecrets resolves the files of secrets against the directory of the config file.
+func normalizeSecrets(secrets map[string]BuildSecret, dir string, path string, errs *errorCollector) map[string]BuildSecret {
+	if secrets == nil {
+		return nil
+	}
+
+	newSecrets := map[string]BuildSecret{}
+
+	for id, secret := range secrets {
+		if len(secret.File) > 0 {
+			secret.File = resolvePath(dir, errs.envsubst(path+"."+id+".file", secret.File))
+		}
+
+		newSecrets[id] = secret
+	}
+
+	return newSecrets
+}
+
+func normalizeBuildArgs(args map[string]BuildArg, path string, errs *errorCollector) map[string]BuildArg {
+	if args == nil {
+		return nil
+	}
+
+	newArgs := map[string]BuildArg{}
+
+	for k, arg := range args {
+		arg.Value = errs.envsubst(path+"."+k, arg.Value)
+		newArgs[k] = arg
+	}
+
+	return newArgs
+}
+
+// envsubst interpolates environment variables into a value, recording an error for invalid syntax.
+func (c *errorCollector) envsubst(path string, value string) string {
+	newValue, err := envsubst.String(value)
+
+	if err != nil {
+		c.add(path, "has invalid environment variable interpolation: %s", err)
+		return value
+	}
+
+	return newValue
 }
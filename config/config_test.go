@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	filet "github.com/Flaque/filet"
@@ -42,6 +43,7 @@ func TestParseConfig(t *testing.T) {
 	c, err := parseConfig([]byte(data))
 
 	expectedNomadFilePath, _ := filepath.Abs("example.nomad")
+	expectedContext, _ := filepath.Abs(".")
 
 	assert.Nil(t, err)
 	assert.Equal(t, "my-job", c.Name)
@@ -49,7 +51,7 @@ func TestParseConfig(t *testing.T) {
 	assert.Equal(t, "http://example.com", c.Environments["staging"].NomadURL)
 	assert.Equal(t, "http://example.com/prod", c.Environments["production"].NomadURL)
 	assert.Equal(t, expectedNomadFilePath, c.Deployments["web"].NomadFile)
-	assert.Equal(t, ".", c.Deployments["web"].Builds["app"].Context)
+	assert.Equal(t, expectedContext, c.Deployments["web"].Builds["app"].Context)
 	assert.Equal(t, "http://example.com", c.Deployments["web"].Builds["app"].RegistryURL)
 	assert.Equal(t, "example", c.Deployments["web"].Builds["app"].Name)
 	assert.True(t, c.Deployments["web"].Builds["app"].Push)
@@ -97,7 +99,7 @@ func TestLoadFromFile(t *testing.T) {
         nomad_url: http://example.com/prod
     deployments:
       web:
-        build:
+        builds:
           app:
             context: .
             registry_url: http://example.com
@@ -194,3 +196,647 @@ func TestConfigWithBuildScript(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedFilePath, c.Deployments["web"].Builds["app"].Script)
 }
+
+func TestConfigWithBuildScriptOptions(t *testing.T) {
+	os.Setenv("TENT_TEST_SCRIPT_ARG", "--fast")
+	defer os.Unsetenv("TENT_TEST_SCRIPT_ARG")
+
+	var data = `
+    name: my-job
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+        overrides:
+          web:
+            builds:
+              app:
+                env:
+                  TARGET: production
+    deployments:
+      web:
+        builds:
+          app:
+            script: ./example.sh
+            shell: sh
+            args:
+              - ${TENT_TEST_SCRIPT_ARG}
+            workdir: ./app
+            env:
+              TARGET: staging
+              DEBUG: "1"
+          image:
+            name: test
+            deploy_tag: latest
+            shell: sh
+        nomad_file: example.nomad
+    `
+
+	_, err := parseConfig([]byte(data))
+
+	errs, ok := err.(ValidationErrors)
+
+	assert.True(t, ok)
+	assert.Equal(t, []string{"27:20: deployments.web.builds.image.shell is only used with a script"}, errorStrings(errs))
+
+	c, err := parseConfig([]byte(strings.Replace(data, "            shell: sh\n        nomad_file", "        nomad_file", 1)))
+
+	expectedWorkdir, _ := filepath.Abs("./app")
+
+	assert.Nil(t, err)
+
+	build := c.Deployments["web"].Builds["app"]
+
+	assert.Equal(t, "sh", build.Shell)
+	assert.Equal(t, []string{"--fast"}, build.Args)
+	assert.Equal(t, expectedWorkdir, build.Workdir)
+	assert.Equal(t, map[string]string{"TARGET": "staging", "DEBUG": "1"}, build.Env)
+
+	production, _ := c.ForEnvironment("production")
+
+	assert.Equal(t, map[string]string{"TARGET": "production", "DEBUG": "1"}, production.Deployments["web"].Builds["app"].Env)
+}
+
+func TestConfigWithBuildLabels(t *testing.T) {
+	os.Setenv("TENT_TEST_TEAM", "payments")
+	defer os.Unsetenv("TENT_TEST_TEAM")
+
+	var data = `
+    name: my-job
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+        overrides:
+          web:
+            builds:
+              app:
+                labels:
+                  stage: production
+    deployments:
+      web:
+        builds:
+          app:
+            name: test
+            deploy_tag: latest
+            labels:
+              team: ${TENT_TEST_TEAM}
+              stage: staging
+          assets:
+            script: ./example.sh
+            labels:
+              team: payments
+        nomad_file: example.nomad
+    `
+
+	_, err := parseConfig([]byte(data))
+
+	errs, ok := err.(ValidationErrors)
+
+	assert.True(t, ok)
+	assert.Equal(t, []string{"23:13: deployments.web.builds.assets.labels is not used with a script"}, errorStrings(errs))
+
+	c, err := parseConfig([]byte(strings.Replace(data, "            labels:\n              team: payments\n", "", 1)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "stage": "staging"}, c.Deployments["web"].Builds["app"].Labels)
+
+	production, _ := c.ForEnvironment("production")
+
+	assert.Equal(t, map[string]string{"team": "payments", "stage": "production"}, production.Deployments["web"].Builds["app"].Labels)
+}
+
+func TestConfigWithBuildSecrets(t *testing.T) {
+	var data = `
+    name: my-job
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+        overrides:
+          web:
+            builds:
+              app:
+                secrets:
+                  npmrc:
+                    file: ./production.npmrc
+    deployments:
+      web:
+        builds:
+          app:
+            name: test
+            deploy_tag: latest
+            ssh: ./deploy_key
+            secrets:
+              npmrc:
+                file: ./.npmrc
+              github_token:
+                env: GITHUB_TOKEN
+        nomad_file: example.nomad
+    `
+
+	c, err := parseConfig([]byte(data))
+
+	expectedKey, _ := filepath.Abs("./deploy_key")
+	expectedFile, _ := filepath.Abs("./.npmrc")
+	expectedProductionFile, _ := filepath.Abs("./production.npmrc")
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedKey, c.Deployments["web"].Builds["app"].SSH)
+	assert.Equal(t, map[string]BuildSecret{
+		"npmrc":        {File: expectedFile},
+		"github_token": {Env: "GITHUB_TOKEN"},
+	}, c.Deployments["web"].Builds["app"].Secrets)
+
+	production, _ := c.ForEnvironment("production")
+
+	assert.Equal(t, map[string]BuildSecret{
+		"npmrc":        {File: expectedProductionFile},
+		"github_token": {Env: "GITHUB_TOKEN"},
+	}, production.Deployments["web"].Builds["app"].Secrets)
+
+	_, err = parseConfig([]byte(strings.Replace(data, "                env: GITHUB_TOKEN", "                env: GITHUB_TOKEN\n                file: ./token", 1)))
+
+	errs, ok := err.(ValidationErrors)
+
+	assert.True(t, ok)
+	assert.Equal(t, []string{"23:15: deployments.web.builds.app.secrets.github_token must have either a file or an env"}, errorStrings(errs))
+
+	home, _ := os.UserHomeDir()
+
+	c, err = parseConfig([]byte(strings.NewReplacer("file: ./.npmrc", "file: ~/.npmrc", "ssh: ./deploy_key", "ssh: ~/.ssh/id_ed25519").Replace(data)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(home, ".ssh", "id_ed25519"), c.Deployments["web"].Builds["app"].SSH)
+	assert.Equal(t, filepath.Join(home, ".npmrc"), c.Deployments["web"].Builds["app"].Secrets["npmrc"].File)
+}
+
+func TestConfigWithBuildSecretsForKaniko(t *testing.T) {
+	var data = `
+    name: my-job
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+        overrides:
+          web:
+            builds:
+              app:
+                builder: kaniko
+    deployments:
+      web:
+        builds:
+          app:
+            name: test
+            deploy_tag: latest
+            push: true
+            ssh: default
+          assets:
+            name: assets
+            deploy_tag: latest
+            push: true
+            builder: kaniko
+            secrets:
+              npmrc:
+                file: ./.npmrc
+        nomad_file: example.nomad
+    `
+
+	_, err := parseConfig([]byte(data))
+
+	errs, ok := err.(ValidationErrors)
+
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"9:15: environments.production.overrides.web.builds.app.ssh is not supported by the kaniko builder",
+		"24:13: deployments.web.builds.assets.secrets is not supported by the kaniko builder",
+	}, errorStrings(errs))
+}
+
+func TestParseConfigWithProtectedEnvironment(t *testing.T) {
+	var data = `
+    name: test
+    environments:
+      staging:
+        nomad_url: http://example.com
+      production:
+        nomad_url: http://example.com/prod
+        protected: true
+    deployments:
+      web:
+    `
+
+	c, err := parseConfig([]byte(data))
+
+	assert.Nil(t, err)
+	assert.False(t, c.Environments["staging"].Protected)
+	assert.True(t, c.Environments["production"].Protected)
+}
+
+func TestParseConfigWithLock(t *testing.T) {
+	var data = `
+    name: test
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+    lock:
+      backend: file
+      path: .tent/locks
+      scope: environment
+      ttl: 10m
+    `
+
+	c, err := parseConfig([]byte(data))
+
+	expectedPath, _ := filepath.Abs(".tent/locks")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "file", c.Lock.Backend)
+	assert.Equal(t, expectedPath, c.Lock.Path)
+	assert.Equal(t, "environment", c.Lock.Scope)
+	assert.Equal(t, "10m", c.Lock.TTL)
+}
+
+func TestParseConfigWithInvalidLockTTL(t *testing.T) {
+	var data = `
+    name: test
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+    lock:
+      backend: file
+      ttl: soon
+    `
+
+	_, err := parseConfig([]byte(data))
+
+	assert.NotNil(t, err)
+}
+
+func TestParseConfigWithBuildArgs(t *testing.T) {
+	os.Setenv("TENT_TEST_NPM_TOKEN", "npm-secret")
+	defer os.Unsetenv("TENT_TEST_NPM_TOKEN")
+
+	var data = `
+    name: test
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    redact:
+      keys:
+        - "*_dsn"
+    deployments:
+      web:
+        builds:
+          app:
+            name: test
+            deploy_tag: latest
+            build_args:
+              VERSION: 1.2
+              NPM_TOKEN:
+                value: ${TENT_TEST_NPM_TOKEN}
+                secret: true
+    `
+
+	c, err := parseConfig([]byte(data))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"*_dsn"}, c.Redact.Keys)
+	assert.Equal(t, map[string]BuildArg{
+		"VERSION":   {Value: "1.2"},
+		"NPM_TOKEN": {Value: "npm-secret", Secret: true},
+	}, c.Deployments["web"].Builds["app"].BuildArgs)
+	assert.Equal(t, map[string]string{"VERSION": "1.2", "NPM_TOKEN": "npm-secret"}, c.Deployments["web"].Builds["app"].BuildArgValues())
+}
+
+func TestParseConfigWithInvalidBuildArgs(t *testing.T) {
+	var data = `name: test
+environments:
+  production:
+    nomad_url: http://example.com/prod
+deployments:
+  web:
+    builds:
+      app:
+        name: test
+        deploy_tag: latest
+        build_args:
+          TOKEN:
+            value: abc
+            secrets: true
+          LIST:
+            - a
+`
+
+	_, err := parseConfig([]byte(data))
+
+	errs, ok := err.(ValidationErrors)
+
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"14:1: unknown key 'secrets' in buildarg",
+		"16:1: build args must be a value or a mapping of value and secret",
+	}, errorStrings(errs))
+}
+
+func TestParseConfigWithJob(t *testing.T) {
+	var data = `
+    name: test
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+        builds:
+          app:
+            name: test
+            deploy_tag: latest
+        job:
+          datacenters: [dc1]
+          groups:
+            web:
+              count: 2
+              tasks:
+                app:
+                  build: app
+                  ports:
+                    http: 8080
+                  services:
+                    - name: web
+                      port: http
+                      checks:
+                        - type: http
+                          path: /health
+                          interval: 10s
+    `
+
+	c, err := parseConfig([]byte(data))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"dc1"}, c.Deployments["web"].Job.Datacenters)
+	assert.Equal(t, 2, *c.Deployments["web"].Job.Groups["web"].Count)
+	assert.Equal(t, "app", c.Deployments["web"].Job.Groups["web"].Tasks["app"].Build)
+	assert.Equal(t, 8080, c.Deployments["web"].Job.Groups["web"].Tasks["app"].Ports["http"])
+}
+
+func TestParseConfigWithJobUsingUnknownBuild(t *testing.T) {
+	var data = `
+    name: test
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+        job:
+          datacenters: [dc1]
+          groups:
+            web:
+              tasks:
+                app:
+                  build: missing
+    `
+
+	_, err := parseConfig([]byte(data))
+
+	assert.NotNil(t, err)
+}
+
+func TestParseConfigWithOverrides(t *testing.T) {
+	var data = `
+    name: test
+    environments:
+      staging:
+        nomad_url: http://example.com
+        overrides:
+          web:
+            start_instances: 1
+            variables:
+              log_level: debug
+            builds:
+              app:
+                deploy_tag: feature/staging
+                build_args:
+                  env: staging
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+        start_instances: 3
+        variables:
+          log_level: info
+          region: eu
+        builds:
+          app:
+            name: test
+            deploy_tag: latest
+            build_args:
+              env: production
+              debug: "false"
+    `
+
+	c, err := parseConfig([]byte(data))
+
+	assert.Nil(t, err)
+
+	staging, sources := c.ForEnvironment("staging")
+
+	assert.Equal(t, 1, staging.Deployments["web"].StartInstances)
+	assert.Equal(t, map[string]string{"log_level": "debug", "region": "eu"}, staging.Deployments["web"].Variables)
+	assert.Equal(t, "feature-staging", staging.Deployments["web"].Builds["app"].DeployTag)
+	assert.Equal(t, "test", staging.Deployments["web"].Builds["app"].Name)
+	assert.Equal(t, map[string]BuildArg{"env": {Value: "staging"}, "debug": {Value: "false"}}, staging.Deployments["web"].Builds["app"].BuildArgs)
+	assert.Equal(t, "environments.staging.overrides", sources.Source("deployments.web.builds.app.deploy_tag"))
+	assert.Equal(t, "environments.staging.overrides", sources.Source("deployments.web.variables.log_level"))
+	assert.Equal(t, BaseSource, sources.Source("deployments.web.variables.region"))
+	assert.Equal(t, BaseSource, sources.Source("deployments.web.builds.app.name"))
+
+	// The base config is left untouched.
+	assert.Equal(t, 3, c.Deployments["web"].StartInstances)
+	assert.Equal(t, "latest", c.Deployments["web"].Builds["app"].DeployTag)
+
+	production, sources := c.ForEnvironment("production")
+
+	assert.Equal(t, 3, production.Deployments["web"].StartInstances)
+	assert.Empty(t, sources)
+}
+
+func TestParseConfigWithOverrideOfUnknownDeployment(t *testing.T) {
+	var data = `
+    name: test
+    environments:
+      staging:
+        nomad_url: http://example.com
+        overrides:
+          missing:
+            start_instances: 1
+    deployments:
+      web:
+    `
+
+	_, err := parseConfig([]byte(data))
+
+	assert.NotNil(t, err)
+}
+
+func TestLoadFromFileWithIncludes(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "services", "api"), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "services", "web"), 0755))
+
+	filet.File(t, filepath.Join(dir, "tent.yaml"), `
+    name: test
+    include:
+      - services/*/tent.yaml
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      worker:
+        nomad_file: worker.nomad
+    `)
+
+	filet.File(t, filepath.Join(dir, "services", "api", "tent.yaml"), `
+    deployments:
+      api:
+        nomad_file: api.nomad
+        builds:
+          app:
+            name: api
+            context: .
+            deploy_tag: latest
+    `)
+
+	filet.File(t, filepath.Join(dir, "services", "web", "tent.yaml"), `
+    deployments:
+      web:
+        builds:
+          app:
+            script: build.sh
+    `)
+
+	c, err := LoadFromFile(filepath.Join(dir, "tent.yaml"))
+
+	assert.Nil(t, err)
+	assert.Len(t, c.Deployments, 3)
+	assert.Equal(t, filepath.Join(dir, "worker.nomad"), c.Deployments["worker"].NomadFile)
+	assert.Equal(t, filepath.Join(dir, "services", "api", "api.nomad"), c.Deployments["api"].NomadFile)
+	assert.Equal(t, filepath.Join(dir, "services", "api"), c.Deployments["api"].Builds["app"].Context)
+	assert.Equal(t, filepath.Join(dir, "services", "web", "build.sh"), c.Deployments["web"].Builds["app"].Script)
+	assert.Equal(t, filepath.Join(dir, "tent.yaml"), c.File)
+	assert.Equal(t, filepath.Join(dir, "tent.yaml"), c.Deployments["worker"].File)
+	assert.Equal(t, filepath.Join(dir, "services", "api", "tent.yaml"), c.Deployments["api"].File)
+}
+
+func TestLoadFromFileWithDuplicateIncludedDeployment(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+
+	filet.File(t, filepath.Join(dir, "tent.yaml"), `
+    name: test
+    include:
+      - other.yaml
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+    `)
+
+	filet.File(t, filepath.Join(dir, "other.yaml"), `
+    deployments:
+      web:
+    `)
+
+	_, err := LoadFromFile(filepath.Join(dir, "tent.yaml"))
+
+	assert.NotNil(t, err)
+}
+
+func TestLoadFromFileWithMissingInclude(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+
+	filet.File(t, filepath.Join(dir, "tent.yaml"), `
+    name: test
+    include:
+      - missing.yaml
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+    `)
+
+	_, err := LoadFromFile(filepath.Join(dir, "tent.yaml"))
+
+	assert.NotNil(t, err)
+}
+
+func TestParseConfigReportsAllErrors(t *testing.T) {
+	var data = `name: test
+environments:
+  production:
+    nomad_url: not-a-url
+deployments:
+  api:
+    start_instances: 20
+    builds:
+      web:
+        name: ab
+        deploy_tags: latest
+`
+
+	_, err := parseConfig([]byte(data))
+
+	errs, ok := err.(ValidationErrors)
+
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"4:16: environments.production.nomad_url must be a url, got 'not-a-url'",
+		"7:22: deployments.api.start_instances must be at most 10, got 20",
+		"9:7: deployments.api.builds.web.deploy_tag is required when no script is given",
+		"10:15: deployments.api.builds.web.name must be at least 3 characters long, got 'ab'",
+		"11:1: unknown key 'deploy_tags' in build",
+	}, errorStrings(errs))
+}
+
+func TestLoadFromFileReportsFilePositions(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	dir := filet.TmpDir(t, "")
+	file := filepath.Join(dir, "tent.yaml")
+
+	filet.File(t, file, `name: test
+environments:
+  production:
+    nomad_url: http://example.com
+deployments:
+  web:
+    service_name: ${SERVICE
+`)
+
+	_, err := LoadFromFile(file)
+
+	errs, ok := err.(ValidationErrors)
+
+	assert.True(t, ok)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, file, errs[0].File)
+	assert.Equal(t, 7, errs[0].Line)
+	assert.Equal(t, "deployments.web.service_name", errs[0].Path)
+}
+
+func errorStrings(errs ValidationErrors) []string {
+	strs := []string{}
+
+	for _, err := range errs {
+		strs = append(strs, err.Error())
+	}
+
+	return strs
+}
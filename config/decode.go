@@ -0,0 +1,101 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeConfigFile decodes a yaml file into out, rejecting unknown keys.
+//
+// It returns false if the file could not be parsed at all.
+func decodeConfigFile(data []byte, file string, out interface{}, errs *errorCollector) bool {
+	root := yaml.Node{}
+
+	err := yaml.Unmarshal(data, &root)
+
+	if err != nil {
+		errs.addDecodeErrors(file, err)
+		return false
+	}
+
+	errs.addFile(file, &root)
+
+	// The decoder is only used to find unknown keys and values of the wrong type, as it can not
+	// decode the node tree with the empty entries filled in below.
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(reflect.New(reflect.TypeOf(out).Elem()).Interface())
+
+	if err != nil && err != io.EOF {
+		if !errs.addDecodeErrors(file, err) {
+			return false
+		}
+	}
+
+	if len(root.Content) == 0 {
+		return true
+	}
+
+	fillEmptyEntries(&root, reflect.TypeOf(out))
+
+	// Type errors have already been recorded, anything that did decode is still used.
+	root.Decode(out)
+
+	return true
+}
+
+// fillEmptyEntries replaces empty map entries, such as `web:` within deployments, with empty mappings.
+//
+// Otherwise the entries would be left out of the map rather than using the defaults.
+func fillEmptyEntries(node *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			fillEmptyEntries(child, t)
+		}
+
+		return
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if field, ok := fieldByYAMLName(t, node.Content[i].Value); ok {
+				fillEmptyEntries(node.Content[i+1], field.Type)
+			}
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value := node.Content[i+1]
+
+			if t.Elem().Kind() == reflect.Struct && value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+				node.Content[i+1] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: value.Line, Column: value.Column}
+				continue
+			}
+
+			fillEmptyEntries(value, t.Elem())
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for _, child := range node.Content {
+			fillEmptyEntries(child, t.Elem())
+		}
+	}
+}
+
+func fieldByYAMLName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0] == name {
+			return t.Field(i), true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
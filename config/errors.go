@@ -0,0 +1,330 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	validator "gopkg.in/go-playground/validator.v9"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError is a single problem found within the config.
+type ValidationError struct {
+	File    string
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	location := e.File
+
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s:%d:%d", location, e.Line, e.Column)
+	}
+
+	location = strings.TrimPrefix(location, ":")
+
+	if len(e.Path) > 0 {
+		if len(location) > 0 {
+			return fmt.Sprintf("%s: %s %s", location, e.Path, e.Message)
+		}
+
+		return fmt.Sprintf("%s %s", e.Path, e.Message)
+	}
+
+	if len(location) > 0 {
+		return fmt.Sprintf("%s: %s", location, e.Message)
+	}
+
+	return e.Message
+}
+
+// ValidationErrors holds every problem found within the config.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := []string{}
+
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// configFile is a parsed config file, used to find the position of a value from its path.
+type configFile struct {
+	name string
+	root *yaml.Node
+}
+
+// errorCollector gathers validation errors, placing each one within the file that declared it.
+type errorCollector struct {
+	files  []configFile
+	errors ValidationErrors
+}
+
+func (c *errorCollector) addFile(name string, root *yaml.Node) {
+	c.files = append(c.files, configFile{name: name, root: root})
+}
+
+// add records an error for the value at the given path, eg, deployments.api.builds.web.name.
+func (c *errorCollector) add(path string, format string, args ...interface{}) {
+	err := ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+
+	best := -1
+
+	for _, file := range c.files {
+		node, depth := findNode(file.root, strings.Split(path, "."))
+
+		if node != nil && depth > best {
+			best = depth
+			err.File = file.name
+			err.Line = node.Line
+			err.Column = node.Column
+		}
+	}
+
+	c.errors = append(c.errors, err)
+}
+
+// addAt records an error at a known position.
+func (c *errorCollector) addAt(file string, line int, column int, path string, message string) {
+	c.errors = append(c.errors, ValidationError{File: file, Line: line, Column: column, Path: path, Message: message})
+}
+
+func (c *errorCollector) err() error {
+	if len(c.errors) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(c.errors, func(i, j int) bool {
+		if c.errors[i].File != c.errors[j].File {
+			return c.errors[i].File < c.errors[j].File
+		}
+
+		if c.errors[i].Line != c.errors[j].Line {
+			return c.errors[i].Line < c.errors[j].Line
+		}
+
+		return c.errors[i].Column < c.errors[j].Column
+	})
+
+	return c.errors
+}
+
+// findNode finds the deepest node along the path, returning it and how many parts of the path matched.
+//
+// Scalar values are pointed at directly, anything else is pointed at by its key.
+func findNode(node *yaml.Node, path []string) (*yaml.Node, int) {
+	if node == nil {
+		return nil, -1
+	}
+
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	position := node
+	depth := 0
+
+	for _, part := range path {
+		var key, next *yaml.Node
+
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == part {
+					key = node.Content[i]
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			var index int
+
+			if _, err := fmt.Sscanf(part, "%d", &index); err == nil && index < len(node.Content) {
+				key = node.Content[index]
+				next = node.Content[index]
+			}
+		}
+
+		if next == nil {
+			break
+		}
+
+		node = next
+		position = key
+		depth++
+
+		if next.Kind == yaml.ScalarNode && next.Tag != "!!null" {
+			position = next
+		}
+	}
+
+	return position, depth
+}
+
+var yamlLineRegex = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// addDecodeErrors records the errors from decoding a file, such as unknown keys or values of the wrong type.
+func (c *errorCollector) addDecodeErrors(file string, err error) bool {
+	typeErr, ok := err.(*yaml.TypeError)
+
+	if !ok {
+		c.addAt(file, 0, 0, "", strings.TrimPrefix(err.Error(), "yaml: "))
+		return false
+	}
+
+	for _, message := range typeErr.Errors {
+		matches := yamlLineRegex.FindStringSubmatch(message)
+
+		if matches == nil {
+			c.addAt(file, 0, 0, "", message)
+			continue
+		}
+
+		var line int
+		fmt.Sscanf(matches[1], "%d", &line)
+
+		c.addAt(file, line, 1, "", humanizeDecodeError(matches[2]))
+	}
+
+	return true
+}
+
+var unknownFieldRegex = regexp.MustCompile(`^field (\S+) not found in type config\.(\S+)$`)
+
+func humanizeDecodeError(message string) string {
+	if matches := unknownFieldRegex.FindStringSubmatch(message); matches != nil {
+		return fmt.Sprintf("unknown key '%s' in %s", matches[1], strings.ToLower(matches[2]))
+	}
+
+	return message
+}
+
+// addValidatorErrors records the errors from the struct validator against the yaml path of each field.
+func (c *errorCollector) addValidatorErrors(root interface{}, err error) {
+	errs, ok := err.(validator.ValidationErrors)
+
+	if !ok {
+		return
+	}
+
+	for _, err := range errs {
+		c.add(yamlPath(reflect.TypeOf(root), err.StructNamespace()), "%s", validationMessage(err))
+	}
+}
+
+var namespacePartRegex = regexp.MustCompile(`^([A-Za-z0-9_]+)(?:\[(.*)\])?$`)
+
+// yamlPath converts a validator namespace, eg, Config.Deployments[api].Builds[web].Name, to the yaml path
+// deployments.api.builds.web.name.
+func yamlPath(t reflect.Type, namespace string) string {
+	parts := splitNamespace(namespace)
+	path := []string{}
+
+	// The first part is the name of the root struct.
+	for _, part := range parts[1:] {
+		matches := namespacePartRegex.FindStringSubmatch(part)
+
+		if matches == nil {
+			path = append(path, part)
+			continue
+		}
+
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		name := matches[1]
+
+		if t.Kind() == reflect.Struct {
+			if field, ok := t.FieldByName(matches[1]); ok {
+				if tag := strings.Split(field.Tag.Get("yaml"), ",")[0]; len(tag) > 0 {
+					name = tag
+				}
+
+				t = field.Type
+			}
+		}
+
+		path = append(path, name)
+
+		if len(matches[2]) > 0 {
+			path = append(path, matches[2])
+
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+
+			if t.Kind() == reflect.Map || t.Kind() == reflect.Slice {
+				t = t.Elem()
+			}
+		}
+	}
+
+	return strings.Join(path, ".")
+}
+
+// splitNamespace splits a validator namespace on dots, ignoring dots within map keys.
+func splitNamespace(namespace string) []string {
+	parts := []string{}
+	depth := 0
+	start := 0
+
+	for i, r := range namespace {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.':
+			if depth == 0 {
+				parts = append(parts, namespace[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, namespace[start:])
+}
+
+func validationMessage(err validator.FieldError) string {
+	kind := err.Kind()
+
+	switch err.Tag() {
+	case "required":
+		return "is required"
+	case "min", "max":
+		limit := "at least"
+
+		if err.Tag() == "max" {
+			limit = "at most"
+		}
+
+		switch kind {
+		case reflect.String:
+			return fmt.Sprintf("must be %s %s characters long, got '%v'", limit, err.Param(), err.Value())
+		case reflect.Map, reflect.Slice, reflect.Array:
+			return fmt.Sprintf("must have %s %s entries", limit, err.Param())
+		}
+
+		return fmt.Sprintf("must be %s %s, got %v", limit, err.Param(), err.Value())
+	case "url":
+		return fmt.Sprintf("must be a url, got '%v'", err.Value())
+	case "oneof":
+		return fmt.Sprintf("must be one of %s, got '%v'", strings.Join(strings.Fields(err.Param()), ", "), err.Value())
+	case "alphanum":
+		return fmt.Sprintf("must only contain letters and numbers, got '%v'", err.Value())
+	case "file":
+		return fmt.Sprintf("must be an existing file, got '%v'", err.Value())
+	}
+
+	return fmt.Sprintf("failed the '%s' check, got '%v'", err.Tag(), err.Value())
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// includedConfig is the part of the config that may be declared in an included file.
+type includedConfig struct {
+	Deployments map[string]Deployment `yaml:"deployments"`
+}
+
+// loadIncludes merges the deployments of every file matched by the include globs into the config.
+//
+// Globs and the relative paths within each included file are resolved against the directory of that file.
+func loadIncludes(config *Config, dir string, errs *errorCollector) {
+	for i, pattern := range config.Include {
+		path := fmt.Sprintf("include.%d", i)
+
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+
+		if err != nil {
+			errs.add(path, "is not a valid glob: %s", err)
+			continue
+		}
+
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			errs.add(path, "must be an existing file, got '%s'", pattern)
+			continue
+		}
+
+		sort.Strings(matches)
+
+		for _, file := range matches {
+			includeConfigFile(config, file, path, errs)
+		}
+	}
+}
+
+func includeConfigFile(config *Config, file string, path string, errs *errorCollector) {
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		errs.add(path, "could not be read: %s", err)
+		return
+	}
+
+	included := includedConfig{}
+
+	if !decodeConfigFile(data, file, &included, errs) {
+		return
+	}
+
+	normalizeDeployments(included.Deployments, resolvePath(".", file), errs)
+
+	if config.Deployments == nil {
+		config.Deployments = map[string]Deployment{}
+	}
+
+	for name, deployment := range included.Deployments {
+		if _, ok := config.Deployments[name]; ok {
+			errs.add("deployments."+name, "is declared more than once, again in '%s'", file)
+			continue
+		}
+
+		config.Deployments[name] = deployment
+	}
+}
@@ -0,0 +1,361 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// BaseSource is the source reported for values that are not overridden.
+const BaseSource = "config"
+
+// DeploymentOverride configuration, merged into a deployment for a single environment.
+//
+// Unset fields keep the value of the deployment. Maps are merged key by key.
+type DeploymentOverride struct {
+	Builds         map[string]BuildOverride `yaml:"builds" validate:"dive"`
+	NomadFile      *string                  `yaml:"nomad_file"`
+	StartInstances *int                     `yaml:"start_instances" validate:"omitempty,min=1,max=10"`
+	Variables      map[string]string        `yaml:"variables"`
+	ServiceName    *string                  `yaml:"service_name" validate:"omitempty,min=3"`
+	TemplateEngine *string                  `yaml:"template_engine" validate:"omitempty,oneof=tent go"`
+}
+
+// BuildOverride configuration, merged into a build for a single environment.
+type BuildOverride struct {
+	Context     *string                `yaml:"context"`
+	RegistryURL *string                `yaml:"registry_url"`
+	Name        *string                `yaml:"name" validate:"omitempty,min=3"`
+	Tags        []string               `yaml:"tags"`
+	Push        *bool                  `yaml:"push"`
+	Target      *string                `yaml:"target" validate:"omitempty,alphanum"`
+	File        *string                `yaml:"file" validate:"omitempty,file"`
+	DeployTag   *string                `yaml:"deploy_tag"`
+	Script      *string                `yaml:"script"`
+	Shell       *string                `yaml:"shell"`
+	Args        []string               `yaml:"args"`
+	Workdir     *string                `yaml:"workdir"`
+	Env         map[string]string      `yaml:"env"`
+	BuildArgs   map[string]BuildArg    `yaml:"build_args"`
+	Labels      map[string]string      `yaml:"labels"`
+	Secrets     map[string]BuildSecret `yaml:"secrets"`
+	SSH         *string                `yaml:"ssh"`
+	Builder     *string                `yaml:"builder" validate:"omitempty,oneof=docker podman buildah kaniko"`
+}
+
+// Sources records where each overridden value of a merged config came from, keyed by the
+// yaml path of the value, eg, deployments.app.builds.web.deploy_tag.
+type Sources map[string]string
+
+// Source returns the source of the value at the given path.
+func (s Sources) Source(path string) string {
+	if source, ok := s[path]; ok {
+		return source
+	}
+
+	return BaseSource
+}
+
+// ForEnvironment returns the config with the overrides of the given environment merged into the deployments.
+//
+// The receiver is left untouched.
+func (c Config) ForEnvironment(environment string) (Config, Sources) {
+	sources := Sources{}
+
+	merged := c
+	merged.Deployments = map[string]Deployment{}
+
+	overrides := c.Environments[environment].Overrides
+	source := fmt.Sprintf("environments.%s.overrides", environment)
+
+	for name, deployment := range c.Deployments {
+		override, ok := overrides[name]
+
+		if !ok {
+			merged.Deployments[name] = deployment
+			continue
+		}
+
+		merged.Deployments[name] = mergeDeployment(deployment, override, "deployments."+name, source, sources)
+	}
+
+	return merged, sources
+}
+
+func mergeDeployment(deployment Deployment, override DeploymentOverride, path string, source string, sources Sources) Deployment {
+	if override.NomadFile != nil {
+		deployment.NomadFile = *override.NomadFile
+		sources[path+".nomad_file"] = source
+	}
+
+	if override.StartInstances != nil {
+		deployment.StartInstances = *override.StartInstances
+		sources[path+".start_instances"] = source
+	}
+
+	if override.ServiceName != nil {
+		deployment.ServiceName = *override.ServiceName
+		sources[path+".service_name"] = source
+	}
+
+	if override.TemplateEngine != nil {
+		deployment.TemplateEngine = *override.TemplateEngine
+		sources[path+".template_engine"] = source
+	}
+
+	deployment.Variables = mergeMap(deployment.Variables, override.Variables, path+".variables", source, sources)
+
+	builds := map[string]Build{}
+
+	for name, build := range deployment.Builds {
+		if buildOverride, ok := override.Builds[name]; ok {
+			build = mergeBuild(build, buildOverride, path+".builds."+name, source, sources)
+		}
+
+		builds[name] = build
+	}
+
+	deployment.Builds = builds
+
+	return deployment
+}
+
+func mergeBuild(build Build, override BuildOverride, path string, source string, sources Sources) Build {
+	fields := []struct {
+		name     string
+		value    *string
+		original *string
+	}{
+		{"context", override.Context, &build.Context},
+		{"registry_url", override.RegistryURL, &build.RegistryURL},
+		{"name", override.Name, &build.Name},
+		{"target", override.Target, &build.Target},
+		{"file", override.File, &build.File},
+		{"deploy_tag", override.DeployTag, &build.DeployTag},
+		{"script", override.Script, &build.Script},
+		{"shell", override.Shell, &build.Shell},
+		{"workdir", override.Workdir, &build.Workdir},
+		{"ssh", override.SSH, &build.SSH},
+		{"builder", override.Builder, &build.Builder},
+	}
+
+	for _, field := range fields {
+		if field.value != nil {
+			*field.original = *field.value
+			sources[path+"."+field.name] = source
+		}
+	}
+
+	if override.Tags != nil {
+		build.Tags = override.Tags
+		sources[path+".tags"] = source
+	}
+
+	if override.Args != nil {
+		build.Args = override.Args
+		sources[path+".args"] = source
+	}
+
+	if override.Push != nil {
+		build.Push = *override.Push
+		sources[path+".push"] = source
+	}
+
+	build.Env = mergeMap(build.Env, override.Env, path+".env", source, sources)
+	build.Labels = mergeMap(build.Labels, override.Labels, path+".labels", source, sources)
+
+	if build.Secrets != nil || override.Secrets != nil {
+		secrets := map[string]BuildSecret{}
+
+		for id, secret := range build.Secrets {
+			secrets[id] = secret
+		}
+
+		for id, secret := range override.Secrets {
+			secrets[id] = secret
+			sources[path+".secrets."+id] = source
+		}
+
+		build.Secrets = secrets
+	}
+
+	if build.BuildArgs != nil || override.BuildArgs != nil {
+		args := map[string]BuildArg{}
+
+		for key, arg := range build.BuildArgs {
+			args[key] = arg
+		}
+
+		for key, arg := range override.BuildArgs {
+			args[key] = arg
+			sources[path+".build_args."+key] = source
+		}
+
+		build.BuildArgs = args
+	}
+
+	return build
+}
+
+func mergeMap(base map[string]string, override map[string]string, path string, source string, sources Sources) map[string]string {
+	if base == nil && override == nil {
+		return nil
+	}
+
+	merged := map[string]string{}
+
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range override {
+		merged[key] = value
+		sources[path+"."+key] = source
+	}
+
+	return merged
+}
+
+func normalizeDeploymentOverride(override DeploymentOverride, dir string, path string, errs *errorCollector) DeploymentOverride {
+	if override.NomadFile != nil {
+		nomadFile := normalizeNomadFile(*override.NomadFile, dir, path+".nomad_file", errs)
+		override.NomadFile = &nomadFile
+	}
+
+	if override.ServiceName != nil {
+		serviceName := errs.envsubst(path+".service_name", *override.ServiceName)
+		override.ServiceName = &serviceName
+	}
+
+	if override.Variables != nil {
+		override.Variables = normalizeVariables(override.Variables, path+".variables", errs)
+	}
+
+	builds := map[string]BuildOverride{}
+
+	for name, build := range override.Builds {
+		builds[name] = normalizeBuildOverride(build, dir, path+".builds."+name, errs)
+	}
+
+	override.Builds = builds
+
+	return override
+}
+
+// normalizeBuildOverride applies the same interpolation to the set fields as normalizeBuild does for a build.
+func normalizeBuildOverride(override BuildOverride, dir string, path string, errs *errorCollector) BuildOverride {
+	build := Build{Tags: override.Tags, Args: override.Args, Env: override.Env, Labels: override.Labels, Secrets: override.Secrets, BuildArgs: override.BuildArgs}
+
+	for _, field := range []struct {
+		value *string
+		into  *string
+	}{
+		{override.RegistryURL, &build.RegistryURL},
+		{override.Name, &build.Name},
+		{override.Target, &build.Target},
+		{override.DeployTag, &build.DeployTag},
+		{override.Script, &build.Script},
+		{override.Shell, &build.Shell},
+		{override.Workdir, &build.Workdir},
+		{override.Context, &build.Context},
+		{override.File, &build.File},
+		{override.SSH, &build.SSH},
+	} {
+		if field.value != nil {
+			*field.into = *field.value
+		}
+	}
+
+	build = normalizeBuild(build, dir, path, errs)
+
+	if override.RegistryURL != nil {
+		override.RegistryURL = &build.RegistryURL
+	}
+
+	if override.Name != nil {
+		override.Name = &build.Name
+	}
+
+	if override.Target != nil {
+		override.Target = &build.Target
+	}
+
+	if override.DeployTag != nil {
+		override.DeployTag = &build.DeployTag
+	}
+
+	if override.Script != nil {
+		override.Script = &build.Script
+	}
+
+	if override.Shell != nil {
+		override.Shell = &build.Shell
+	}
+
+	if override.Workdir != nil {
+		override.Workdir = &build.Workdir
+	}
+
+	if override.Context != nil {
+		override.Context = &build.Context
+	}
+
+	if override.File != nil {
+		override.File = &build.File
+	}
+
+	if len(override.Tags) > 0 {
+		override.Tags = build.Tags
+	}
+
+	if override.BuildArgs != nil {
+		override.BuildArgs = build.BuildArgs
+	}
+
+	if override.Args != nil {
+		override.Args = build.Args
+	}
+
+	if override.Env != nil {
+		override.Env = build.Env
+	}
+
+	if override.Labels != nil {
+		override.Labels = build.Labels
+	}
+
+	if override.Secrets != nil {
+		override.Secrets = build.Secrets
+	}
+
+	if override.SSH != nil {
+		override.SSH = &build.SSH
+	}
+
+	return override
+}
+
+func validateOverrides(environment string, env Environment, deployments map[string]Deployment, errs *errorCollector) {
+	names := []string{}
+
+	for name := range env.Overrides {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := fmt.Sprintf("environments.%s.overrides.%s", environment, name)
+		deployment, ok := deployments[name]
+
+		if !ok {
+			errs.add(path, "overrides a deployment that is not configured")
+			continue
+		}
+
+		for build := range env.Overrides[name].Builds {
+			if _, ok := deployment.Builds[build]; !ok {
+				errs.add(path+".builds."+build, "overrides a build that is not configured for the deployment")
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Schema generates a JSON Schema for the config file from the config structs.
+//
+// Properties come from the yaml tags, and the validate tags are used for required
+// properties, lengths, ranges and allowed values.
+func Schema() ([]byte, error) {
+	schema := typeSchema(reflect.TypeOf(Config{}), "")
+
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "tent.yaml"
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func typeSchema(t reflect.Type, validate string) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Rules after dive apply to the elements of a map or slice rather than the map or slice itself.
+	rules := strings.Split(validate, ",")
+	elementRules := ""
+
+	for i, rule := range rules {
+		if rule == "dive" {
+			elementRules = strings.Join(rules[i+1:], ",")
+			rules = rules[:i]
+			break
+		}
+	}
+
+	// Build args may be given as a plain value rather than a mapping.
+	if t == reflect.TypeOf(BuildArg{}) {
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": []string{"string", "number", "boolean", "null"}},
+				structSchema(t),
+			},
+		}
+	}
+
+	schema := map[string]interface{}{}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		schema = structSchema(t)
+	case reflect.Map:
+		elem := typeSchema(t.Elem(), elementRules)
+
+		// Entries such as `web:` within deployments may be left empty to use the defaults.
+		if elem["type"] == "object" {
+			elem["type"] = []string{"object", "null"}
+		}
+
+		schema["type"] = "object"
+		schema["additionalProperties"] = elem
+	case reflect.Slice, reflect.Array:
+		schema["type"] = "array"
+		schema["items"] = typeSchema(t.Elem(), elementRules)
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema["type"] = "integer"
+	}
+
+	for _, rule := range rules {
+		applyRule(schema, t.Kind(), rule)
+	}
+
+	return schema
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+
+		fieldValidate := field.Tag.Get("validate")
+		properties[name] = typeSchema(field.Type, fieldValidate)
+
+		if hasRule(strings.Split(fieldValidate, ","), "required") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func applyRule(schema map[string]interface{}, kind reflect.Kind, rule string) {
+	parts := strings.SplitN(rule, "=", 2)
+	name := parts[0]
+	param := ""
+
+	if len(parts) == 2 {
+		param = parts[1]
+	}
+
+	limit, _ := strconv.Atoi(param)
+
+	switch name {
+	case "min", "max":
+		keys := map[reflect.Kind][2]string{
+			reflect.String: {"minLength", "maxLength"},
+			reflect.Map:    {"minProperties", "maxProperties"},
+			reflect.Slice:  {"minItems", "maxItems"},
+			reflect.Int:    {"minimum", "maximum"},
+		}
+
+		if key, ok := keys[kind]; ok {
+			if name == "min" {
+				schema[key[0]] = limit
+			} else {
+				schema[key[1]] = limit
+			}
+		}
+	case "oneof":
+		schema["enum"] = strings.Fields(param)
+	case "url":
+		schema["format"] = "uri"
+	case "alphanum":
+		schema["pattern"] = "^[a-zA-Z0-9]*$"
+	}
+}
+
+func hasRule(rules []string, name string) bool {
+	for _, rule := range rules {
+		if rule == "dive" {
+			return false
+		}
+
+		if rule == name {
+			return true
+		}
+	}
+
+	return false
+}
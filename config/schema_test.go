@@ -0,0 +1,48 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The committed schema must match the config structs. To update it, run: tent config schema > tent.schema.json
+func TestSchemaIsInSync(t *testing.T) {
+	expected, err := ioutil.ReadFile("../tent.schema.json")
+
+	assert.Nil(t, err)
+
+	schema, err := Schema()
+
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(schema)+"\n", "tent.schema.json is out of date, run: tent config schema > tent.schema.json")
+}
+
+func TestSchema(t *testing.T) {
+	data, err := Schema()
+
+	assert.Nil(t, err)
+
+	schema := map[string]interface{}{}
+
+	assert.Nil(t, json.Unmarshal(data, &schema))
+
+	properties := schema["properties"].(map[string]interface{})
+	name := properties["name"].(map[string]interface{})
+	deployments := properties["deployments"].(map[string]interface{})
+	deployment := deployments["additionalProperties"].(map[string]interface{})
+	deploymentProperties := deployment["properties"].(map[string]interface{})
+	engine := deploymentProperties["template_engine"].(map[string]interface{})
+	startInstances := deploymentProperties["start_instances"].(map[string]interface{})
+
+	assert.Equal(t, []interface{}{"name", "environments", "deployments"}, schema["required"])
+	assert.Equal(t, false, schema["additionalProperties"])
+	assert.Equal(t, "string", name["type"])
+	assert.Equal(t, float64(3), name["minLength"])
+	assert.Equal(t, []interface{}{"object", "null"}, deployment["type"])
+	assert.Equal(t, []interface{}{"tent", "go"}, engine["enum"])
+	assert.Equal(t, "integer", startInstances["type"])
+	assert.Equal(t, float64(10), startInstances["maximum"])
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pm-connect/tent/git"
+)
+
+// maxTagLength is the longest tag docker accepts.
+const maxTagLength = 128
+
+// templateNames are the templates that may be used within tags.
+var templateNames = []string{"git.sha", "git.short_sha", "git.branch", "git.tag", "git.dirty", "timestamp"}
+
+var templateRegex = regexp.MustCompile(`\{([a-z_.]+)\}`)
+var invalidTagCharsRegex = regexp.MustCompile(`[^a-z0-9_.-]`)
+
+// templateTime is the time used by {timestamp}, so that every tag of a run gets the same value.
+var templateTime = time.Now()
+
+var templateCache = map[string]templateResult{}
+var templateLock sync.Mutex
+
+type templateResult struct {
+	values map[string]string
+	err    error
+}
+
+// templateValues returns the values of the {git.sha} style templates for the repository containing dir, each
+// sanitised to be valid within a docker tag, along with git.remote, which is not valid within a tag but is
+// available to nomad files and image labels.
+//
+// {timestamp} is always available, while the git templates are missing if the repository can not be read.
+func templateValues(dir string) (map[string]string, error) {
+	templateLock.Lock()
+	defer templateLock.Unlock()
+
+	if result, ok := templateCache[dir]; ok {
+		return result.values, result.err
+	}
+
+	values := map[string]string{
+		"timestamp": templateTime.UTC().Format("20060102150405"),
+	}
+
+	info, err := git.Read(dir)
+
+	if err == nil {
+		values["git.sha"] = info.SHA
+		values["git.short_sha"] = info.ShortSHA
+		values["git.branch"] = sanitizeTag(info.Branch)
+		values["git.tag"] = sanitizeTag(info.Tag)
+		values["git.dirty"] = ""
+		values["git.remote"] = info.Remote
+
+		if info.Dirty {
+			values["git.dirty"] = "-dirty"
+		}
+	}
+
+	templateCache[dir] = templateResult{values: values, err: err}
+
+	return values, err
+}
+
+// resolveTemplates replaces the {git.sha} style templates within a tag, keeping the whole tag valid for docker.
+//
+// A template without a value, such as {git.tag} on a commit that is not tagged, is an error rather than being
+// left out of the tag. Only {git.dirty} is expected to be empty.
+func resolveTemplates(tag string, dir string, path string, errs *errorCollector) string {
+	if !templateRegex.MatchString(tag) {
+		return tag
+	}
+
+	templates := &Templates{dir: dir}
+	original := tag
+
+	tag = templateRegex.ReplaceAllStringFunc(tag, func(match string) string {
+		key := strings.Trim(match, "{}")
+
+		if !isTemplateName(key) {
+			errs.add(path, "unknown template %s, expected one of {%s}", match, strings.Join(templateNames, "}, {"))
+			return match
+		}
+
+		value, err := templates.Value(key)
+
+		if err != nil {
+			errs.add(path, "%s", err)
+			return match
+		}
+
+		if len(value) == 0 && key != "git.dirty" {
+			errs.add(path, "%s has no value, eg, {git.tag} when the commit is not tagged", match)
+			return match
+		}
+
+		return value
+	})
+
+	resolved := sanitizeTag(tag)
+
+	if len(resolved) == 0 {
+		errs.add(path, "%s resolves to an empty tag", original)
+		return original
+	}
+
+	return resolved
+}
+
+// sanitizeTag makes a value valid within a docker tag: lowercase, only letters, digits, _, . and -, not
+// starting with . or -, and at most 128 characters.
+func sanitizeTag(tag string) string {
+	tag = invalidTagCharsRegex.ReplaceAllString(strings.ToLower(tag), "-")
+	tag = strings.TrimLeft(tag, ".-")
+
+	if len(tag) > maxTagLength {
+		tag = tag[:maxTagLength]
+	}
+
+	return tag
+}
+
+// Templates are the values of the {git.sha} style templates, keyed without braces, for the repository of the
+// file declaring a deployment, along with git.remote. The repository is only read once a git value is needed.
+type Templates struct {
+	dir    string
+	values map[string]string
+}
+
+// NewTemplates returns templates with the given values, rather than those read from a repository.
+func NewTemplates(values map[string]string) *Templates {
+	return &Templates{values: values}
+}
+
+// IsTemplateValue reports whether name is one of the templates available from Templates, eg, git.sha.
+func IsTemplateValue(name string) bool {
+	return isTemplateName(name) || name == "git.remote"
+}
+
+// Value returns the value of a single template, reading the repository if it is a git template.
+func (t *Templates) Value(name string) (string, error) {
+	if !IsTemplateValue(name) {
+		return "", fmt.Errorf("unknown template {%s}", name)
+	}
+
+	if t == nil {
+		return "", nil
+	}
+
+	if t.values != nil {
+		return t.values[name], nil
+	}
+
+	if name == "timestamp" {
+		return templateTime.UTC().Format("20060102150405"), nil
+	}
+
+	values, err := templateValues(t.dir)
+
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve {%s}: %s", name, err)
+	}
+
+	return values[name], nil
+}
+
+// Values returns the value of every template, reading the repository. {timestamp} is returned even when the
+// repository can not be read.
+func (t *Templates) Values() (map[string]string, error) {
+	if t == nil {
+		return map[string]string{}, nil
+	}
+
+	if t.values != nil {
+		return t.values, nil
+	}
+
+	return templateValues(t.dir)
+}
+
+func isTemplateName(name string) bool {
+	for _, templateName := range templateNames {
+		if templateName == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFromFileWithTagTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tent-templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "Feature/Login#2"},
+		{"-c", "user.name=tent", "-c", "user.email=tent@example.com", "commit", "-q", "--allow-empty", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, string(out))
+	}
+
+	sha, _ := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+
+	var data = `
+    name: test
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+        builds:
+          app:
+            name: example
+            tags:
+              - "{git.branch}-{git.short_sha}{git.dirty}"
+              - "{git.sha}"
+            deploy_tag: "{git.sha}"
+    `
+
+	ioutil.WriteFile(filepath.Join(dir, "tent.yaml"), []byte(data), 0644)
+
+	c, err := LoadFromFile(filepath.Join(dir, "tent.yaml"))
+
+	assert.Nil(t, err)
+
+	expectedSHA := strings.TrimSpace(string(sha))
+	build := c.Deployments["web"].Builds["app"]
+
+	// The config file is untracked, so the working tree is dirty.
+	assert.Equal(t, []string{"feature-login-2-" + expectedSHA[:7] + "-dirty", expectedSHA}, build.Tags)
+	assert.Equal(t, expectedSHA, build.DeployTag)
+	templates, err := c.Deployments["web"].Templates.Values()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "feature-login-2", templates["git.branch"])
+	assert.Len(t, templates["timestamp"], 14)
+}
+
+func TestLoadFromFileSanitizesTheWholeTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tent-templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	gitCommit(t, dir)
+
+	sha, _ := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+
+	var data = `
+    name: test
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+        builds:
+          app:
+            name: example
+            tags:
+              - "-{git.short_sha}"
+            deploy_tag: "{git.short_sha}"
+    `
+
+	ioutil.WriteFile(filepath.Join(dir, "tent.yaml"), []byte(data), 0644)
+
+	c, err := LoadFromFile(filepath.Join(dir, "tent.yaml"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{strings.TrimSpace(string(sha))[:7]}, c.Deployments["web"].Builds["app"].Tags)
+}
+
+func TestLoadFromFileWithTagTemplatesWithoutValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tent-templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var data = `
+    name: test
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+        builds:
+          app:
+            name: example
+            tags:
+              - "{git.tag}"
+            deploy_tag: "{git.dirty}"
+    `
+
+	// The config file is committed, so the working tree is clean and {git.dirty} is empty.
+	ioutil.WriteFile(filepath.Join(dir, "tent.yaml"), []byte(data), 0644)
+	gitCommit(t, dir)
+
+	_, err = LoadFromFile(filepath.Join(dir, "tent.yaml"))
+
+	errs, ok := err.(ValidationErrors)
+	file := filepath.Join(dir, "tent.yaml")
+
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		file + ":12:17: deployments.web.builds.app.tags.0 {git.tag} has no value, eg, {git.tag} when the commit is not tagged",
+		file + ":13:25: deployments.web.builds.app.deploy_tag {git.dirty} resolves to an empty tag",
+	}, errorStrings(errs))
+}
+
+// gitCommit creates a repository within dir, committing everything in it.
+func gitCommit(t *testing.T, dir string) {
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=tent", "-c", "user.email=tent@example.com", "commit", "-q", "--allow-empty", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, string(out))
+	}
+}
+
+func TestParseConfigWithUnknownTagTemplate(t *testing.T) {
+	var data = `name: test
+environments:
+  production:
+    nomad_url: http://example.com/prod
+deployments:
+  web:
+    builds:
+      app:
+        name: example
+        deploy_tag: "{git.commit}"
+`
+
+	_, err := parseConfig([]byte(data))
+
+	errs, ok := err.(ValidationErrors)
+
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"10:21: deployments.web.builds.app.deploy_tag unknown template {git.commit}, expected one of {git.sha}, {git.short_sha}, {git.branch}, {git.tag}, {git.dirty}, {timestamp}",
+	}, errorStrings(errs))
+}
+
+func TestLoadFromFileOnlyReadsGitWhenNeeded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tent-templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var data = `
+    name: test
+    environments:
+      production:
+        nomad_url: http://example.com/prod
+    deployments:
+      web:
+        builds:
+          app:
+            name: example
+            deploy_tag: latest
+    `
+
+	ioutil.WriteFile(filepath.Join(dir, "tent.yaml"), []byte(data), 0644)
+
+	c, err := LoadFromFile(filepath.Join(dir, "tent.yaml"))
+
+	assert.Nil(t, err)
+
+	_, read := templateCache[dir]
+
+	assert.False(t, read)
+
+	timestamp, err := c.Deployments["web"].Templates.Value("timestamp")
+
+	assert.Nil(t, err)
+	assert.Len(t, timestamp, 14)
+
+	_, read = templateCache[dir]
+
+	assert.False(t, read)
+
+	_, err = c.Deployments["web"].Templates.Value("git.sha")
+
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "unable to resolve {git.sha}: "), err.Error())
+
+	_, read = templateCache[dir]
+
+	assert.True(t, read)
+}
+
+func TestSanitizeTag(t *testing.T) {
+	assert.Equal(t, "feature-login_2.0", sanitizeTag("Feature/Login_2.0"))
+	assert.Equal(t, "v1", sanitizeTag(".-v1"))
+	assert.Len(t, sanitizeTag(strings.Repeat("a", 200)), 128)
+}
@@ -0,0 +1,54 @@
+package docker
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// Buildah builds and pushes images using the buildah cli, which can run rootless without a daemon.
+type Buildah struct {
+	Runner
+
+	digests map[string]string
+	lock    sync.Mutex
+}
+
+// Capabilities of buildah.
+func (b *Buildah) Capabilities() Capabilities {
+	return Capabilities{Name: "buildah", Binary: "buildah", LocalImages: true, Secrets: true, SSH: true}
+}
+
+// BuildImage builds an image with buildah bud.
+//
+// Layers are kept so that, as with docker, later builds are cached and the cache image can be used.
+func (b *Buildah) BuildImage(name string, context string, tags []string, buildArgs map[string]string, labels map[string]string, secrets []Secret, ssh string, target string, cacheFrom string, file string, output bool) error {
+	args := dockerBuildArgs([]string{"bud", "--layers"}, context, tags, buildArgs, labels, secrets, ssh, target, cacheFrom, file)
+
+	return b.run(name, "buildah", args, output)
+}
+
+// PushImage pushes an image with buildah push, keeping the digest of the pushed image.
+func (b *Buildah) PushImage(name string, image string, output bool) (PushProgress, error) {
+	var progress PushProgress
+
+	digest, err := withDigestFile(func(path string) error {
+		var err error
+		progress, err = b.push(name, exec.Command("buildah", "push", "--digestfile="+path, image), output)
+		return err
+	})
+
+	if err != nil {
+		return progress, err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.digests == nil {
+		b.digests = map[string]string{}
+	}
+
+	b.digests[image] = digest
+
+	return progress, nil
+}
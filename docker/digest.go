@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Digester is implemented by builders that can report the digest of an image they pushed.
+type Digester interface {
+	ImageDigest(name string, image string) (string, error)
+}
+
+// ImageDigest returns the digest docker recorded when pushing the image.
+func (b *DefaultDocker) ImageDigest(name string, image string) (string, error) {
+	return inspectDigest("docker", image)
+}
+
+// ImageDigest returns the digest podman recorded when pushing the image.
+func (b *Podman) ImageDigest(name string, image string) (string, error) {
+	return inspectDigest("podman", image)
+}
+
+// ImageDigest returns the digest written by buildah push.
+func (b *Buildah) ImageDigest(name string, image string) (string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if digest, ok := b.digests[image]; ok {
+		return digest, nil
+	}
+
+	return "", fmt.Errorf("%s has not been pushed", image)
+}
+
+// ImageDigest returns the digest written by the executor, which is shared by every tag.
+func (b *Kaniko) ImageDigest(name string, image string) (string, error) {
+	if len(b.digest) == 0 {
+		return "", fmt.Errorf("%s has not been pushed", image)
+	}
+
+	return b.digest, nil
+}
+
+// inspectDigest finds the digest of the image within the repository it was pushed to.
+func inspectDigest(binary string, image string) (string, error) {
+	out, err := exec.Command(binary, "image", "inspect", "--format={{range .RepoDigests}}{{println .}}{{end}}", image).Output()
+
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect %s: %s", image, err)
+	}
+
+	return repoDigest(string(out), image)
+}
+
+// repoDigest finds the digest of the image within a list of repository digests, eg, registry/app@sha256:...
+func repoDigest(repoDigests string, image string) (string, error) {
+	repository := imageRepository(image)
+
+	for _, line := range strings.Split(repoDigests, "\n") {
+		if strings.HasPrefix(line, repository+"@") {
+			return strings.TrimPrefix(strings.TrimSpace(line), repository+"@"), nil
+		}
+	}
+
+	return "", fmt.Errorf("no digest of %s was found, has it been pushed?", image)
+}
+
+// imageRepository removes the tag from an image, keeping any registry port.
+func imageRepository(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+
+	return image
+}
+
+// withDigestFile calls fn with the path of a temporary file for a command to write a digest to, returning
+// the digest written.
+func withDigestFile(fn func(path string) error) (string, error) {
+	file, err := ioutil.TempFile("", "tent-digest")
+
+	if err != nil {
+		return "", err
+	}
+
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := fn(file.Name()); err != nil {
+		return "", err
+	}
+
+	digest, err := ioutil.ReadFile(file.Name())
+
+	return strings.TrimSpace(string(digest)), err
+}
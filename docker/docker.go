@@ -1,11 +1,85 @@
 package docker
 
+import (
+	"fmt"
+	"os/exec"
+)
+
 // Docker interface to run docker related commands.
+//
+// Despite the name, it is implemented by every image builder: docker, podman, buildah and kaniko.
 type Docker interface {
-	BuildImage(name string, context string, tags []string, buildArgs map[string]string, target string, cacheFrom string, file string, output bool) error
-	PushImage(name string, image string, output bool) error
+	BuildImage(name string, context string, tags []string, buildArgs map[string]string, labels map[string]string, secrets []Secret, ssh string, target string, cacheFrom string, file string, output bool) error
+	PushImage(name string, image string, output bool) (PushProgress, error)
+	Capabilities() Capabilities
+}
+
+// Capabilities describes how a builder differs from docker.
+type Capabilities struct {
+	// Name of the builder, used within errors.
+	Name string
+	// Binary that must be installed to use the builder.
+	Binary string
+	// LocalImages is false for builders, such as kaniko, that do not keep the images they build. Every tag is
+	// pushed while it is built, or without push the image is only built, as a check.
+	LocalImages bool
+	// Secrets is true for builders that can mount secrets into the build, without keeping them in the image.
+	Secrets bool
+	// SSH is true for builders that can forward an ssh agent or key into the build.
+	SSH bool
+}
+
+// Secret is made available to the build with RUN --mount=type=secret,id={ID}, read from either a file or an
+// environment variable.
+type Secret struct {
+	ID   string
+	File string
+	Env  string
+}
+
+// Check returns an error if the builder can not be used, or does not support the given options.
+func (c Capabilities) Check(secrets bool, ssh bool) error {
+	if len(c.Binary) > 0 {
+		if _, err := exec.LookPath(c.Binary); err != nil {
+			return fmt.Errorf("the %s builder needs %s, which was not found", c.Name, c.Binary)
+		}
+	}
+
+	if !c.Secrets && secrets {
+		return fmt.Errorf("the %s builder does not support build secrets", c.Name)
+	}
+
+	if !c.SSH && ssh {
+		return fmt.Errorf("the %s builder does not support ssh forwarding", c.Name)
+	}
+
+	return nil
+}
+
+// NewBuilder creates the named builder, one of docker, podman, buildah or kaniko, defaulting to docker.
+//
+// Builders that can only push while building, such as kaniko, push the image when push is true.
+func NewBuilder(builder string, push bool, runner Runner) (Docker, error) {
+	switch builder {
+	case "", "docker":
+		return &DefaultDocker{Runner: runner}, nil
+	case "podman":
+		return &Podman{Runner: runner}, nil
+	case "buildah":
+		return &Buildah{Runner: runner}, nil
+	case "kaniko":
+		return &Kaniko{Push: push, Runner: runner}, nil
+	}
+
+	return nil, fmt.Errorf("unknown builder %s", builder)
 }
 
 // DefaultDocker contains the default setup for docker commands.
 type DefaultDocker struct {
+	Runner
+}
+
+// Capabilities of docker.
+func (b *DefaultDocker) Capabilities() Capabilities {
+	return Capabilities{Name: "docker", Binary: "docker", LocalImages: true, Secrets: true, SSH: true}
 }
@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDockerBuildArgs(t *testing.T) {
+	args := dockerBuildArgs(
+		[]string{"build"},
+		"app",
+		[]string{"registry/app:latest", "registry/app:v1"},
+		map[string]string{"VERSION": "1.2.0", "ENV": "production"},
+		map[string]string{"tent.build": "app", "org.opencontainers.image.revision": "abc"},
+		[]Secret{{ID: "npm", File: "/home/app/.npmrc"}, {ID: "token", Env: "GITHUB_TOKEN"}},
+		"default",
+		"prod",
+		"registry/app:v1",
+		"app/Dockerfile.prod",
+	)
+
+	assert.Equal(t, []string{
+		"build",
+		"--target=prod",
+		"--tag=registry/app:latest",
+		"--tag=registry/app:v1",
+		"--build-arg=ENV=production",
+		"--build-arg=VERSION=1.2.0",
+		"--label=org.opencontainers.image.revision=abc",
+		"--label=tent.build=app",
+		"--secret=id=npm,src=/home/app/.npmrc",
+		"--secret=id=token,env=GITHUB_TOKEN",
+		"--ssh=default",
+		"--cache-from=registry/app:v1",
+		"--file=app/Dockerfile.prod",
+		"app",
+	}, args)
+}
+
+func TestKanikoBuildArgs(t *testing.T) {
+	args := kanikoBuildArgs(
+		"app",
+		[]string{"registry/app:latest", "registry/app:v1"},
+		map[string]string{"VERSION": "1.2.0"},
+		map[string]string{"tent.build": "app"},
+		"",
+		"registry/app:v1",
+		"",
+		true,
+	)
+
+	assert.Equal(t, []string{
+		"--context=app",
+		"--dockerfile=app/Dockerfile",
+		"--destination=registry/app:latest",
+		"--destination=registry/app:v1",
+		"--build-arg=VERSION=1.2.0",
+		"--label=tent.build=app",
+		"--cache=true",
+	}, args)
+
+	args = kanikoBuildArgs("", []string{"app:latest"}, nil, nil, "", "", "", false)
+
+	assert.Equal(t, []string{"--context=.", "--dockerfile=Dockerfile", "--destination=app:latest", "--no-push"}, args)
+}
+
+func TestNewBuilder(t *testing.T) {
+	for name, expected := range map[string]Docker{
+		"":        &DefaultDocker{},
+		"docker":  &DefaultDocker{},
+		"podman":  &Podman{},
+		"buildah": &Buildah{},
+		"kaniko":  &Kaniko{Push: true},
+	} {
+		builder, err := NewBuilder(name, true, Runner{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, expected, builder)
+	}
+
+	_, err := NewBuilder("bazel", true, Runner{})
+
+	assert.EqualError(t, err, "unknown builder bazel")
+}
+
+func TestCapabilitiesCheck(t *testing.T) {
+	assert.Nil(t, Capabilities{Name: "docker", LocalImages: true}.Check(false, false))
+	assert.Nil(t, Capabilities{Name: "docker", LocalImages: true, Secrets: true, SSH: true}.Check(true, true))
+	assert.Nil(t, Capabilities{Name: "kaniko"}.Check(false, false))
+	assert.EqualError(t, Capabilities{Name: "kaniko"}.Check(true, false), "the kaniko builder does not support build secrets")
+	assert.EqualError(t, Capabilities{Name: "kaniko"}.Check(false, true), "the kaniko builder does not support ssh forwarding")
+	assert.EqualError(t, Capabilities{Name: "kaniko", Binary: "/does/not/exist"}.Check(false, false), "the kaniko builder needs /does/not/exist, which was not found")
+}
+
+func TestRepoDigest(t *testing.T) {
+	digests := "registry.example.com:5000/app@sha256:abc\nother.example.com/app@sha256:def\n"
+
+	digest, err := repoDigest(digests, "registry.example.com:5000/app:v1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:abc", digest)
+
+	digest, err = repoDigest(digests, "other.example.com/app")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:def", digest)
+
+	_, err = repoDigest(digests, "app:v1")
+
+	assert.EqualError(t, err, "no digest of app:v1 was found, has it been pushed?")
+}
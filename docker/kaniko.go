@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// DefaultKanikoExecutor is where the executor is found within the kaniko image.
+const DefaultKanikoExecutor = "/kaniko/executor"
+
+// Kaniko builds images using the kaniko executor, which needs neither a daemon nor privileges.
+//
+// Kaniko does not keep the images it builds, so when pushing every tag is pushed by the build itself, otherwise
+// the image is only built, as a check.
+type Kaniko struct {
+	// Push every tag while building. Without it the image is only built, as a check.
+	Push bool
+	// Executor is the path of the kaniko executor, defaulting to DefaultKanikoExecutor.
+	Executor string
+	Runner
+
+	digest string
+}
+
+func (b *Kaniko) executor() string {
+	if len(b.Executor) == 0 {
+		return DefaultKanikoExecutor
+	}
+
+	return b.Executor
+}
+
+// Capabilities of kaniko.
+func (b *Kaniko) Capabilities() Capabilities {
+	return Capabilities{Name: "kaniko", Binary: b.executor(), LocalImages: false}
+}
+
+// BuildImage builds the image with the kaniko executor, pushing every tag when Push is set.
+//
+// A cache image is not used directly, instead kaniko caches layers within the repository of the image. Kaniko
+// can not mount secrets or forward ssh, so they are rejected by Capabilities.Check.
+func (b *Kaniko) BuildImage(name string, context string, tags []string, buildArgs map[string]string, labels map[string]string, secrets []Secret, ssh string, target string, cacheFrom string, file string, output bool) error {
+	args := kanikoBuildArgs(context, tags, buildArgs, labels, target, cacheFrom, file, b.Push)
+
+	if !b.Push {
+		return b.run(name, b.executor(), args, output)
+	}
+
+	digest, err := withDigestFile(func(path string) error {
+		return b.run(name, b.executor(), append(args, "--digest-file="+path), output)
+	})
+
+	b.digest = digest
+
+	return err
+}
+
+// PushImage does nothing, as every tag was pushed by BuildImage.
+func (b *Kaniko) PushImage(name string, image string, output bool) (PushProgress, error) {
+	if !b.Push {
+		return PushProgress{}, fmt.Errorf("kaniko can only push while building")
+	}
+
+	if output {
+		newPrefixWriter(b.output(), fmt.Sprintf("===> [%s]    ", name)).Write([]byte(image + " was pushed while building.\n"))
+	}
+
+	return PushProgress{}, nil
+}
+
+func kanikoBuildArgs(context string, tags []string, buildArgs map[string]string, labels map[string]string, target string, cacheFrom string, file string, push bool) []string {
+	if len(context) == 0 {
+		context = "."
+	}
+
+	if len(file) == 0 {
+		file = filepath.Join(context, "Dockerfile")
+	}
+
+	args := []string{
+		fmt.Sprintf("--context=%s", context),
+		fmt.Sprintf("--dockerfile=%s", file),
+	}
+
+	if len(target) > 0 {
+		args = append(args, fmt.Sprintf("--target=%s", target))
+	}
+
+	for _, tag := range tags {
+		args = append(args, fmt.Sprintf("--destination=%s", tag))
+	}
+
+	for _, arg := range sortedKeys(buildArgs) {
+		args = append(args, fmt.Sprintf("--build-arg=%s=%s", arg, buildArgs[arg]))
+	}
+
+	for _, label := range sortedKeys(labels) {
+		args = append(args, fmt.Sprintf("--label=%s=%s", label, labels[label]))
+	}
+
+	if len(cacheFrom) > 0 && push {
+		args = append(args, "--cache=true")
+	}
+
+	if !push {
+		args = append(args, "--no-push")
+	}
+
+	return args
+}
@@ -0,0 +1,25 @@
+package docker
+
+import "os/exec"
+
+// Podman builds and pushes images using the podman cli, which can run rootless without a daemon.
+type Podman struct {
+	Runner
+}
+
+// Capabilities of podman.
+func (b *Podman) Capabilities() Capabilities {
+	return Capabilities{Name: "podman", Binary: "podman", LocalImages: true, Secrets: true, SSH: true}
+}
+
+// BuildImage builds an image with podman build.
+func (b *Podman) BuildImage(name string, context string, tags []string, buildArgs map[string]string, labels map[string]string, secrets []Secret, ssh string, target string, cacheFrom string, file string, output bool) error {
+	args := dockerBuildArgs([]string{"build"}, context, tags, buildArgs, labels, secrets, ssh, target, cacheFrom, file)
+
+	return b.run(name, "podman", args, output)
+}
+
+// PushImage pushes an image with podman push.
+func (b *Podman) PushImage(name string, image string, output bool) (PushProgress, error) {
+	return b.push(name, exec.Command("podman", "push", image), output)
+}
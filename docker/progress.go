@@ -0,0 +1,230 @@
+package docker
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Docker reports each layer by its short id, eg, "5f70bf18a086: Pushed", while podman and buildah report the
+// blobs they copy, eg, "Copying blob sha256:5f70bf18a086... done".
+var dockerLayerRegex = regexp.MustCompile(`^([0-9a-f]{12}): (Pushed|Layer already exists|Mounted from|Pushing)`)
+var copyingBlobRegex = regexp.MustCompile(`^Copying blob (?:sha256:)?([0-9a-f]+)(.*)$`)
+var sizeRegex = regexp.MustCompile(`([0-9.]+)\s?([kKMGT]?i?B)\s?/\s?([0-9.]+)\s?([kKMGT]?i?B)`)
+
+// transientErrorRegex matches the errors of a registry or network that are likely to pass when retried. Status codes
+// are only matched as part of a status, eg, "status: 502" or "HTTP/1.1 503", so that sizes and ids are not.
+var transientErrorRegex = regexp.MustCompile(`(?i)((status\s*(code)?\s*:?|http/\d(\.\d)?)\s*(429|500|502|503|504)\b|too many requests|bad gateway|service unavailable|gateway time-?out|internal server error|connection reset|i/o timeout|tls handshake timeout|unexpected eof|temporary failure)`)
+
+var sizeUnits = map[string]float64{
+	"B":   1,
+	"kB":  1e3,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+// PushProgress summarises a push, from the output of the builder.
+type PushProgress struct {
+	// Layers is how many layers were uploaded.
+	Layers int
+	// ExistingLayers is how many layers the registry already had, so were skipped or mounted.
+	ExistingLayers int
+	// Bytes is the size of the uploaded layers, when the builder reports it.
+	Bytes   int64
+	Elapsed time.Duration
+}
+
+func (p PushProgress) String() string {
+	parts := []string{fmt.Sprintf("%d layers pushed", p.Layers)}
+
+	if p.ExistingLayers > 0 {
+		parts = append(parts, fmt.Sprintf("%d already existed", p.ExistingLayers))
+	}
+
+	if p.Bytes > 0 {
+		parts = append(parts, formatBytes(p.Bytes))
+	}
+
+	return fmt.Sprintf("%s in %s", strings.Join(parts, ", "), p.Elapsed.Round(100*time.Millisecond))
+}
+
+// IsTransient reports whether a command failed with an error of the registry or network that is likely to pass
+// when retried, such as a 502, rather than an error such as being denied access.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	text := err.Error()
+
+	if commandErr, ok := err.(*CommandError); ok {
+		text += "\n" + strings.Join(commandErr.Tail, "\n")
+	}
+
+	return transientErrorRegex.MatchString(text)
+}
+
+type layerProgress struct {
+	pushed   bool
+	existing bool
+	bytes    int64
+}
+
+// progressWriter parses the output of a push line by line, keeping the state of each layer.
+type progressWriter struct {
+	buf    []byte
+	layers map[string]*layerProgress
+	order  []string
+	lock   sync.Mutex
+}
+
+func newProgressWriter() *progressWriter {
+	return &progressWriter{layers: map[string]*layerProgress{}}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := strings.IndexAny(string(w.buf), "\r\n")
+
+		if i < 0 {
+			return len(p), nil
+		}
+
+		w.parse(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+func (w *progressWriter) layer(id string) *layerProgress {
+	if layer, ok := w.layers[id]; ok {
+		return layer
+	}
+
+	w.layers[id] = &layerProgress{}
+	w.order = append(w.order, id)
+
+	return w.layers[id]
+}
+
+func (w *progressWriter) parse(line string) {
+	line = strings.TrimSpace(line)
+
+	if match := dockerLayerRegex.FindStringSubmatch(line); match != nil {
+		layer := w.layer(match[1])
+
+		switch match[2] {
+		case "Pushed":
+			layer.pushed = true
+		case "Pushing":
+			layer.bytes = maxInt64(layer.bytes, parseTotalSize(line))
+		default:
+			layer.existing = true
+		}
+
+		return
+	}
+
+	if match := copyingBlobRegex.FindStringSubmatch(line); match != nil {
+		layer := w.layer(match[1])
+		status := match[2]
+
+		switch {
+		case strings.Contains(status, "skipped") || strings.Contains(status, "already exists"):
+			layer.existing = true
+		case strings.Contains(status, "done"):
+			layer.pushed = true
+		}
+
+		layer.bytes = maxInt64(layer.bytes, parseTotalSize(status))
+	}
+}
+
+// Progress returns the summary of the output written so far.
+func (w *progressWriter) Progress(elapsed time.Duration) PushProgress {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	progress := PushProgress{Elapsed: elapsed}
+
+	for _, id := range w.order {
+		layer := w.layers[id]
+
+		switch {
+		case layer.existing:
+			progress.ExistingLayers++
+		case layer.pushed:
+			progress.Layers++
+			progress.Bytes += layer.bytes
+		}
+	}
+
+	return progress
+}
+
+// push runs a push command, summarising its progress from the output.
+func (r Runner) push(name string, cmd *exec.Cmd, output bool) (PushProgress, error) {
+	progress := newProgressWriter()
+	start := time.Now()
+
+	err := r.runWith(name, cmd, output, progress)
+
+	return progress.Progress(time.Since(start)), err
+}
+
+// parseTotalSize returns the total of a "1.2MB/3.4MB" style progress, in bytes.
+func parseTotalSize(text string) int64 {
+	match := sizeRegex.FindStringSubmatch(text)
+
+	if match == nil {
+		return 0
+	}
+
+	value, err := strconv.ParseFloat(match[3], 64)
+
+	if err != nil {
+		return 0
+	}
+
+	return int64(value * sizeUnits[match[4]])
+}
+
+// formatBytes formats a size the same way as docker, eg, 12.3MB.
+func formatBytes(bytes int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(bytes)
+	unit := 0
+
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%dB", bytes)
+	}
+
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
+
+func maxInt64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,66 @@
+package docker
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressWriterParsesDockerOutput(t *testing.T) {
+	w := newProgressWriter()
+
+	w.Write([]byte(`The push refers to repository [registry.example.com/app]
+5f70bf18a086: Preparing
+a1b2c3d4e5f6: Preparing
+0123456789ab: Preparing
+5f70bf18a086: Pushing [=====>      ]  1.2MB/2.5MB
+5f70bf18a086: Pushed
+a1b2c3d4e5f6: Layer already exists
+0123456789ab: Mounted from library/alpine
+v1: digest: sha256:abc size: 942
+`))
+
+	assert.Equal(t, PushProgress{Layers: 1, ExistingLayers: 2, Bytes: 2500000, Elapsed: time.Second}, w.Progress(time.Second))
+}
+
+func TestProgressWriterParsesPodmanOutput(t *testing.T) {
+	w := newProgressWriter()
+
+	w.Write([]byte("Getting image source signatures\nCopying blob sha256:5f70bf18a086 done\r"))
+	w.Write([]byte("\nCopying blob sha256:a1b2c3d4e5f6 skipped: already exists\nCopying blob 0123456789ab done  3.0MiB / 3.0MiB\n"))
+	w.Write([]byte("Copying config sha256:ffff done\nWriting manifest to image destination\n"))
+
+	assert.Equal(t, PushProgress{Layers: 2, ExistingLayers: 1, Bytes: 3 << 20}, w.Progress(0))
+}
+
+func TestPushProgressString(t *testing.T) {
+	assert.Equal(t, "1 layers pushed, 2 already existed, 2.5MB in 1.2s", PushProgress{Layers: 1, ExistingLayers: 2, Bytes: 2500000, Elapsed: 1234 * time.Millisecond}.String())
+	assert.Equal(t, "3 layers pushed in 0s", PushProgress{Layers: 3}.String())
+	assert.Equal(t, "512B", formatBytes(512))
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.False(t, IsTransient(nil))
+	assert.True(t, IsTransient(errors.New("received unexpected HTTP status: 502 Bad Gateway")))
+	assert.True(t, IsTransient(errors.New("unexpected status code 503: unable to reach the registry")))
+	assert.True(t, IsTransient(errors.New("StatusCode: 504, HTTP/1.1 504")))
+	assert.True(t, IsTransient(errors.New("HTTP/2 429")))
+	assert.False(t, IsTransient(errors.New("5f70bf18a086: size: 502 digest: sha256:abc")))
+	assert.False(t, IsTransient(errors.New("manifest unknown: 500 layers")))
+	assert.True(t, IsTransient(&CommandError{Err: errors.New("exit status 1"), Tail: []string{"net/http: TLS handshake timeout"}}))
+	assert.False(t, IsTransient(&CommandError{Err: errors.New("exit status 1"), Tail: []string{"denied: requested access to the resource is denied"}}))
+}
+
+func TestRunnerPushSummarisesProgress(t *testing.T) {
+	runner := Runner{}
+
+	progress, err := runner.push("app", exec.Command("sh", "-c", "echo '5f70bf18a086: Pushed'; echo 'a1b2c3d4e5f6: Layer already exists'"), false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, progress.Layers)
+	assert.Equal(t, 1, progress.ExistingLayers)
+	assert.True(t, progress.Elapsed > 0)
+}
@@ -0,0 +1,167 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// DefaultTailLines is how many lines of output are kept to show when a command fails.
+const DefaultTailLines = 20
+
+// Runner runs the commands of a builder, streaming their output line by line as it is written.
+type Runner struct {
+	// Output receives the verbose output of the commands, each line prefixed with the build name.
+	// Defaults to stdout.
+	Output io.Writer
+	// Log receives the full output of every command, without prefixes, if set. It is written a whole line at a
+	// time, so that concurrent commands can share it.
+	Log io.Writer
+	// TailLines is how many lines of output a CommandError keeps, defaulting to DefaultTailLines.
+	TailLines int
+}
+
+// CommandError is returned when a command fails, holding the last lines of its output.
+type CommandError struct {
+	Err  error
+	Tail []string
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+// Run runs a command, writing its arguments and output when output is true.
+//
+// Without output, the last lines are still returned within a CommandError if the command fails.
+func (r Runner) Run(name string, cmd *exec.Cmd, output bool) error {
+	return r.runWith(name, cmd, output)
+}
+
+// runWith runs a command as Run does, also writing its output to the given writers.
+func (r Runner) runWith(name string, cmd *exec.Cmd, output bool, extra ...io.Writer) error {
+	w := r.output()
+
+	tailLines := r.TailLines
+
+	if tailLines <= 0 {
+		tailLines = DefaultTailLines
+	}
+
+	prefixed := newPrefixWriter(w, fmt.Sprintf("===> [%s]    ", name))
+	tail := newTailWriter(tailLines)
+	writers := append([]io.Writer{tail}, extra...)
+
+	if output {
+		prefixed.Write([]byte(fmt.Sprintf("Running: %s\n", strings.Join(cmd.Args, " "))))
+		writers = append(writers, prefixed)
+	}
+
+	var log *prefixWriter
+
+	if r.Log != nil {
+		log = newPrefixWriter(r.Log, "")
+		log.Write([]byte(fmt.Sprintf("$ %s\n", strings.Join(cmd.Args, " "))))
+		writers = append(writers, log)
+	}
+
+	stream := io.MultiWriter(writers...)
+
+	cmd.Stdout = stream
+	cmd.Stderr = stream
+
+	err := cmd.Run()
+
+	prefixed.Flush()
+
+	if log != nil {
+		log.Flush()
+	}
+
+	if err != nil {
+		return &CommandError{Err: err, Tail: tail.Lines()}
+	}
+
+	return nil
+}
+
+func (r Runner) output() io.Writer {
+	if r.Output == nil {
+		return os.Stdout
+	}
+
+	return r.Output
+}
+
+func (r Runner) run(name string, binary string, args []string, output bool) error {
+	return r.Run(name, exec.Command(binary, args...), output)
+}
+
+// DefaultSSHAgent forwards the ssh agent of SSH_AUTH_SOCK into a build, rather than a key.
+const DefaultSSHAgent = "default"
+
+// dockerBuildArgs returns the build command line shared by the docker compatible builders.
+func dockerBuildArgs(command []string, context string, tags []string, buildArgs map[string]string, labels map[string]string, secrets []Secret, ssh string, target string, cacheFrom string, file string) []string {
+	args := append([]string{}, command...)
+
+	if len(target) > 0 {
+		args = append(args, fmt.Sprintf("--target=%s", target))
+	}
+
+	for _, tag := range tags {
+		args = append(args, fmt.Sprintf("--tag=%s", tag))
+	}
+
+	for _, arg := range sortedKeys(buildArgs) {
+		args = append(args, fmt.Sprintf("--build-arg=%s=%s", arg, buildArgs[arg]))
+	}
+
+	for _, label := range sortedKeys(labels) {
+		args = append(args, fmt.Sprintf("--label=%s=%s", label, labels[label]))
+	}
+
+	for _, secret := range secrets {
+		if len(secret.File) > 0 {
+			args = append(args, fmt.Sprintf("--secret=id=%s,src=%s", secret.ID, secret.File))
+		} else {
+			args = append(args, fmt.Sprintf("--secret=id=%s,env=%s", secret.ID, secret.Env))
+		}
+	}
+
+	if ssh == DefaultSSHAgent {
+		args = append(args, "--ssh=default")
+	} else if len(ssh) > 0 {
+		args = append(args, fmt.Sprintf("--ssh=default=%s", ssh))
+	}
+
+	if len(cacheFrom) > 0 {
+		args = append(args, fmt.Sprintf("--cache-from=%s", cacheFrom))
+	}
+
+	if len(file) > 0 {
+		args = append(args, fmt.Sprintf("--file=%s", file))
+	}
+
+	if len(context) == 0 {
+		args = append(args, ".")
+	} else {
+		args = append(args, context)
+	}
+
+	return args
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := []string{}
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,65 @@
+package docker
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+
+	w := newPrefixWriter(&out, "> ")
+
+	w.Write([]byte("one\ntw"))
+	w.Write([]byte("o\r\nthree"))
+
+	assert.Equal(t, "> one\n> two\n", out.String())
+
+	w.Flush()
+
+	assert.Equal(t, "> one\n> two\n> three\n", out.String())
+}
+
+func TestTailWriter(t *testing.T) {
+	w := newTailWriter(2)
+
+	w.Write([]byte("one\ntwo\nthr"))
+	w.Write([]byte("ee\n"))
+
+	assert.Equal(t, []string{"two", "three"}, w.Lines())
+
+	w.Write([]byte("four"))
+
+	assert.Equal(t, []string{"three", "four"}, w.Lines())
+}
+
+func TestRunnerStreamsOutput(t *testing.T) {
+	var out, log bytes.Buffer
+
+	runner := Runner{Output: &out, Log: &log}
+
+	err := runner.Run("app", exec.Command("sh", "-c", "echo one; echo two"), true)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "===> [app]    Running: sh -c echo one; echo two\n===> [app]    one\n===> [app]    two\n", out.String())
+	assert.Equal(t, "$ sh -c echo one; echo two\none\ntwo\n", log.String())
+}
+
+func TestRunnerKeepsTheTailOfFailedCommands(t *testing.T) {
+	var out bytes.Buffer
+
+	runner := Runner{Output: &out, TailLines: 2}
+
+	err := runner.Run("app", exec.Command("sh", "-c", "echo one; echo two; echo three; exit 1"), false)
+
+	assert.Equal(t, "", out.String())
+
+	commandErr, ok := err.(*CommandError)
+
+	assert.True(t, ok)
+	assert.Equal(t, []string{"two", "three"}, commandErr.Tail)
+	assert.EqualError(t, err, "exit status 1")
+}
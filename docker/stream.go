@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// outputLock is shared by every prefixed writer, so that lines from concurrent builds are never interleaved.
+var outputLock sync.Mutex
+
+// prefixWriter writes each complete line to the underlying writer with a prefix.
+type prefixWriter struct {
+	writer io.Writer
+	prefix string
+	buffer bytes.Buffer
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{writer: w, prefix: prefix}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+
+	for {
+		line, err := w.buffer.ReadString('\n')
+
+		if err != nil {
+			// Keep the partial line until the rest of it is written.
+			w.buffer.Reset()
+			w.buffer.WriteString(line)
+			break
+		}
+
+		if err := w.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (w *prefixWriter) writeLine(line string) error {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+
+	_, err := io.WriteString(w.writer, w.prefix+strings.TrimRight(line, "\r\n")+"\n")
+
+	return err
+}
+
+// Flush writes any partial line left at the end of the output.
+func (w *prefixWriter) Flush() error {
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+
+	line := w.buffer.String()
+	w.buffer.Reset()
+
+	return w.writeLine(line)
+}
+
+// tailWriter keeps the last lines written to it.
+type tailWriter struct {
+	size    int
+	lines   []string
+	partial string
+}
+
+func newTailWriter(size int) *tailWriter {
+	return &tailWriter{size: size}
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	lines := strings.Split(w.partial+string(p), "\n")
+
+	w.partial = lines[len(lines)-1]
+
+	for _, line := range lines[:len(lines)-1] {
+		w.add(strings.TrimRight(line, "\r"))
+	}
+
+	return len(p), nil
+}
+
+func (w *tailWriter) add(line string) {
+	w.lines = append(w.lines, line)
+
+	if len(w.lines) > w.size {
+		w.lines = w.lines[len(w.lines)-w.size:]
+	}
+}
+
+// Lines returns the last lines written, including any partial line.
+func (w *tailWriter) Lines() []string {
+	lines := append([]string{}, w.lines...)
+
+	if len(w.partial) > 0 {
+		lines = append(lines, w.partial)
+
+		if len(lines) > w.size {
+			lines = lines[len(lines)-w.size:]
+		}
+	}
+
+	return lines
+}
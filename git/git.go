@@ -0,0 +1,144 @@
+// Package git reads the state of the git repository a project is built from.
+package git
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// branchEnvVars are checked, in order, for the branch when HEAD is detached, as it is within most CI systems.
+var branchEnvVars = []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME", "BRANCH_NAME", "TRAVIS_BRANCH"}
+
+var scpRemoteRegex = regexp.MustCompile(`^[^/@:]+@([^/:]+):(.+)$`)
+
+// Info describes the commit checked out within a repository.
+type Info struct {
+	SHA      string
+	ShortSHA string
+	// Branch is empty if HEAD is detached and no CI variable gives the branch.
+	Branch string
+	// Tag is the tag pointing at the commit, if any.
+	Tag   string
+	Dirty bool
+	// Remote is the URL of the origin remote, without any credentials, or empty if there is none.
+	Remote string
+}
+
+// Read reads the commit checked out within the repository containing dir.
+func Read(dir string) (Info, error) {
+	info := Info{}
+
+	sha, err := run(dir, "rev-parse", "HEAD")
+
+	if err != nil {
+		return info, err
+	}
+
+	info.SHA = sha
+	info.ShortSHA, _ = run(dir, "rev-parse", "--short=7", "HEAD")
+	info.Tag, _ = run(dir, "describe", "--tags", "--exact-match", "HEAD")
+
+	if branch, err := run(dir, "rev-parse", "--abbrev-ref", "HEAD"); err == nil && branch != "HEAD" {
+		info.Branch = branch
+	} else {
+		for _, name := range branchEnvVars {
+			if branch := os.Getenv(name); len(branch) > 0 {
+				info.Branch = branch
+				break
+			}
+		}
+	}
+
+	if remote, err := run(dir, "config", "--get", "remote.origin.url"); err == nil {
+		info.Remote = cleanRemote(remote)
+	}
+
+	status, err := run(dir, "status", "--porcelain")
+
+	if err != nil {
+		return info, err
+	}
+
+	info.Dirty = len(status) > 0
+
+	return info, nil
+}
+
+// ChangedFiles returns the absolute paths of the files that differ between the working tree and ref, including
+// untracked files.
+func ChangedFiles(dir string, ref string) ([]string, error) {
+	// The root is found relative to dir, rather than with --show-toplevel, so that the paths are not resolved
+	// through any symlinks and match the paths of the config.
+	cdup, err := run(dir, "rev-parse", "--show-cdup")
+
+	if err != nil {
+		return nil, err
+	}
+
+	absDir, err := filepath.Abs(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Join(absDir, cdup)
+
+	changed, err := run(dir, "diff", "--name-only", "--no-renames", ref, "--")
+
+	if err != nil {
+		return nil, err
+	}
+
+	untracked, err := run(dir, "ls-files", "--others", "--exclude-standard", "--full-name")
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+
+	for _, file := range strings.Split(changed+"\n"+untracked, "\n") {
+		if len(file) > 0 {
+			files = append(files, filepath.Join(root, file))
+		}
+	}
+
+	return files, nil
+}
+
+// cleanRemote turns a remote into a URL that is safe to publish, removing any credentials and using https in
+// place of scp style ssh remotes. (eg, git@github.com:org/repo.git becomes https://github.com/org/repo)
+func cleanRemote(remote string) string {
+	if match := scpRemoteRegex.FindStringSubmatch(remote); match != nil {
+		remote = fmt.Sprintf("https://%s/%s", match[1], match[2])
+	}
+
+	if u, err := url.Parse(remote); err == nil && len(u.Host) > 0 {
+		u.User = nil
+		remote = u.String()
+	}
+
+	return strings.TrimSuffix(remote, ".git")
+}
+
+func run(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.Output()
+
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+
+		return "", fmt.Errorf("git %s: %s", args[0], err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
@@ -110,7 +110,7 @@ require (
 	gopkg.in/gorethink/gorethink.v4 v4.1.0 // indirect
 	gopkg.in/ory-am/dockertest.v2 v2.2.3 // indirect
 	gopkg.in/square/go-jose.v2 v2.2.2 // indirect
-	gopkg.in/yaml.v2 v2.2.2
+	gopkg.in/yaml.v3 v3.0.1
 	k8s.io/api v0.0.0-20190206011303-7d75eb91fcfa // indirect
 	k8s.io/apimachinery v0.0.0-20190205091131-4b4ea28f2790 // indirect
 	k8s.io/klog v0.1.0 // indirect
@@ -0,0 +1,246 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileBackend stores locks as files within a directory, which may be shared between machines.
+type FileBackend struct {
+	Dir string
+}
+
+// NewFileBackend creates a file backend storing locks within dir.
+func NewFileBackend(dir string) *FileBackend {
+	return &FileBackend{Dir: dir}
+}
+
+// Acquire takes the lock for key.
+//
+// An expired lock is taken over under a marker named after the expired lock, so that of the processes racing to
+// take it over only one removes it, and a new lock can never be removed in its place.
+func (b *FileBackend) Acquire(key string, holder Holder, ttl time.Duration) (*Lock, error) {
+	if err := os.MkdirAll(b.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	lock, err := newLock(key, holder, ttl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A second attempt is only made after removing an expired lock.
+	for attempt := 0; attempt < 2; attempt++ {
+		err := b.create(lock)
+
+		if err == nil {
+			return lock, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		existing, err := b.Read(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if existing == nil {
+			continue
+		}
+
+		if !existing.Expired(time.Now()) {
+			return nil, &LockedError{Lock: existing}
+		}
+
+		if err := b.takeOver(existing); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("unable to acquire lock %s", key)
+}
+
+// Renew extends the ttl of a lock that is still held.
+func (b *FileBackend) Renew(lock *Lock, ttl time.Duration) error {
+	err := b.withMarker(lock, func() error {
+		existing, err := b.Read(lock.Key)
+
+		if err != nil {
+			return err
+		}
+
+		if existing == nil || existing.ID != lock.ID {
+			return fmt.Errorf("lock %s is no longer held", lock.Key)
+		}
+
+		renewed := *lock
+		renewed.ExpiresAt = time.Now().UTC().Add(ttl)
+
+		temp, err := b.writeTemp(&renewed)
+
+		if err != nil {
+			return err
+		}
+
+		if err := os.Rename(temp, b.path(lock.Key)); err != nil {
+			os.Remove(temp)
+			return err
+		}
+
+		lock.ExpiresAt = renewed.ExpiresAt
+
+		return nil
+	})
+
+	if os.IsExist(err) {
+		return fmt.Errorf("lock %s expired and is being taken over", lock.Key)
+	}
+
+	return err
+}
+
+// Release gives up the given lock if it is still held.
+func (b *FileBackend) Release(lock *Lock) error {
+	err := b.withMarker(lock, func() error {
+		existing, err := b.Read(lock.Key)
+
+		if err != nil {
+			return err
+		}
+
+		if existing == nil || existing.ID != lock.ID {
+			return nil
+		}
+
+		return b.remove(lock.Key)
+	})
+
+	// The lock expired and is being taken over, so it is no longer ours to release.
+	if os.IsExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Read returns the lock for key, or nil if it is not held.
+func (b *FileBackend) Read(key string) (*Lock, error) {
+	data, err := ioutil.ReadFile(b.path(key))
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{}
+
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// ForceRelease removes the lock for key, along with any marker left by a process that died while taking it over.
+func (b *FileBackend) ForceRelease(key string) error {
+	markers, _ := filepath.Glob(b.path(key) + ".*.takeover")
+
+	for _, marker := range markers {
+		os.Remove(marker)
+	}
+
+	return b.remove(key)
+}
+
+// takeOver removes an expired lock, unless another process is already taking it over or it has changed.
+func (b *FileBackend) takeOver(expired *Lock) error {
+	err := b.withMarker(expired, func() error {
+		existing, err := b.Read(expired.Key)
+
+		if err != nil || existing == nil || existing.ID != expired.ID {
+			return err
+		}
+
+		return b.remove(expired.Key)
+	})
+
+	if os.IsExist(err) {
+		return &LockedError{Lock: expired}
+	}
+
+	return err
+}
+
+// withMarker runs fn while holding the marker of a lock, which guards changes to that lock against each other.
+// It fails with an os.IsExist error if another process holds the marker.
+func (b *FileBackend) withMarker(lock *Lock, fn func() error) error {
+	marker := b.path(lock.Key) + "." + lock.ID + ".takeover"
+
+	file, err := os.OpenFile(marker, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	file.Close()
+	defer os.Remove(marker)
+
+	return fn()
+}
+
+// create writes the lock only if there is no lock for its key. The lock is written to a temporary file first and
+// linked into place, so a reader never sees a partly written lock.
+func (b *FileBackend) create(lock *Lock) error {
+	temp, err := b.writeTemp(lock)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp)
+
+	return os.Link(temp, b.path(lock.Key))
+}
+
+func (b *FileBackend) writeTemp(lock *Lock) (string, error) {
+	data, err := json.MarshalIndent(lock, "", "  ")
+
+	if err != nil {
+		return "", err
+	}
+
+	temp := b.path(lock.Key) + "." + lock.ID + ".tmp"
+
+	if err := ioutil.WriteFile(temp, data, 0644); err != nil {
+		os.Remove(temp)
+		return "", err
+	}
+
+	return temp, nil
+}
+
+func (b *FileBackend) remove(key string) error {
+	err := os.Remove(b.path(key))
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (b *FileBackend) path(key string) string {
+	return filepath.Join(b.Dir, strings.Replace(key, "/", ".", -1)+".lock")
+}
@@ -0,0 +1,131 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFileBackend(t *testing.T) (*FileBackend, func()) {
+	dir, err := ioutil.TempDir("", "tent-lock")
+
+	assert.Nil(t, err)
+
+	return NewFileBackend(dir), func() { os.RemoveAll(dir) }
+}
+
+func TestFileBackendAcquireAndRelease(t *testing.T) {
+	backend, cleanUp := newTestFileBackend(t)
+	defer cleanUp()
+
+	holder := Holder{User: "test", Hostname: "ci", PID: 1, Command: "deploy"}
+
+	lock, err := backend.Acquire("staging/my-job", holder, time.Minute)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "staging/my-job", lock.Key)
+
+	existing, err := backend.Read("staging/my-job")
+
+	assert.Nil(t, err)
+	assert.Equal(t, lock.ID, existing.ID)
+	assert.Equal(t, holder, existing.Holder)
+
+	_, err = backend.Acquire("staging/my-job", holder, time.Minute)
+
+	assert.IsType(t, &LockedError{}, err)
+
+	assert.Nil(t, backend.Release(lock))
+
+	existing, err = backend.Read("staging/my-job")
+
+	assert.Nil(t, err)
+	assert.Nil(t, existing)
+}
+
+func TestFileBackendAcquireExpiredLock(t *testing.T) {
+	backend, cleanUp := newTestFileBackend(t)
+	defer cleanUp()
+
+	holder := Holder{User: "test", Hostname: "ci", PID: 1, Command: "deploy"}
+
+	expired, err := backend.Acquire("staging", holder, -time.Minute)
+
+	assert.Nil(t, err)
+
+	lock, err := backend.Acquire("staging", holder, time.Minute)
+
+	assert.Nil(t, err)
+	assert.NotEqual(t, expired.ID, lock.ID)
+
+	// Releasing the expired lock must not remove the new holder's lock.
+	assert.Nil(t, backend.Release(expired))
+
+	existing, err := backend.Read("staging")
+
+	assert.Nil(t, err)
+	assert.Equal(t, lock.ID, existing.ID)
+}
+
+func TestFileBackendTakesOverAnExpiredLockOnce(t *testing.T) {
+	backend, cleanUp := newTestFileBackend(t)
+	defer cleanUp()
+
+	holder := Holder{User: "test", Hostname: "ci", PID: 1, Command: "deploy"}
+
+	_, err := backend.Acquire("staging", holder, -time.Minute)
+
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+
+	acquired := []*Lock{}
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if lock, err := backend.Acquire("staging", holder, time.Minute); err == nil {
+				mutex.Lock()
+				acquired = append(acquired, lock)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 1, len(acquired))
+
+	existing, err := backend.Read("staging")
+
+	assert.Nil(t, err)
+	assert.Equal(t, acquired[0].ID, existing.ID)
+}
+
+func TestFileBackendRenew(t *testing.T) {
+	backend, cleanUp := newTestFileBackend(t)
+	defer cleanUp()
+
+	holder := Holder{User: "test", Hostname: "ci", PID: 1, Command: "deploy"}
+
+	lock, err := backend.Acquire("staging", holder, time.Millisecond)
+
+	assert.Nil(t, err)
+	assert.Nil(t, backend.Renew(lock, time.Hour))
+
+	existing, err := backend.Read("staging")
+
+	assert.Nil(t, err)
+	assert.False(t, existing.Expired(time.Now()))
+	assert.Equal(t, lock.ExpiresAt.Unix(), existing.ExpiresAt.Unix())
+
+	assert.Nil(t, backend.ForceRelease("staging"))
+	assert.EqualError(t, backend.Renew(lock, time.Hour), "lock staging is no longer held")
+}
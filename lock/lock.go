@@ -0,0 +1,94 @@
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// Backend stores deploy locks.
+type Backend interface {
+	// Acquire takes the lock for key, failing with a LockedError if another holder has it.
+	Acquire(key string, holder Holder, ttl time.Duration) (*Lock, error)
+	// Renew extends the ttl of a lock previously returned by Acquire, failing if it is no longer held.
+	Renew(lock *Lock, ttl time.Duration) error
+	// Release gives up a lock previously returned by Acquire.
+	Release(lock *Lock) error
+	// Read returns the current holder of the lock for key, or nil if it is not held.
+	Read(key string) (*Lock, error)
+	// ForceRelease removes the lock for key regardless of who holds it.
+	ForceRelease(key string) error
+}
+
+// Holder describes who is holding a lock.
+type Holder struct {
+	User     string `json:"user"`
+	Hostname string `json:"hostname"`
+	PID      int    `json:"pid"`
+	Command  string `json:"command"`
+}
+
+// Lock is a held lock.
+type Lock struct {
+	ID         string    `json:"id"`
+	Key        string    `json:"key"`
+	Holder     Holder    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// LockedError is returned when a lock is already held by someone else.
+type LockedError struct {
+	Lock *Lock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("lock %s is held by %s until %s", e.Lock.Key, e.Lock.Holder, e.Lock.ExpiresAt.Format(time.RFC3339))
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("%s@%s (pid %d, %s)", h.User, h.Hostname, h.PID, h.Command)
+}
+
+// Expired reports whether the lock has passed its ttl.
+func (l *Lock) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && now.After(l.ExpiresAt)
+}
+
+// CurrentHolder describes the running process as a lock holder.
+func CurrentHolder(command string) Holder {
+	holder := Holder{
+		User:    os.Getenv("USER"),
+		PID:     os.Getpid(),
+		Command: command,
+	}
+
+	if u, err := user.Current(); err == nil {
+		holder.User = u.Username
+	}
+
+	holder.Hostname, _ = os.Hostname()
+
+	return holder
+}
+
+func newLock(key string, holder Holder, ttl time.Duration) (*Lock, error) {
+	id := make([]byte, 8)
+
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	return &Lock{
+		ID:         hex.EncodeToString(id),
+		Key:        key,
+		Holder:     holder,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}, nil
+}
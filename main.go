@@ -23,14 +23,23 @@ func Run(args []string) int {
 	return RunCustom(args)
 }
 
+// commandsWithoutConfig can be run without a valid config file.
+var commandsWithoutConfig = map[string]bool{
+	"config schema": true,
+}
+
+// commandsWithoutBanner print output meant for other tools, so the version banner is left out.
+var commandsWithoutBanner = map[string]bool{
+	"config schema": true,
+	"config show":   true,
+	"generate":      true,
+}
+
 // RunCustom runs the custom setup cli app.
 func RunCustom(args []string) int {
-	conf, err := config.LoadFromFile("tent.yaml")
+	configFile, args := configFileFromArgs(args)
 
-	if err != nil {
-		log.Print("Error with  \"tent.yaml\" file in current directory.")
-		log.Fatalf("err: %s", err)
-	}
+	conf, configErr := config.LoadFromFile(configFile)
 
 	commands := command.Commands(conf)
 
@@ -45,7 +54,15 @@ func RunCustom(args []string) int {
 		HelpWriter:                 os.Stdout,
 	}
 
-	fmt.Println("Running Tent Version: 1.0.8")
+	// Help and version output do not need a config, so tent can be used outside of a project.
+	if configErr != nil && cli.Subcommand() != "" && !cli.IsHelp() && !commandsWithoutConfig[cli.Subcommand()] {
+		log.Printf("Error with %q config file.", configFile)
+		log.Fatalf("err: %s", configErr)
+	}
+
+	if !commandsWithoutBanner[cli.Subcommand()] {
+		fmt.Println("Running Tent Version: 1.0.8")
+	}
 
 	exitCode, err := cli.Run()
 
@@ -57,13 +74,49 @@ func RunCustom(args []string) int {
 	return exitCode
 }
 
+// configFileFromArgs finds the config file to use and removes the -config flag from the args.
+//
+// The -config flag takes precedence over the TENT_CONFIG environment variable, which takes precedence over tent.yaml.
+func configFileFromArgs(args []string) (string, []string) {
+	configFile := "tent.yaml"
+
+	if env := os.Getenv("TENT_CONFIG"); len(env) > 0 {
+		configFile = env
+	}
+
+	remaining := []string{}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			remaining = append(remaining, args[i:]...)
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+
+		switch {
+		case arg != name && name == "config" && i+1 < len(args):
+			configFile = args[i+1]
+			i++
+		case arg != name && strings.HasPrefix(name, "config="):
+			configFile = strings.TrimPrefix(name, "config=")
+		default:
+			remaining = append(remaining, arg)
+		}
+	}
+
+	return configFile, remaining
+}
+
 func groupedHelpFunc(f cli.HelpFunc) cli.HelpFunc {
 	return func(commands map[string]cli.CommandFactory) string {
 		var b bytes.Buffer
 
 		tw := tabwriter.NewWriter(&b, 0, 2, 6, ' ', 0)
 
-		fmt.Fprintf(tw, "Usage: tent [-version] [-help] [-verbose] [-autocomplete-(un)install] <command> [args]\n\n")
+		fmt.Fprintf(tw, "Usage: tent [-version] [-help] [-verbose] [-config=] [-autocomplete-(un)install] <command> [args]\n\n")
 		fmt.Fprintf(tw, "Common commands:\n")
 		for k := range commands {
 			printCommand(tw, k, commands[k])
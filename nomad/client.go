@@ -14,10 +14,18 @@ type Client interface {
 
 	// Job
 	ParseJob(hcl string) (*nomad.Job, error)
+	ValidateJob(*nomad.Job) (*nomad.JobValidateResponse, error)
 	UpdateJob(*nomad.Job) (*nomad.JobRegisterResponse, error)
 	GetLatestDeployment(name string) (*nomad.Deployment, error)
-	StopJob(ID string, purge bool) error
+	StopJob(ID string, purge bool) (string, error)
 	ReadJob(ID string) (*nomad.Job, error)
+	ReadJobAllocations(ID string) ([]*nomad.AllocationListStub, error)
+	ListJobs(prefix string) ([]*nomad.JobListStub, error)
+	ListNamespaceJobs(namespace string) ([]*nomad.JobListStub, error)
+	ReadNamespaceJob(namespace string, ID string) (*nomad.Job, error)
+
+	// Namespace
+	ListNamespaces() ([]string, error)
 }
 
 // DefaultClient is the default nomad client.
@@ -25,7 +33,7 @@ type DefaultClient struct {
 	Address string
 	Client  *nomad.Client
 
-	httpRetryAttempts int
+	retryPolicy RetryPolicy
 }
 
 // NewDefaultClient creates a new client for the given address.
@@ -39,8 +47,8 @@ func NewDefaultClient(addr string, httpRetryAttempts int) (*DefaultClient, error
 	}
 
 	return &DefaultClient{
-		Address: addr,
-		Client:  client,
-		httpRetryAttempts: httpRetryAttempts,
+		Address:     addr,
+		Client:      client,
+		retryPolicy: DefaultRetryPolicy(httpRetryAttempts),
 	}, nil
 }
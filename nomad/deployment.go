@@ -7,11 +7,11 @@ import (
 // ReadDeployment returns the data for a given deployment id.
 func (c *DefaultClient) ReadDeployment(ID string) (*nomad.Deployment, error) {
 	var deployment *nomad.Deployment
-	var err error
 
-	for retries := 0; retries <= c.httpRetryAttempts && err == nil; retries++ {
+	err := c.retryPolicy.retry(true, func() (err error) {
 		deployment, _, err = c.Client.Deployments().Info(ID, nil)
-	}
+		return err
+	})
 
 	if err != nil {
 		return &nomad.Deployment{}, err
@@ -7,11 +7,11 @@ import (
 // ReadEvaluation reads the requested evaluation.
 func (c *DefaultClient) ReadEvaluation(ID string) (*nomad.Evaluation, error) {
 	var eval *nomad.Evaluation
-	var err error
 
-	for retries := 0; retries <= c.httpRetryAttempts && err == nil; retries++ {
+	err := c.retryPolicy.retry(true, func() (err error) {
 		eval, _, err = c.Client.Evaluations().Info(ID, nil)
-	}
+		return err
+	})
 
 	if err != nil {
 		return &nomad.Evaluation{}, err
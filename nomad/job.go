@@ -2,17 +2,18 @@ package nomad
 
 import (
 	"errors"
+
 	nomad "github.com/hashicorp/nomad/api"
 )
 
 // ParseJob takes a hcl job file and converts it to json.
 func (c *DefaultClient) ParseJob(hcl string) (*nomad.Job, error) {
 	var job *nomad.Job
-	var err error
 
-	for retries := 0; retries <= c.httpRetryAttempts && err == nil; retries++ {
+	err := c.retryPolicy.retry(true, func() (err error) {
 		job, err = c.Client.Jobs().ParseHCL(hcl, false)
-	}
+		return err
+	})
 
 	if err != nil || *job.ID == "" {
 		return nil, err
@@ -21,15 +22,26 @@ func (c *DefaultClient) ParseJob(hcl string) (*nomad.Job, error) {
 	return job, nil
 }
 
-// UpdateJob registers the given job with nomad.
-func (c *DefaultClient) UpdateJob(job *nomad.Job) (*nomad.JobRegisterResponse, error) {
+// ValidateJob asks nomad to validate the given job without registering it.
+func (c *DefaultClient) ValidateJob(job *nomad.Job) (*nomad.JobValidateResponse, error) {
 	var validation *nomad.JobValidateResponse
-	var err error
 
-	for retries := 0; retries <= c.httpRetryAttempts && err == nil; retries++ {
+	err := c.retryPolicy.retry(true, func() (err error) {
 		validation, _, err = c.Client.Jobs().Validate(job, nil)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
+	return validation, nil
+}
+
+// UpdateJob registers the given job with nomad.
+func (c *DefaultClient) UpdateJob(job *nomad.Job) (*nomad.JobRegisterResponse, error) {
+	validation, err := c.ValidateJob(job)
+
 	if err != nil {
 		return nil, err
 	}
@@ -40,9 +52,10 @@ func (c *DefaultClient) UpdateJob(job *nomad.Job) (*nomad.JobRegisterResponse, e
 
 	var registered *nomad.JobRegisterResponse
 
-	for retries := 0; retries <= c.httpRetryAttempts && err == nil; retries++ {
+	err = c.retryPolicy.retry(false, func() (err error) {
 		registered, _, err = c.Client.Jobs().Register(job, nil)
-	}
+		return err
+	})
 
 	if err != nil {
 		return nil, err
@@ -54,11 +67,11 @@ func (c *DefaultClient) UpdateJob(job *nomad.Job) (*nomad.JobRegisterResponse, e
 // GetLatestDeployment returns the most recent deployment for a job.
 func (c *DefaultClient) GetLatestDeployment(name string) (*nomad.Deployment, error) {
 	var deployment *nomad.Deployment
-	var err error
 
-	for retries := 0; retries <= c.httpRetryAttempts && err == nil; retries++ {
+	err := c.retryPolicy.retry(true, func() (err error) {
 		deployment, _, err = c.Client.Jobs().LatestDeployment(name, nil)
-	}
+		return err
+	})
 
 	if err != nil {
 		return nil, err
@@ -67,33 +80,127 @@ func (c *DefaultClient) GetLatestDeployment(name string) (*nomad.Deployment, err
 	return deployment, nil
 }
 
-// StopJob stops a given job.
-func (c *DefaultClient) StopJob(ID string, purge bool) error {
-	var err error
+// StopJob stops a given job, returning the id of the deregistration evaluation.
+//
+// If purge is true the job is also garbage collected by nomad immediately.
+func (c *DefaultClient) StopJob(ID string, purge bool) (string, error) {
+	var evalID string
 
-	for retries := 0; retries <= c.httpRetryAttempts && err == nil; retries++ {
-		_, _, err = c.Client.Jobs().Deregister(ID, false, nil)
-	}
+	err := c.retryPolicy.retry(false, func() (err error) {
+		evalID, _, err = c.Client.Jobs().Deregister(ID, purge, nil)
+		return err
+	})
 
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return evalID, nil
 }
 
 // ReadJob reads a job by id.
 func (c *DefaultClient) ReadJob(ID string) (*nomad.Job, error) {
 	var job *nomad.Job
-	var err error
 
-	for retries := 0; retries <= c.httpRetryAttempts && err == nil; retries++ {
+	err := c.retryPolicy.retry(true, func() (err error) {
 		job, _, err = c.Client.Jobs().Info(ID, nil)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
+	return job, nil
+}
+
+// ReadJobAllocations returns the allocations for a job.
+func (c *DefaultClient) ReadJobAllocations(ID string) ([]*nomad.AllocationListStub, error) {
+	var allocations []*nomad.AllocationListStub
+
+	err := c.retryPolicy.retry(true, func() (err error) {
+		allocations, _, err = c.Client.Jobs().Allocations(ID, false, nil)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return allocations, nil
+}
+
+// ListJobs returns the jobs whose id starts with the given prefix.
+func (c *DefaultClient) ListJobs(prefix string) ([]*nomad.JobListStub, error) {
+	var jobs []*nomad.JobListStub
+
+	err := c.retryPolicy.retry(true, func() (err error) {
+		jobs, _, err = c.Client.Jobs().PrefixList(prefix)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// ListNamespaceJobs returns every job within the given namespace.
+func (c *DefaultClient) ListNamespaceJobs(namespace string) ([]*nomad.JobListStub, error) {
+	var jobs []*nomad.JobListStub
+
+	err := c.retryPolicy.retry(true, func() (err error) {
+		jobs, _, err = c.Client.Jobs().List(&nomad.QueryOptions{Namespace: namespace})
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// ReadNamespaceJob reads a job by id within the given namespace.
+func (c *DefaultClient) ReadNamespaceJob(namespace string, ID string) (*nomad.Job, error) {
+	var job *nomad.Job
+
+	err := c.retryPolicy.retry(true, func() (err error) {
+		job, _, err = c.Client.Jobs().Info(ID, &nomad.QueryOptions{Namespace: namespace})
+		return err
+	})
+
 	if err != nil {
 		return nil, err
 	}
 
 	return job, nil
 }
+
+// ListNamespaces returns the names of every namespace, or only the default namespace when nomad does not support
+// namespaces.
+func (c *DefaultClient) ListNamespaces() ([]string, error) {
+	var namespaces []*nomad.Namespace
+
+	err := c.retryPolicy.retry(true, func() (err error) {
+		namespaces, _, err = c.Client.Namespaces().List(nil)
+		return err
+	})
+
+	if IsNotFound(err) {
+		return []string{nomad.DefaultNamespace}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+
+	for _, namespace := range namespaces {
+		names = append(names, namespace.Name)
+	}
+
+	return names, nil
+}
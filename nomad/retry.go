@@ -0,0 +1,168 @@
+package nomad
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures how failed requests to nomad are retried.
+type RetryPolicy struct {
+	// Attempts is the number of retries made after the first request fails.
+	Attempts int
+	// InitialDelay is the delay before the first retry, doubled after each attempt.
+	InitialDelay time.Duration
+	// MaxDelay caps the delay between any two attempts.
+	MaxDelay time.Duration
+	// Deadline is the total time allowed for a request, including all retries.
+	Deadline time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy used for the given number of attempts.
+func DefaultRetryPolicy(attempts int) RetryPolicy {
+	return RetryPolicy{
+		Attempts:     attempts,
+		InitialDelay: time.Millisecond * 250,
+		MaxDelay:     time.Second * 5,
+		Deadline:     time.Second * 30,
+	}
+}
+
+var retrySleep = time.Sleep
+
+var responseCodeRegex = regexp.MustCompile(`^Unexpected response code: (\d{3})`)
+
+// retry runs fn until it succeeds, returns a permanent error or the policy is exhausted.
+//
+// Requests that are not idempotent are only retried when they never reached nomad, so
+// a job is never registered or deregistered twice.
+func (p RetryPolicy) retry(idempotent bool, fn func() error) error {
+	start := time.Now()
+	delay := p.InitialDelay
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= p.Attempts || !isTransientError(err) {
+			return err
+		}
+
+		if !idempotent && !isUnsentError(err) {
+			return err
+		}
+
+		wait := withJitter(delay)
+
+		if p.Deadline > 0 && time.Since(start)+wait > p.Deadline {
+			return err
+		}
+
+		retrySleep(wait)
+
+		delay *= 2
+
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// withJitter returns a random duration between half and all of the given delay.
+func withJitter(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+
+	half := int64(delay) / 2
+
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// isTransientError reports whether a request that failed with err may succeed if retried.
+func isTransientError(err error) bool {
+	if code, ok := responseCode(err); ok {
+		return code >= 500 || code == 429
+	}
+
+	err = unwrapURLError(err)
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+
+	if _, ok := err.(*net.OpError); ok {
+		return true
+	}
+
+	return isConnectionError(err)
+}
+
+// IsNotFound reports whether a request failed because nomad has no such object, eg, the job does not exist.
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	code, ok := responseCode(err)
+
+	return ok && code == 404
+}
+
+// isUnsentError reports whether err happened before the request reached nomad.
+func isUnsentError(err error) bool {
+	if opErr, ok := unwrapURLError(err).(*net.OpError); ok {
+		return opErr.Op == "dial"
+	}
+
+	return false
+}
+
+// responseCode extracts the http status code from an error returned by the nomad api.
+func responseCode(err error) (int, bool) {
+	matches := responseCodeRegex.FindStringSubmatch(err.Error())
+
+	if len(matches) != 2 {
+		return 0, false
+	}
+
+	code, convErr := strconv.Atoi(matches[1])
+
+	return code, convErr == nil
+}
+
+func unwrapURLError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+
+	return err
+}
+
+func isConnectionError(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+
+	switch err {
+	case syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EPIPE:
+		return true
+	}
+
+	msg := err.Error()
+
+	return strings.Contains(msg, "connection refused") || strings.Contains(msg, "connection reset")
+}
@@ -0,0 +1,150 @@
+package nomad
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// skipRetrySleep stops retries from sleeping, returning a function that restores the sleep.
+func skipRetrySleep() func() {
+	sleep := retrySleep
+	retrySleep = func(time.Duration) {}
+
+	return func() { retrySleep = sleep }
+}
+
+func testRetryPolicy(attempts int) RetryPolicy {
+	return RetryPolicy{
+		Attempts:     attempts,
+		InitialDelay: time.Millisecond,
+		MaxDelay:     time.Millisecond * 4,
+		Deadline:     time.Minute,
+	}
+}
+
+func TestRetryDoesNotRepeatSuccessfulCalls(t *testing.T) {
+	defer skipRetrySleep()()
+
+	calls := 0
+
+	err := testRetryPolicy(5).retry(true, func() error {
+		calls++
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryRetriesServerErrors(t *testing.T) {
+	defer skipRetrySleep()()
+
+	calls := 0
+
+	err := testRetryPolicy(5).retry(true, func() error {
+		calls++
+
+		if calls < 3 {
+			return errors.New("Unexpected response code: 502 (bad gateway)")
+		}
+
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryStopsAfterAttempts(t *testing.T) {
+	defer skipRetrySleep()()
+
+	calls := 0
+
+	err := testRetryPolicy(2).retry(true, func() error {
+		calls++
+		return errors.New("Unexpected response code: 500 (error)")
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryDoesNotRetryClientErrors(t *testing.T) {
+	defer skipRetrySleep()()
+
+	calls := 0
+
+	err := testRetryPolicy(5).retry(true, func() error {
+		calls++
+		return errors.New("Unexpected response code: 400 (invalid job)")
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryOnlyRetriesUnsentNonIdempotentCalls(t *testing.T) {
+	defer skipRetrySleep()()
+
+	calls := 0
+
+	err := testRetryPolicy(5).retry(false, func() error {
+		calls++
+		return errors.New("Unexpected response code: 503 (unavailable)")
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+
+	err = testRetryPolicy(5).retry(false, func() error {
+		calls++
+
+		if calls < 2 {
+			return &url.Error{Op: "Put", URL: "http://nomad", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+		}
+
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRetryRespectsDeadline(t *testing.T) {
+	defer skipRetrySleep()()
+
+	policy := testRetryPolicy(5)
+	policy.InitialDelay = time.Second
+	policy.Deadline = time.Millisecond
+
+	calls := 0
+
+	err := policy.retry(true, func() error {
+		calls++
+		return errors.New("Unexpected response code: 500 (error)")
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestIsTransientError(t *testing.T) {
+	assert.True(t, isTransientError(errors.New("Unexpected response code: 504 (timeout)")))
+	assert.True(t, isTransientError(&url.Error{Op: "Get", URL: "http://nomad", Err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}}))
+	assert.False(t, isTransientError(errors.New("Unexpected response code: 404 (job not found)")))
+	assert.False(t, isTransientError(errors.New("something else")))
+}
+
+func TestIsNotFound(t *testing.T) {
+	assert.True(t, IsNotFound(errors.New("Unexpected response code: 404 (job not found)")))
+	assert.False(t, IsNotFound(errors.New("Unexpected response code: 403 (Permission denied)")))
+	assert.False(t, IsNotFound(errors.New("connection refused")))
+	assert.False(t, IsNotFound(nil))
+}
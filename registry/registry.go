@@ -0,0 +1,525 @@
+// Package registry talks to the HTTP API of a docker registry, to list and delete the tags of an image.
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestMediaTypes are accepted when reading a manifest, so that the registry does not convert it to an older
+// schema, which would change its digest.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+}
+
+var linkRegex = regexp.MustCompile(`<([^>]+)>;\s*rel="?next"?`)
+var challengeParamRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Registry lists and deletes the tags of the images within a registry.
+type Registry interface {
+	// Tags returns the tags of a repository, eg, team/app.
+	Tags(repository string) ([]string, error)
+	// Manifest reads the manifest a tag points at.
+	Manifest(repository string, tag string) (Manifest, error)
+	// Delete deletes a manifest, and so every tag pointing at it.
+	Delete(repository string, digest string) error
+}
+
+// Manifest describes the image a tag points at.
+type Manifest struct {
+	Digest string
+	// Created is when the image was built, or zero if the image does not say.
+	Created time.Time
+}
+
+// Client talks to a registry through its HTTP API.
+//
+// Unless set, the username and password are taken from TENT_REGISTRY_USERNAME and TENT_REGISTRY_PASSWORD, or from
+// the auths within the docker config, when the registry asks for them.
+type Client struct {
+	// Address is the URL of the registry. Without a scheme https is used, except for localhost.
+	Address  string
+	Username string
+	Password string
+	Client   *http.Client
+
+	tokens map[string]string
+	lock   sync.Mutex
+}
+
+type manifestBody struct {
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Manifests []struct {
+		Digest string `json:"digest"`
+	} `json:"manifests"`
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// NewClient creates a client for the registry at the given address, eg, registry.example.com.
+func NewClient(address string) *Client {
+	return &Client{Address: address}
+}
+
+// SplitImage splits the registry_url and name of a build into the address of the registry and the repository
+// within it, as the registry_url may include a path, eg, registry.example.com/team.
+func SplitImage(registryURL string, name string) (string, string) {
+	registryURL = strings.Trim(registryURL, "/")
+
+	scheme := ""
+
+	if i := strings.Index(registryURL, "://"); i >= 0 {
+		scheme, registryURL = registryURL[:i+3], registryURL[i+3:]
+	}
+
+	parts := strings.SplitN(registryURL, "/", 2)
+
+	if len(parts) == 2 {
+		return scheme + parts[0], parts[1] + "/" + name
+	}
+
+	return scheme + parts[0], name
+}
+
+// Tags returns the tags of a repository, following the pages of the list.
+func (c *Client) Tags(repository string) ([]string, error) {
+	tags := []string{}
+	next := "/v2/" + repository + "/tags/list"
+
+	for len(next) > 0 {
+		resp, body, err := c.do(http.MethodGet, next, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, responseError(resp, body, "listing the tags of "+repository)
+		}
+
+		page := struct {
+			Tags []string `json:"tags"`
+		}{}
+
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("unable to parse the tags of %s: %s", repository, err)
+		}
+
+		tags = append(tags, page.Tags...)
+		next = ""
+
+		if match := linkRegex.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+			next = match[1]
+		}
+	}
+
+	return tags, nil
+}
+
+// Manifest reads the manifest a tag points at, and when its image was created from the image config.
+//
+// For a multi-platform image the config of the first platform is used.
+func (c *Client) Manifest(repository string, tag string) (Manifest, error) {
+	manifest := Manifest{}
+
+	digest, body, err := c.readManifest(repository, tag)
+
+	if err != nil {
+		return manifest, err
+	}
+
+	manifest.Digest = digest
+
+	if len(body.Config.Digest) == 0 && len(body.Manifests) > 0 {
+		_, body, err = c.readManifest(repository, body.Manifests[0].Digest)
+
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	if len(body.Config.Digest) == 0 {
+		return manifest, nil
+	}
+
+	resp, config, err := c.do(http.MethodGet, "/v2/"+repository+"/blobs/"+body.Config.Digest, nil)
+
+	if err != nil {
+		return manifest, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return manifest, responseError(resp, config, "reading the config of "+repository+":"+tag)
+	}
+
+	created := struct {
+		Created time.Time `json:"created"`
+	}{}
+
+	if err := json.Unmarshal(config, &created); err != nil {
+		return manifest, fmt.Errorf("unable to parse the config of %s:%s: %s", repository, tag, err)
+	}
+
+	manifest.Created = created.Created
+
+	return manifest, nil
+}
+
+// Delete deletes a manifest by its digest.
+func (c *Client) Delete(repository string, digest string) error {
+	resp, body, err := c.do(http.MethodDelete, "/v2/"+repository+"/manifests/"+digest, nil)
+
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNotFound:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return fmt.Errorf("the registry does not allow deleting images, is deletion enabled?")
+	}
+
+	return responseError(resp, body, "deleting "+repository+"@"+digest)
+}
+
+func (c *Client) readManifest(repository string, reference string) (string, manifestBody, error) {
+	manifest := manifestBody{}
+
+	resp, body, err := c.do(http.MethodGet, "/v2/"+repository+"/manifests/"+reference, map[string]string{
+		"Accept": strings.Join(manifestMediaTypes, ", "),
+	})
+
+	if err != nil {
+		return "", manifest, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", manifest, responseError(resp, body, "reading the manifest of "+repository+":"+reference)
+	}
+
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return "", manifest, fmt.Errorf("unable to parse the manifest of %s:%s: %s", repository, reference, err)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+
+	if len(digest) == 0 {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+
+	return digest, manifest, nil
+}
+
+// do sends a request to the registry, authenticating as the registry asks when it responds with a 401.
+//
+// A token is kept for each scope and may expire, so a 401 with a token drops it and the request is sent once
+// more with a fresh one.
+func (c *Client) do(method string, path string, headers map[string]string) (*http.Response, []byte, error) {
+	resp, body, err := c.send(method, path, headers, "")
+
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, body, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+
+	authorization, err := c.authorize(challenge)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, body, err = c.send(method, path, headers, authorization)
+
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !c.forgetToken(challenge, authorization) {
+		return resp, body, err
+	}
+
+	authorization, err = c.authorize(challenge)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c.send(method, path, headers, authorization)
+}
+
+func (c *Client) send(method string, path string, headers map[string]string, authorization string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, c.url(path), nil)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := c.httpClient().Do(req)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	return resp, body, err
+}
+
+// authorize answers the challenge of a registry, either with the credentials directly or with a token for the
+// scope the registry asked for.
+func (c *Client) authorize(challenge string) (string, error) {
+	username, password := c.credentials()
+
+	if strings.HasPrefix(strings.ToLower(challenge), "basic") {
+		if len(username) == 0 {
+			return "", fmt.Errorf("the registry %s needs credentials, login or set TENT_REGISTRY_USERNAME and TENT_REGISTRY_PASSWORD", c.Address)
+		}
+
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	}
+
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer") {
+		return "", fmt.Errorf("the registry %s asked for unsupported authentication: %s", c.Address, challenge)
+	}
+
+	params := challengeParams(challenge)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if token, ok := c.tokens[params["scope"]]; ok {
+		return "Bearer " + token, nil
+	}
+
+	query := url.Values{}
+
+	for _, key := range []string{"service", "scope"} {
+		if len(params[key]) > 0 {
+			query.Set(key, params[key])
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(username) > 0 {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := c.httpClient().Do(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp, body, "authenticating with "+c.Address)
+	}
+
+	token := tokenResponse{}
+
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("unable to parse the token of %s: %s", c.Address, err)
+	}
+
+	if len(token.Token) == 0 {
+		token.Token = token.AccessToken
+	}
+
+	if c.tokens == nil {
+		c.tokens = map[string]string{}
+	}
+
+	c.tokens[params["scope"]] = token.Token
+
+	return "Bearer " + token.Token, nil
+}
+
+// forgetToken drops the token kept for the scope of a bearer challenge, if it is the one that was used, reporting
+// whether it was dropped.
+func (c *Client) forgetToken(challenge string, authorization string) bool {
+	scope := challengeParams(challenge)["scope"]
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	token, ok := c.tokens[scope]
+
+	if !ok || "Bearer "+token != authorization {
+		return false
+	}
+
+	delete(c.tokens, scope)
+
+	return true
+}
+
+// challengeParams returns the parameters of a WWW-Authenticate challenge, eg, realm, service and scope.
+func challengeParams(challenge string) map[string]string {
+	params := map[string]string{}
+
+	for _, match := range challengeParamRegex.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+
+	return params
+}
+
+// credentials returns the username and password to use, from the client, the environment or the docker config.
+func (c *Client) credentials() (string, string) {
+	if len(c.Username) > 0 {
+		return c.Username, c.Password
+	}
+
+	if username := os.Getenv("TENT_REGISTRY_USERNAME"); len(username) > 0 {
+		return username, os.Getenv("TENT_REGISTRY_PASSWORD")
+	}
+
+	dir := os.Getenv("DOCKER_CONFIG")
+
+	if len(dir) == 0 {
+		home, err := os.UserHomeDir()
+
+		if err != nil {
+			return "", ""
+		}
+
+		dir = filepath.Join(home, ".docker")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+
+	if err != nil {
+		return "", ""
+	}
+
+	config := struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}{}
+
+	if json.Unmarshal(data, &config) != nil {
+		return "", ""
+	}
+
+	host := c.host()
+
+	for address, auth := range config.Auths {
+		if address != host && !strings.HasPrefix(address, "https://"+host) && !strings.HasPrefix(address, "http://"+host) {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+
+		if err != nil {
+			continue
+		}
+
+		if parts := strings.SplitN(string(decoded), ":", 2); len(parts) == 2 {
+			return parts[0], parts[1]
+		}
+	}
+
+	return "", ""
+}
+
+func (c *Client) host() string {
+	address := c.Address
+
+	if i := strings.Index(address, "://"); i >= 0 {
+		address = address[i+3:]
+	}
+
+	return strings.TrimRight(address, "/")
+}
+
+// url returns the URL of a path within the registry, or the path itself if the registry gave a full URL, such as
+// within a Link header.
+func (c *Client) url(path string) string {
+	if strings.Contains(path, "://") {
+		return path
+	}
+
+	address := strings.TrimRight(c.Address, "/")
+
+	if !strings.Contains(address, "://") {
+		hostname := strings.Split(address, ":")[0]
+
+		// Like docker, registries on the local machine are spoken to without TLS.
+		if hostname == "localhost" || hostname == "127.0.0.1" {
+			address = "http://" + address
+		} else {
+			address = "https://" + address
+		}
+	}
+
+	return address + path
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.Client == nil {
+		return &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return c.Client
+}
+
+// responseError describes an unexpected response, including the errors the registry gave.
+func responseError(resp *http.Response, body []byte, action string) error {
+	response := struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}{}
+
+	if json.Unmarshal(body, &response) == nil && len(response.Errors) > 0 {
+		messages := []string{}
+
+		for _, e := range response.Errors {
+			messages = append(messages, e.Message)
+		}
+
+		return fmt.Errorf("registry returned %d while %s: %s", resp.StatusCode, action, strings.Join(messages, ", "))
+	}
+
+	return fmt.Errorf("registry returned %d while %s", resp.StatusCode, action)
+}
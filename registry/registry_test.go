@@ -0,0 +1,168 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRegistry stands in for a registry holding team/app, with the tags v1 and latest pointing at the same
+// image, a multi-platform v2, and deletes that need a token.
+func newTestRegistry(t *testing.T, deleted *[]string) *httptest.Server {
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			assert.Equal(t, "repository:team/app:delete", r.URL.Query().Get("scope"))
+			w.Write([]byte(`{"token":"secret-token"}`))
+			return
+		}
+
+		if r.Method == http.MethodDelete && r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:team/app:delete"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "GET /v2/team/app/tags/list":
+			w.Header().Set("Link", `</v2/team/app/tags/list?last=latest&n=2>; rel="next"`)
+			w.Write([]byte(`{"name":"team/app","tags":["v1","latest"]}`))
+		case "GET /v2/team/app/tags/list?last=latest&n=2":
+			w.Write([]byte(`{"name":"team/app","tags":["v2"]}`))
+		case "GET /v2/team/app/manifests/v1", "GET /v2/team/app/manifests/latest":
+			assert.Contains(t, r.Header.Get("Accept"), "application/vnd.docker.distribution.manifest.v2+json")
+			w.Header().Set("Docker-Content-Digest", "sha256:one")
+			w.Write([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:config-one"}}`))
+		case "GET /v2/team/app/manifests/v2":
+			w.Header().Set("Docker-Content-Digest", "sha256:two")
+			w.Write([]byte(`{"schemaVersion":2,"manifests":[{"digest":"sha256:two-amd64"}]}`))
+		case "GET /v2/team/app/manifests/sha256:two-amd64":
+			w.Write([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:config-two"}}`))
+		case "GET /v2/team/app/blobs/sha256:config-one":
+			w.Write([]byte(`{"created":"2026-09-01T10:00:00Z"}`))
+		case "GET /v2/team/app/blobs/sha256:config-two":
+			w.Write([]byte(`{"created":"2026-10-01T10:00:00Z"}`))
+		case "DELETE /v2/team/app/manifests/sha256:one":
+			*deleted = append(*deleted, "sha256:one")
+			w.WriteHeader(http.StatusAccepted)
+		case "DELETE /v2/other/manifests/sha256:one":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"code":"NAME_UNKNOWN","message":"repository name not known to registry"}]}`))
+		}
+	}))
+
+	return server
+}
+
+func TestClientTags(t *testing.T) {
+	server := newTestRegistry(t, nil)
+	defer server.Close()
+
+	tags, err := NewClient(server.URL).Tags("team/app")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"v1", "latest", "v2"}, tags)
+
+	_, err = NewClient(server.URL).Tags("missing")
+
+	assert.EqualError(t, err, "registry returned 404 while listing the tags of missing: repository name not known to registry")
+}
+
+func TestClientManifest(t *testing.T) {
+	server := newTestRegistry(t, nil)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	manifest, err := client.Manifest("team/app", "v1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Manifest{Digest: "sha256:one", Created: time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC)}, manifest)
+
+	manifest, err = client.Manifest("team/app", "v2")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Manifest{Digest: "sha256:two", Created: time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)}, manifest)
+}
+
+func TestClientDeleteAuthenticates(t *testing.T) {
+	deleted := []string{}
+	server := newTestRegistry(t, &deleted)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	assert.Nil(t, client.Delete("team/app", "sha256:one"))
+	assert.Equal(t, []string{"sha256:one"}, deleted)
+
+	err := client.Delete("other", "sha256:one")
+
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "is deletion enabled?"))
+}
+
+func TestClientFetchesAFreshTokenOnceTheTokenExpires(t *testing.T) {
+	var server *httptest.Server
+	issued := 0
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			issued++
+			w.Write([]byte(fmt.Sprintf(`{"token":"token-%d"}`, issued)))
+			return
+		}
+
+		// Only the latest token is valid, as if the earlier ones expired.
+		if r.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%d", issued) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:team/app:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`{"name":"team/app","tags":["v1"]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	tags, err := client.Tags("team/app")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"v1"}, tags)
+	assert.Equal(t, 1, issued)
+
+	// The kept token expires.
+	issued++
+
+	tags, err = client.Tags("team/app")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"v1"}, tags)
+	assert.Equal(t, 3, issued)
+}
+
+func TestSplitImage(t *testing.T) {
+	address, repository := SplitImage("registry.example.com/team/", "app")
+
+	assert.Equal(t, "registry.example.com", address)
+	assert.Equal(t, "team/app", repository)
+
+	address, repository = SplitImage("http://localhost:5000", "app")
+
+	assert.Equal(t, "http://localhost:5000", address)
+	assert.Equal(t, "app", repository)
+}
+
+func TestClientURL(t *testing.T) {
+	assert.Equal(t, "https://registry.example.com/v2/", NewClient("registry.example.com").url("/v2/"))
+	assert.Equal(t, "http://localhost:5000/v2/", NewClient("localhost:5000").url("/v2/"))
+	assert.Equal(t, "http://127.0.0.1/v2/", NewClient("http://127.0.0.1/").url("/v2/"))
+}
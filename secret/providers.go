@@ -0,0 +1,71 @@
+package secret
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// EnvProvider reads secrets from environment variables, eg, env:DATABASE_URL.
+type EnvProvider struct{}
+
+// Resolve returns the value of the environment variable, failing if it is not set.
+func (p EnvProvider) Resolve(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+
+	return value, nil
+}
+
+// FileProvider reads secrets from files, eg, file:/run/secrets/db_password.
+type FileProvider struct{}
+
+// Resolve returns the contents of the file, without a trailing newline.
+func (p FileProvider) Resolve(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// MemoryProvider holds secrets in memory, for use in tests.
+type MemoryProvider struct {
+	Secrets map[string]string
+
+	// Resolved counts how many times each secret was resolved.
+	Resolved map[string]int
+
+	lock sync.Mutex
+}
+
+// NewMemoryProvider creates a provider holding the given secrets.
+func NewMemoryProvider(secrets map[string]string) *MemoryProvider {
+	return &MemoryProvider{
+		Secrets:  secrets,
+		Resolved: map[string]int{},
+	}
+}
+
+// Resolve returns the secret held for path.
+func (p *MemoryProvider) Resolve(path string) (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	value, ok := p.Secrets[path]
+
+	if !ok {
+		return "", fmt.Errorf("secret %s does not exist", path)
+	}
+
+	p.Resolved[path]++
+
+	return value, nil
+}
@@ -0,0 +1,164 @@
+package secret
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultRedactKeys are the key patterns whose values are always redacted.
+var DefaultRedactKeys = []string{"*_token", "*password*", "*secret*"}
+
+// minRedactLength is the shortest value redacted because of its key, so that values such as `1` or `yes`
+// do not hide unrelated output.
+const minRedactLength = 4
+
+// Redactor hides sensitive values within output, replacing them with ****.
+//
+// Values are sensitive if they were added with a key matching one of its patterns, added directly or
+// resolved from a secret provider.
+type Redactor struct {
+	patterns []string
+	values   map[string]bool
+	secrets  *Resolver
+	lock     sync.Mutex
+}
+
+// NewRedactor creates a redactor using the default key patterns and the given patterns, along with every
+// secret resolved by secrets.
+func NewRedactor(patterns []string, secrets *Resolver) *Redactor {
+	all := []string{}
+
+	for _, pattern := range append(append([]string{}, DefaultRedactKeys...), patterns...) {
+		all = append(all, strings.ToLower(pattern))
+	}
+
+	return &Redactor{
+		patterns: all,
+		values:   map[string]bool{},
+		secrets:  secrets,
+	}
+}
+
+// IsSensitiveKey reports whether the key matches one of the patterns, ignoring case.
+func (r *Redactor) IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, pattern := range r.patterns {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddValue marks the value as sensitive.
+func (r *Redactor) AddValue(value string) {
+	if len(value) < minRedactLength || r.secrets.IsReference(value) {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.values[value] = true
+}
+
+// AddValues marks the values of every key matching one of the patterns as sensitive.
+func (r *Redactor) AddValues(values map[string]string) {
+	for key, value := range values {
+		if r.IsSensitiveKey(key) {
+			r.AddValue(value)
+		}
+	}
+}
+
+// Redact replaces every sensitive value within s.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	r.lock.Lock()
+
+	values := r.secrets.Secrets()
+
+	for value := range r.values {
+		values = append(values, value)
+	}
+
+	r.lock.Unlock()
+
+	// Longer values are replaced first so that a value containing another is fully redacted.
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	for _, value := range values {
+		s = strings.Replace(s, value, Mask, -1)
+	}
+
+	return s
+}
+
+// Writer returns a writer that redacts everything written to w.
+//
+// Output is redacted a line at a time, so that a value split across writes is still redacted. Flush writes
+// out a partial line left at the end of the output.
+func (r *Redactor) Writer(w io.Writer) *RedactedWriter {
+	return &RedactedWriter{writer: w, redactor: r}
+}
+
+// RedactedWriter redacts each line written to it before writing it to the underlying writer. It is safe to use
+// from several goroutines, although lines written at once should be written whole to stay intact.
+type RedactedWriter struct {
+	writer   io.Writer
+	redactor *Redactor
+	buffer   bytes.Buffer
+	lock     sync.Mutex
+}
+
+// Write redacts and writes each complete line of p, reporting the length of p so that callers do not see a
+// short write.
+func (w *RedactedWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buffer.Write(p)
+
+	end := bytes.LastIndexByte(w.buffer.Bytes(), '\n')
+
+	if end < 0 {
+		// Keep the partial line until the rest of it is written.
+		return len(p), nil
+	}
+
+	lines := string(w.buffer.Next(end + 1))
+
+	if _, err := io.WriteString(w.writer, w.redactor.Redact(lines)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush redacts and writes any partial line left at the end of the output.
+func (w *RedactedWriter) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+
+	line := w.buffer.String()
+	w.buffer.Reset()
+
+	_, err := io.WriteString(w.writer, w.redactor.Redact(line))
+
+	return err
+}
@@ -0,0 +1,76 @@
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactorSensitiveKeys(t *testing.T) {
+	redactor := NewRedactor([]string{"*_dsn"}, nil)
+
+	for _, key := range []string{"api_token", "DB_PASSWORD", "password", "client_secret", "sentry_dsn"} {
+		assert.True(t, redactor.IsSensitiveKey(key), key)
+	}
+
+	for _, key := range []string{"token_ttl", "log_level", "dsn_host"} {
+		assert.False(t, redactor.IsSensitiveKey(key), key)
+	}
+}
+
+func TestRedactorRedactsValues(t *testing.T) {
+	resolver := NewResolver(map[string]Provider{"mem": NewMemoryProvider(map[string]string{"key": "resolved-secret"})})
+	redactor := NewRedactor(nil, resolver)
+
+	redactor.AddValues(map[string]string{
+		"api_token":   "abcdef",
+		"db_password": "abcdefgh",
+		"short_token": "1",
+		"vault_token": "mem:key",
+		"log_level":   "debug",
+	})
+
+	resolver.Resolve("mem:key")
+
+	assert.Equal(t, "**** **** 1 debug **** mem:key", redactor.Redact("abcdefgh abcdef 1 debug resolved-secret mem:key"))
+}
+
+func TestRedactorWriter(t *testing.T) {
+	redactor := NewRedactor(nil, nil)
+	redactor.AddValue("hunter2")
+
+	var out bytes.Buffer
+
+	n, err := fmt.Fprintln(redactor.Writer(&out), "--build-arg=\"PASSWORD=hunter2\"")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 31, n)
+	assert.Equal(t, "--build-arg=\"PASSWORD=****\"\n", out.String())
+}
+
+func TestRedactorWriterRedactsValuesSplitAcrossWrites(t *testing.T) {
+	redactor := NewRedactor(nil, nil)
+	redactor.AddValue("hunter2")
+
+	var out bytes.Buffer
+
+	w := redactor.Writer(&out)
+
+	w.Write([]byte("PASSWORD=hun"))
+	w.Write([]byte("ter2\nnext hunt"))
+
+	assert.Equal(t, "PASSWORD=****\n", out.String())
+
+	w.Write([]byte("er2"))
+
+	assert.Nil(t, w.Flush())
+	assert.Equal(t, "PASSWORD=****\nnext ****", out.String())
+}
+
+func TestNilRedactor(t *testing.T) {
+	var redactor *Redactor
+
+	assert.Equal(t, "output", redactor.Redact("output"))
+}
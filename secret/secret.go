@@ -0,0 +1,143 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask is the text that replaces every sensitive value within output.
+const Mask = "****"
+
+// Provider resolves a secret from the path of a reference, eg, NAME for env:NAME.
+type Provider interface {
+	Resolve(path string) (string, error)
+}
+
+// Resolver resolves secret references such as vault:secret/data/app#password, file:/run/secrets/x and env:NAME.
+//
+// Every resolved secret is remembered so that it can be redacted from output. A nil Resolver leaves references as they are.
+type Resolver struct {
+	providers map[string]Provider
+	cache     map[string]string
+	secrets   map[string]bool
+	lock      sync.Mutex
+}
+
+// NewResolver creates a resolver using the given providers, keyed by the scheme of their references.
+func NewResolver(providers map[string]Provider) *Resolver {
+	return &Resolver{
+		providers: providers,
+		cache:     map[string]string{},
+		secrets:   map[string]bool{},
+	}
+}
+
+// DefaultProviders returns the vault, file and env providers.
+func DefaultProviders() map[string]Provider {
+	return map[string]Provider{
+		"vault": &VaultProvider{},
+		"file":  FileProvider{},
+		"env":   EnvProvider{},
+	}
+}
+
+// IsReference reports whether the value refers to a secret of one of the providers.
+func (r *Resolver) IsReference(value string) bool {
+	if r == nil {
+		return false
+	}
+
+	_, _, ok := r.parse(value)
+
+	return ok
+}
+
+func (r *Resolver) parse(value string) (Provider, string, bool) {
+	parts := strings.SplitN(value, ":", 2)
+
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		return nil, "", false
+	}
+
+	provider, ok := r.providers[parts[0]]
+
+	return provider, parts[1], ok
+}
+
+// Resolve returns the secret that value refers to, or value itself if it is not a reference.
+func (r *Resolver) Resolve(value string) (string, error) {
+	if r == nil {
+		return value, nil
+	}
+
+	provider, path, ok := r.parse(value)
+
+	if !ok {
+		return value, nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if resolved, ok := r.cache[value]; ok {
+		return resolved, nil
+	}
+
+	resolved, err := provider.Resolve(path)
+
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve secret %s: %s", value, err)
+	}
+
+	r.cache[value] = resolved
+
+	if len(resolved) > 0 {
+		r.secrets[resolved] = true
+
+		// Secrets are also redacted when they appear within json output.
+		encoded, _ := json.Marshal(resolved)
+		r.secrets[strings.Trim(string(encoded), `"`)] = true
+	}
+
+	return resolved, nil
+}
+
+// ResolveMap resolves every value of the map, returning a new map.
+func (r *Resolver) ResolveMap(values map[string]string) (map[string]string, error) {
+	resolved := map[string]string{}
+
+	for key, value := range values {
+		secret, err := r.Resolve(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		resolved[key] = secret
+	}
+
+	return resolved, nil
+}
+
+// Secrets returns every secret resolved so far.
+func (r *Resolver) Secrets() []string {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	secrets := []string{}
+
+	for secret := range r.secrets {
+		secrets = append(secrets, secret)
+	}
+
+	sort.Strings(secrets)
+
+	return secrets
+}
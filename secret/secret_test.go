@@ -0,0 +1,98 @@
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolverResolvesReferences(t *testing.T) {
+	memory := NewMemoryProvider(map[string]string{"secret/data/app#password": "hunter2"})
+	resolver := NewResolver(map[string]Provider{"vault": memory})
+
+	value, err := resolver.Resolve("vault:secret/data/app#password")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	value, err = resolver.Resolve("vault:secret/data/app#password")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "hunter2", value)
+	assert.Equal(t, 1, memory.Resolved["secret/data/app#password"])
+}
+
+func TestResolverLeavesPlainValues(t *testing.T) {
+	resolver := NewResolver(map[string]Provider{"vault": NewMemoryProvider(map[string]string{})})
+
+	for _, value := range []string{"plain", "https://example.com", "vault:", "unknown:thing"} {
+		resolved, err := resolver.Resolve(value)
+
+		assert.Nil(t, err)
+		assert.Equal(t, value, resolved)
+		assert.False(t, resolver.IsReference(value))
+	}
+
+	assert.True(t, resolver.IsReference("vault:a#b"))
+}
+
+func TestResolverErrors(t *testing.T) {
+	resolver := NewResolver(map[string]Provider{"vault": NewMemoryProvider(map[string]string{})})
+
+	_, err := resolver.Resolve("vault:missing#key")
+
+	assert.EqualError(t, err, "unable to resolve secret vault:missing#key: secret missing#key does not exist")
+}
+
+func TestNilResolver(t *testing.T) {
+	var resolver *Resolver
+
+	value, err := resolver.Resolve("env:HOME")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "env:HOME", value)
+	assert.Nil(t, resolver.Secrets())
+}
+
+func TestResolverRemembersResolvedSecrets(t *testing.T) {
+	resolver := NewResolver(map[string]Provider{"mem": NewMemoryProvider(map[string]string{
+		"plain": "abc",
+		"quote": `pa"ss`,
+	})})
+
+	resolver.Resolve("mem:plain")
+	resolver.Resolve("mem:quote")
+
+	assert.Equal(t, []string{"abc", `pa"ss`, `pa\"ss`}, resolver.Secrets())
+}
+
+func TestEnvProvider(t *testing.T) {
+	os.Setenv("TENT_TEST_SECRET", "value")
+	defer os.Unsetenv("TENT_TEST_SECRET")
+
+	value, err := EnvProvider{}.Resolve("TENT_TEST_SECRET")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "value", value)
+
+	_, err = EnvProvider{}.Resolve("TENT_TEST_SECRET_MISSING")
+
+	assert.EqualError(t, err, "environment variable TENT_TEST_SECRET_MISSING is not set")
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tent-secret")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "password")
+	ioutil.WriteFile(path, []byte("hunter2\n"), 0600)
+
+	value, err := FileProvider{}.Resolve(path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "hunter2", value)
+}
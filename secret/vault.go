@@ -0,0 +1,165 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VaultProvider reads secrets from vault, eg, vault:secret/data/app#password.
+//
+// The path is read from vault and the key after the # is returned from its data. Both the kv version 1
+// and version 2 secret engines are supported.
+//
+// Unless set, the address, token and namespace are taken from VAULT_ADDR, VAULT_TOKEN (or ~/.vault-token)
+// and VAULT_NAMESPACE when a secret is first resolved.
+type VaultProvider struct {
+	Address   string
+	Token     string
+	Namespace string
+	Client    *http.Client
+}
+
+type vaultResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+// Resolve reads the secret from vault.
+func (p *VaultProvider) Resolve(reference string) (string, error) {
+	parts := strings.SplitN(reference, "#", 2)
+
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", fmt.Errorf("vault secrets must be given as path#key")
+	}
+
+	path, key := strings.Trim(parts[0], "/"), parts[1]
+
+	data, err := p.read(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	// The kv version 2 engine nests the secret within data, alongside its metadata.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	value, ok := data[key]
+
+	if !ok {
+		return "", fmt.Errorf("key %s does not exist in %s", key, path)
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	encoded, err := json.Marshal(value)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
+
+func (p *VaultProvider) read(path string) (map[string]interface{}, error) {
+	address := p.Address
+
+	if len(address) == 0 {
+		address = os.Getenv("VAULT_ADDR")
+	}
+
+	if len(address) == 0 {
+		address = "https://127.0.0.1:8200"
+	}
+
+	token, err := p.token()
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(address, "/")+"/v1/"+path, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Vault-Token", token)
+
+	namespace := p.Namespace
+
+	if len(namespace) == 0 {
+		namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+
+	if len(namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	client := p.Client
+
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := vaultResponse{}
+
+	if resp.StatusCode != http.StatusOK {
+		if json.Unmarshal(body, &response) == nil && len(response.Errors) > 0 {
+			return nil, fmt.Errorf("vault returned %d for %s: %s", resp.StatusCode, path, strings.Join(response.Errors, ", "))
+		}
+
+		return nil, fmt.Errorf("vault returned %d for %s", resp.StatusCode, path)
+	}
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("unable to parse the vault response for %s: %s", path, err)
+	}
+
+	return response.Data, nil
+}
+
+func (p *VaultProvider) token() (string, error) {
+	if len(p.Token) > 0 {
+		return p.Token, nil
+	}
+
+	if token := os.Getenv("VAULT_TOKEN"); len(token) > 0 {
+		return token, nil
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err == nil {
+		if data, err := ioutil.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+			return strings.TrimSpace(string(data)), nil
+		}
+	}
+
+	return "", fmt.Errorf("no vault token, set VAULT_TOKEN or login with vault")
+}
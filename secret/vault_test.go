@@ -0,0 +1,72 @@
+package secret
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestVault(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/app":
+			w.Write([]byte(`{"data":{"data":{"password":"v2-secret","port":5432},"metadata":{"version":1}}}`))
+		case "/v1/kv/app":
+			w.Write([]byte(`{"data":{"password":"v1-secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+func TestVaultProvider(t *testing.T) {
+	server := newTestVault(t)
+	defer server.Close()
+
+	provider := &VaultProvider{Address: server.URL, Token: "token"}
+
+	value, err := provider.Resolve("secret/data/app#password")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "v2-secret", value)
+
+	value, err = provider.Resolve("secret/data/app#port")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "5432", value)
+
+	value, err = provider.Resolve("kv/app#password")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "v1-secret", value)
+}
+
+func TestVaultProviderErrors(t *testing.T) {
+	server := newTestVault(t)
+	defer server.Close()
+
+	provider := &VaultProvider{Address: server.URL, Token: "token"}
+
+	_, err := provider.Resolve("secret/data/app")
+	assert.EqualError(t, err, "vault secrets must be given as path#key")
+
+	_, err = provider.Resolve("secret/data/app#missing")
+	assert.EqualError(t, err, "key missing does not exist in secret/data/app")
+
+	_, err = provider.Resolve("secret/data/missing#key")
+	assert.EqualError(t, err, "vault returned 404 for secret/data/missing")
+
+	provider.Token = "wrong"
+
+	_, err = provider.Resolve("secret/data/app#password")
+	assert.EqualError(t, err, "vault returned 403 for secret/data/app: permission denied")
+}